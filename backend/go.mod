@@ -7,12 +7,33 @@ require (
 	github.com/lib/pq v1.10.9
 )
 
-require github.com/rs/cors v1.11.1 // indirect
+require (
+	github.com/coreos/go-oidc/v3 v3.14.1
+	github.com/rs/cors v1.11.1
+	golang.org/x/oauth2 v0.30.0
+)
+
+require github.com/go-jose/go-jose/v4 v4.0.5 // indirect
+
+require (
+	github.com/fxamacker/cbor/v2 v2.8.0 // indirect
+	github.com/go-webauthn/webauthn v0.13.0
+	github.com/go-webauthn/x v0.1.21 // indirect
+	github.com/google/go-tpm v0.9.5 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mitchellh/mapstructure v1.5.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+)
+
+require (
+	golang.org/x/crypto v0.38.0
+	golang.org/x/sys v0.33.0 // indirect
+)
 
 require (
 	github.com/golang-jwt/jwt/v5 v5.2.2
 	github.com/mfridman/interpolate v0.0.2 // indirect
-	github.com/pressly/goose/v3 v3.24.2 // indirect
+	github.com/pressly/goose/v3 v3.24.2
 	github.com/sethvargo/go-retry v0.3.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/sync v0.12.0 // indirect
@@ -2,24 +2,43 @@ package app
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/bercivarga/website-builder/internal/blocks"
+	"github.com/bercivarga/website-builder/internal/mailer"
 	"github.com/bercivarga/website-builder/internal/models"
 	"github.com/bercivarga/website-builder/internal/services"
 	"github.com/bercivarga/website-builder/internal/utils"
 	"github.com/bercivarga/website-builder/migrations"
 	"github.com/bercivarga/website-builder/pkg/database"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/joho/godotenv"
 )
 
 // Application holds the application state
 type Application struct {
-	DB          *sql.DB
-	Logger      *log.Logger
-	UserService *services.UserService
-	AuthService *services.AuthService
+	DB              *sql.DB
+	Logger          *log.Logger
+	UserService     *services.UserService
+	AuthService     *services.AuthService
+	SessionService  *services.SessionService
+	KeyService      *services.KeyService
+	WebAuthn        *services.WebAuthnService
+	OIDC            *services.OIDCService
+	APITokenService *services.APITokenService
+	Workspaces      *services.WorkspaceService
+	AuditService    *services.AuditService
+	DataExports     *services.DataExportService
+	Deletion        *services.AccountDeletionService
+	Sites           *services.SiteService
+	Pages           *services.PageService
+	Publishing      *services.PublishService
+	TrustedProxies  utils.TrustedProxies
 }
 
 // NewApplication initializes the application with a database connection and logger.
@@ -50,26 +69,244 @@ func NewApplication() (Application, error) {
 	}
 
 	// utils go here
-	authUtils := utils.NewAuthUtils(utils.AuthConfig{
-		SecretKey:         os.Getenv("JWT_SECRET_KEY"),
+	keySet := utils.NewKeySet()
+	authConfig := utils.AuthConfig{
+		Keys:              keySet,
 		RefreshExpiration: time.Hour * 24,
 		TokenExpiration:   time.Hour * 24,
+	}
+	authUtils := utils.NewAuthUtils(authConfig)
+
+	keyRotationInterval, err := durationFromEnv("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour)
+	if err != nil {
+		return Application{}, err
+	}
+
+	deletionGracePeriod, err := durationFromEnv("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour)
+	if err != nil {
+		return Application{}, err
+	}
+
+	passwordHasher, err := utils.NewPasswordHasher(os.Getenv("PASSWORD_HASHER"))
+	if err != nil {
+		return Application{}, err
+	}
+
+	mail, err := mailer.New(mailer.Config{
+		Transport:    os.Getenv("MAIL_TRANSPORT"),
+		From:         os.Getenv("MAIL_FROM"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     os.Getenv("SMTP_PORT"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		OutboxDir:    os.Getenv("MAIL_OUTBOX_DIR"),
 	})
+	if err != nil {
+		return Application{}, err
+	}
+
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:5173"
+	}
+
+	webAuthnOrigins := []string{appURL}
+	if origins := os.Getenv("WEBAUTHN_RP_ORIGINS"); origins != "" {
+		webAuthnOrigins = strings.Split(origins, ",")
+	}
+
+	webAuthnRPID := os.Getenv("WEBAUTHN_RP_ID")
+	if webAuthnRPID == "" {
+		webAuthnRPID = "localhost"
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          webAuthnRPID,
+		RPDisplayName: "Website Builder",
+		RPOrigins:     webAuthnOrigins,
+	})
+	if err != nil {
+		return Application{}, err
+	}
+
+	cookieConfig, err := cookieConfigFromEnv()
+	if err != nil {
+		return Application{}, err
+	}
+
+	oidcProviders, err := oidcProvidersFromEnv(appURL)
+	if err != nil {
+		return Application{}, err
+	}
+
+	trustedProxies, err := utils.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return Application{}, err
+	}
 
 	// stores go here
 	userStore := models.NewUserStore(db)
 	tokenStore := models.NewTokenStore(db)
+	signingKeyStore := models.NewSigningKeyStore(db)
+	sessionStore := models.NewSessionStore(db)
+	auditEventStore := models.NewAuditEventStore(db)
+	totpStore := models.NewTOTPStore(db)
+	recoveryCodeStore := models.NewRecoveryCodeStore(db)
+	mfaChallengeStore := models.NewMFAChallengeStore(db)
+	webAuthnCredentialStore := models.NewWebAuthnCredentialStore(db)
+	webAuthnCeremonyStore := models.NewWebAuthnCeremonyStore(db)
+	oidcStateStore := models.NewOIDCStateStore(db)
+	userIdentityStore := models.NewUserIdentityStore(db)
+	apiTokenStore := models.NewAPITokenStore(db)
+	loginThrottleStore := models.NewLoginThrottleStore(db)
+	workspaceStore := models.NewWorkspaceStore(db)
+	workspaceInvitationStore := models.NewWorkspaceInvitationStore(db)
+	dataExportStore := models.NewDataExportStore(db)
+	siteStore := models.NewSiteStore(db)
+	pageStore := models.NewPageStore(db)
+	pageRevisionStore := models.NewPageRevisionStore(db)
+	sitePublishStore := models.NewSitePublishStore(db)
 
 	// services go here
+	mailService := services.NewMailService(mail, appURL)
 	userService := services.NewUserService(userStore)
-	authService := services.NewAuthService(tokenStore, authUtils, userStore)
+	authService := services.NewAuthService(services.AuthStores{
+		Tokens:         tokenStore,
+		Sessions:       sessionStore,
+		Users:          userStore,
+		AuditEvents:    auditEventStore,
+		TOTP:           totpStore,
+		RecoveryCodes:  recoveryCodeStore,
+		MFAChallenges:  mfaChallengeStore,
+		APITokens:      apiTokenStore,
+		LoginThrottles: loginThrottleStore,
+	}, authUtils, passwordHasher, mailService, cookieConfig, logger)
+	sessionService := services.NewSessionService(sessionStore, authService)
+	apiTokenService := services.NewAPITokenService(apiTokenStore)
+	workspaceService := services.NewWorkspaceService(workspaceStore, workspaceInvitationStore, userStore, mailService, authService)
+	auditService := services.NewAuditService(auditEventStore)
+	siteService := services.NewSiteService(siteStore, workspaceStore)
+	pageService := services.NewPageService(pageStore, pageRevisionStore, blocks.DefaultRegistry())
+	publishService := services.NewPublishService(sitePublishStore, authService)
+	dataExportService := services.NewDataExportService(services.DataExportStores{
+		Exports:     dataExportStore,
+		Users:       userStore,
+		Sessions:    sessionStore,
+		Workspaces:  workspaceStore,
+		APITokens:   apiTokenStore,
+		Identities:  userIdentityStore,
+		Passkeys:    webAuthnCredentialStore,
+		AuditEvents: auditEventStore,
+		Sites:       siteStore,
+		Pages:       pageStore,
+		Revisions:   pageRevisionStore,
+	}, mailService, logger)
+	deletionService := services.NewAccountDeletionService(userStore, workspaceStore, auditEventStore, authService, mailService, logger, deletionGracePeriod)
+	webAuthnService := services.NewWebAuthnService(webAuthn, webAuthnCredentialStore, webAuthnCeremonyStore, userStore, authService)
+	oidcService := services.NewOIDCService(oidcProviders, oidcStateStore, userIdentityStore, userStore, authService)
+	keyService := services.NewKeyService(signingKeyStore, keySet, services.KeyConfig{
+		Algorithm:        os.Getenv("JWT_SIGNING_ALG"),
+		RotationInterval: keyRotationInterval,
+		// Replaced keys must outlive every token they signed
+		GracePeriod: max(authConfig.TokenExpiration, authConfig.RefreshExpiration),
+	}, logger)
+
+	// Tokens cannot be signed until the key set is loaded
+	err = keyService.Sync()
+	if err != nil {
+		return Application{}, err
+	}
+	keyService.Start()
+	dataExportService.Start()
+	deletionService.Start()
 
 	app := Application{
-		DB:          db,
-		Logger:      logger,
-		UserService: userService,
-		AuthService: authService,
+		DB:              db,
+		Logger:          logger,
+		UserService:     userService,
+		AuthService:     authService,
+		SessionService:  sessionService,
+		KeyService:      keyService,
+		WebAuthn:        webAuthnService,
+		OIDC:            oidcService,
+		APITokenService: apiTokenService,
+		Workspaces:      workspaceService,
+		AuditService:    auditService,
+		DataExports:     dataExportService,
+		Deletion:        deletionService,
+		Sites:           siteService,
+		Pages:           pageService,
+		Publishing:      publishService,
+		TrustedProxies:  trustedProxies,
 	}
 
 	return app, nil
 }
+
+// durationFromEnv parses a duration such as "720h" from an environment variable
+func durationFromEnv(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// cookieConfigFromEnv reads the cookie mode settings.
+// AUTH_COOKIES=true enables it; cookies are Secure and SameSite=Lax unless configured otherwise.
+func cookieConfigFromEnv() (services.CookieConfig, error) {
+	config := services.CookieConfig{
+		Enabled:  os.Getenv("AUTH_COOKIES") == "true",
+		Secure:   os.Getenv("AUTH_COOKIE_SECURE") != "false",
+		SameSite: http.SameSiteLaxMode,
+		Domain:   os.Getenv("AUTH_COOKIE_DOMAIN"),
+	}
+
+	switch strings.ToLower(os.Getenv("AUTH_COOKIE_SAMESITE")) {
+	case "", "lax":
+	case "strict":
+		config.SameSite = http.SameSiteStrictMode
+	case "none":
+		config.SameSite = http.SameSiteNoneMode
+	default:
+		return config, fmt.Errorf("unsupported AUTH_COOKIE_SAMESITE: %s", os.Getenv("AUTH_COOKIE_SAMESITE"))
+	}
+
+	return config, nil
+}
+
+// oidcProvidersFromEnv reads the OpenID Connect providers listed in OIDC_PROVIDERS.
+// Each provider NAME is configured with OIDC_NAME_ISSUER, OIDC_NAME_CLIENT_ID,
+// OIDC_NAME_CLIENT_SECRET and optionally OIDC_NAME_REDIRECT_URL and OIDC_NAME_SCOPES.
+func oidcProvidersFromEnv(appURL string) ([]services.OIDCProviderConfig, error) {
+	var providers []services.OIDCProviderConfig
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := services.OIDCProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(strings.ReplaceAll(os.Getenv(prefix+"SCOPES"), ",", " ")),
+		}
+
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %q needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+
+		if provider.RedirectURL == "" {
+			provider.RedirectURL = appURL + "/auth/oidc/" + name + "/callback"
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
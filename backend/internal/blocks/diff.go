@@ -0,0 +1,202 @@
+package blocks
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// Kinds of block changes
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeMoved    = "moved"
+	ChangeModified = "modified"
+)
+
+// Change is a difference between two documents at the level of blocks, which
+// are matched by ID. A block that was both moved and modified has two changes.
+// Blocks added or removed together with their parent are not listed separately.
+type Change struct {
+	Kind    string `json:"kind"`
+	BlockID string `json:"block_id"`
+	Type    string `json:"type"`
+	// Pointer locates the block in the newer document, or in the older one if it was removed
+	Pointer string `json:"pointer"`
+	// FromPointer locates a moved block in the older document
+	FromPointer string       `json:"from_pointer,omitempty"`
+	OldType     string       `json:"old_type,omitempty"` // Set when a modified block changed its type
+	Props       []PropChange `json:"props,omitempty"`    // Props of a modified block that differ
+}
+
+// PropChange is a prop whose value differs between two versions of a block.
+// Old is missing for added props and New for removed ones.
+type PropChange struct {
+	Name string `json:"name"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// placedBlock is a block together with where it sits in its document
+type placedBlock struct {
+	block   *Block
+	parent  string // ID of the parent block, empty at the top level
+	pointer string
+}
+
+// documentIndex finds the blocks of a document by ID and lists the children of each parent in order
+type documentIndex struct {
+	blocks   map[string]placedBlock
+	order    []string            // Block IDs depth-first
+	children map[string][]string // Parent ID to child IDs, "" for the top level
+}
+
+// indexDocument builds the index of a document
+func indexDocument(document *Document) *documentIndex {
+	index := &documentIndex{
+		blocks:   make(map[string]placedBlock),
+		children: make(map[string][]string),
+	}
+	if document != nil {
+		index.add(document.Blocks, "", "/blocks")
+	}
+	return index
+}
+
+// add indexes a list of sibling blocks and their descendants
+func (x *documentIndex) add(blocks []*Block, parent string, pointer string) {
+	for i, block := range blocks {
+		blockPointer := pointer + "/" + strconv.Itoa(i)
+		x.blocks[block.ID] = placedBlock{block: block, parent: parent, pointer: blockPointer}
+		x.order = append(x.order, block.ID)
+		x.children[parent] = append(x.children[parent], block.ID)
+		x.add(block.Children, block.ID, blockPointer+"/children")
+	}
+}
+
+// Diff lists the block changes that turn the older document into the newer one,
+// in the order of the newer document followed by removed blocks in the order of the older one
+func Diff(older *Document, newer *Document) []Change {
+	before := indexDocument(older)
+	after := indexDocument(newer)
+	reordered := reorderedBlocks(before, after)
+
+	changes := []Change{}
+	for _, id := range after.order {
+		current := after.blocks[id]
+		previous, existed := before.blocks[id]
+
+		if !existed {
+			// Descendants of an added block come with it
+			if _, parentExisted := before.blocks[current.parent]; current.parent == "" || parentExisted {
+				changes = append(changes, Change{Kind: ChangeAdded, BlockID: id, Type: current.block.Type, Pointer: current.pointer})
+			}
+			continue
+		}
+
+		if previous.parent != current.parent || reordered[id] {
+			changes = append(changes, Change{Kind: ChangeMoved, BlockID: id, Type: current.block.Type, Pointer: current.pointer, FromPointer: previous.pointer})
+		}
+
+		props := diffProps(previous.block.Props, current.block.Props)
+		if previous.block.Type != current.block.Type || len(props) > 0 {
+			change := Change{Kind: ChangeModified, BlockID: id, Type: current.block.Type, Pointer: current.pointer, Props: props}
+			if previous.block.Type != current.block.Type {
+				change.OldType = previous.block.Type
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	for _, id := range before.order {
+		previous := before.blocks[id]
+		if _, kept := after.blocks[id]; kept {
+			continue
+		}
+		// Descendants of a removed block go with it
+		if _, parentKept := after.blocks[previous.parent]; previous.parent == "" || parentKept {
+			changes = append(changes, Change{Kind: ChangeRemoved, BlockID: id, Type: previous.block.Type, Pointer: previous.pointer})
+		}
+	}
+
+	return changes
+}
+
+// reorderedBlocks finds blocks that stayed with their parent but changed places with
+// their siblings. The longest run of siblings that kept their relative order stays put;
+// everything else counts as moved, so inserting or removing a sibling moves nothing.
+func reorderedBlocks(before *documentIndex, after *documentIndex) map[string]bool {
+	reordered := make(map[string]bool)
+	for parent, newChildren := range after.children {
+		var kept []string
+		for _, id := range newChildren {
+			if previous, ok := before.blocks[id]; ok && previous.parent == parent {
+				kept = append(kept, id)
+			}
+		}
+		var oldKept []string
+		for _, id := range before.children[parent] {
+			if current, ok := after.blocks[id]; ok && current.parent == parent {
+				oldKept = append(oldKept, id)
+			}
+		}
+
+		stayed := longestCommonSubsequence(oldKept, kept)
+		for _, id := range kept {
+			if !stayed[id] {
+				reordered[id] = true
+			}
+		}
+	}
+	return reordered
+}
+
+// longestCommonSubsequence returns the elements of a longest common subsequence of a and b
+func longestCommonSubsequence(a []string, b []string) map[string]bool {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	common := make(map[string]bool)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			common[a[i]] = true
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return common
+}
+
+// diffProps lists the props that differ between two versions of a block, by name
+func diffProps(older map[string]any, newer map[string]any) []PropChange {
+	names := make(map[string]bool, len(older)+len(newer))
+	for name := range older {
+		names[name] = true
+	}
+	for name := range newer {
+		names[name] = true
+	}
+
+	var changes []PropChange
+	for _, name := range sortedKeys(names) {
+		if !reflect.DeepEqual(older[name], newer[name]) {
+			changes = append(changes, PropChange{Name: name, Old: older[name], New: newer[name]})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,153 @@
+package blocks
+
+import (
+	"reflect"
+	"testing"
+)
+
+// block builds a block for a diff test
+func block(id string, blockType string, props map[string]any, children ...*Block) *Block {
+	if props == nil {
+		props = map[string]any{}
+	}
+	return &Block{ID: id, Type: blockType, Props: props, Children: children}
+}
+
+func text(id string, value string) *Block {
+	return block(id, "text", map[string]any{"text": value})
+}
+
+// inOrder returns the elements of s that are in set, in the order of s
+func inOrder(s []string, set map[string]bool) []string {
+	var kept []string
+	for _, id := range s {
+		if set[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+func TestLongestCommonSubsequence(t *testing.T) {
+	tests := []struct {
+		name   string
+		a      []string
+		b      []string
+		length int
+	}{
+		{"empty", nil, nil, 0},
+		{"identical", []string{"a", "b", "c"}, []string{"a", "b", "c"}, 3},
+		{"disjoint", []string{"a", "b"}, []string{"c", "d"}, 0},
+		{"one moved to the end", []string{"a", "b", "c", "d"}, []string{"b", "c", "d", "a"}, 3},
+		{"one moved to the front", []string{"a", "b", "c", "d"}, []string{"d", "a", "b", "c"}, 3},
+		{"pairs swapped", []string{"a", "b", "c", "d", "e"}, []string{"b", "a", "d", "c", "e"}, 3},
+		{"reversed", []string{"a", "b", "c"}, []string{"c", "b", "a"}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			common := longestCommonSubsequence(test.a, test.b)
+			if len(common) != test.length {
+				t.Fatalf("got %v, want %d elements", common, test.length)
+			}
+			// The elements must appear in the same order in both lists
+			if !reflect.DeepEqual(inOrder(test.a, common), inOrder(test.b, common)) || len(inOrder(test.a, common)) != test.length {
+				t.Errorf("%v is not a common subsequence of %v and %v", common, test.a, test.b)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name  string
+		older []*Block
+		newer []*Block
+		want  []Change
+	}{
+		{
+			name:  "unchanged",
+			older: []*Block{text("a", "A"), text("b", "B")},
+			newer: []*Block{text("a", "A"), text("b", "B")},
+			want:  []Change{},
+		},
+		{
+			name:  "inserted at the front",
+			older: []*Block{text("a", "A"), text("b", "B")},
+			newer: []*Block{text("c", "C"), text("a", "A"), text("b", "B")},
+			want:  []Change{{Kind: ChangeAdded, BlockID: "c", Type: "text", Pointer: "/blocks/0"}},
+		},
+		{
+			name:  "removed from the middle",
+			older: []*Block{text("a", "A"), text("b", "B"), text("c", "C")},
+			newer: []*Block{text("a", "A"), text("c", "C")},
+			want:  []Change{{Kind: ChangeRemoved, BlockID: "b", Type: "text", Pointer: "/blocks/1"}},
+		},
+		{
+			name:  "container added with its children",
+			older: []*Block{text("a", "A")},
+			newer: []*Block{text("a", "A"), block("s", "section", nil, text("b", "B"), text("c", "C"))},
+			want:  []Change{{Kind: ChangeAdded, BlockID: "s", Type: "section", Pointer: "/blocks/1"}},
+		},
+		{
+			name:  "container removed with its children",
+			older: []*Block{block("s", "section", nil, text("b", "B")), text("a", "A")},
+			newer: []*Block{text("a", "A")},
+			want:  []Change{{Kind: ChangeRemoved, BlockID: "s", Type: "section", Pointer: "/blocks/0"}},
+		},
+		{
+			name:  "moved to the end",
+			older: []*Block{text("a", "A"), text("b", "B"), text("c", "C")},
+			newer: []*Block{text("b", "B"), text("c", "C"), text("a", "A")},
+			want:  []Change{{Kind: ChangeMoved, BlockID: "a", Type: "text", Pointer: "/blocks/2", FromPointer: "/blocks/0"}},
+		},
+		{
+			name:  "moved into a container",
+			older: []*Block{text("a", "A"), block("s", "section", nil)},
+			newer: []*Block{block("s", "section", nil, text("a", "A"))},
+			want:  []Change{{Kind: ChangeMoved, BlockID: "a", Type: "text", Pointer: "/blocks/0/children/0", FromPointer: "/blocks/0"}},
+		},
+		{
+			name:  "props modified",
+			older: []*Block{block("h", "heading", map[string]any{"text": "Old", "level": int64(1)})},
+			newer: []*Block{block("h", "heading", map[string]any{"text": "New", "align": "center"})},
+			want: []Change{{Kind: ChangeModified, BlockID: "h", Type: "heading", Pointer: "/blocks/0", Props: []PropChange{
+				{Name: "align", New: "center"},
+				{Name: "level", Old: int64(1)},
+				{Name: "text", Old: "Old", New: "New"},
+			}}},
+		},
+		{
+			name:  "type changed",
+			older: []*Block{block("x", "heading", map[string]any{"text": "Hi"})},
+			newer: []*Block{block("x", "text", map[string]any{"text": "Hi"})},
+			want:  []Change{{Kind: ChangeModified, BlockID: "x", Type: "text", Pointer: "/blocks/0", OldType: "heading"}},
+		},
+		{
+			name:  "moved and modified",
+			older: []*Block{text("a", "A"), text("b", "B")},
+			newer: []*Block{text("b", "B"), text("a", "A2")},
+			want: []Change{
+				{Kind: ChangeMoved, BlockID: "a", Type: "text", Pointer: "/blocks/1", FromPointer: "/blocks/0"},
+				{Kind: ChangeModified, BlockID: "a", Type: "text", Pointer: "/blocks/1", Props: []PropChange{{Name: "text", Old: "A", New: "A2"}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Diff(&Document{Version: CurrentVersion, Blocks: test.older}, &Document{Version: CurrentVersion, Blocks: test.newer})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiffAgainstNothing(t *testing.T) {
+	got := Diff(nil, &Document{Version: CurrentVersion, Blocks: []*Block{text("a", "A")}})
+	want := []Change{{Kind: ChangeAdded, BlockID: "a", Type: "text", Pointer: "/blocks/0"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,399 @@
+package blocks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CurrentVersion is the version of the document schema this server writes and accepts
+const CurrentVersion = 1
+
+const (
+	// MaxDepth is how deeply blocks may be nested, counting top-level blocks as 1
+	MaxDepth = 10
+
+	// MaxBlocks is the most blocks a document may contain
+	MaxBlocks = 2000
+
+	// Validation stops reporting after this many errors
+	maxErrors = 100
+
+	defaultMaxStringLength = 1000
+	defaultMaxTextLength   = 20000
+	maxURLLength           = 2048
+	maxBlockIDLength       = 64
+)
+
+var blockIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// pointerEscaper escapes a key for use as a JSON pointer token (RFC 6901)
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// Document is the content of a page: a versioned list of blocks
+type Document struct {
+	Version int      `json:"version"`
+	Blocks  []*Block `json:"blocks"`
+}
+
+// Block is one element of a document. Containers hold further blocks as children.
+type Block struct {
+	ID       string         `json:"id"` // Unique within the document, chosen by the editor
+	Type     string         `json:"type"`
+	Props    map[string]any `json:"props"`
+	Children []*Block       `json:"children,omitempty"`
+}
+
+// ValidationError is a problem with a document at the location given as JSON pointer
+type ValidationError struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+// EmptyDocument returns a document without blocks
+func EmptyDocument() *Document {
+	return &Document{Version: CurrentVersion, Blocks: []*Block{}}
+}
+
+// Parse decodes and validates a document against the registry.
+// It returns the document normalized to the registered prop kinds, or every problem
+// found, up to a limit, each with the JSON pointer of the offending value.
+func (r *Registry) Parse(data []byte) (*Document, []ValidationError) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var root any
+	if err := decoder.Decode(&root); err != nil {
+		return nil, []ValidationError{{Pointer: "", Message: "Invalid JSON"}}
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return nil, []ValidationError{{Pointer: "", Message: "Unexpected data after the document"}}
+	}
+
+	v := &validator{registry: r, ids: make(map[string]string)}
+	document := v.document(root)
+	if len(v.errors) > 0 {
+		return nil, v.errors
+	}
+	return document, nil
+}
+
+// validator collects the errors found while walking a decoded document
+type validator struct {
+	registry *Registry
+	errors   []ValidationError
+	ids      map[string]string // Block ID to the pointer of the block that uses it
+	blocks   int
+}
+
+// fail records a problem at the given pointer
+func (v *validator) fail(pointer string, format string, args ...any) {
+	if len(v.errors) < maxErrors {
+		v.errors = append(v.errors, ValidationError{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// document validates the top level of a document
+func (v *validator) document(value any) *Document {
+	object, ok := value.(map[string]any)
+	if !ok {
+		v.fail("", "Document must be an object")
+		return nil
+	}
+
+	for _, key := range sortedKeys(object) {
+		if key != "version" && key != "blocks" {
+			v.fail("/"+pointerEscaper.Replace(key), "Unknown field")
+		}
+	}
+
+	document := EmptyDocument()
+
+	switch version := object["version"].(type) {
+	case nil:
+		v.fail("/version", "Missing version")
+	case json.Number:
+		if n, err := version.Int64(); err != nil || n != CurrentVersion {
+			v.fail("/version", "Unsupported version %s, expected %d", version, CurrentVersion)
+		}
+	default:
+		v.fail("/version", "Must be an integer")
+	}
+
+	blocks, ok := object["blocks"].([]any)
+	if !ok {
+		if object["blocks"] == nil {
+			v.fail("/blocks", "Missing blocks")
+		} else {
+			v.fail("/blocks", "Must be an array")
+		}
+		return document
+	}
+
+	document.Blocks = v.blockList(blocks, "/blocks", nil, 1)
+	return document
+}
+
+// blockList validates the blocks of a document or the children of a container
+func (v *validator) blockList(values []any, pointer string, parent *BlockType, depth int) []*Block {
+	blocks := make([]*Block, 0, len(values))
+	for i, value := range values {
+		if block := v.block(value, pointer+"/"+strconv.Itoa(i), parent, depth); block != nil {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// block validates a single block and, for containers, its children
+func (v *validator) block(value any, pointer string, parent *BlockType, depth int) *Block {
+	v.blocks++
+	if v.blocks > MaxBlocks {
+		if v.blocks == MaxBlocks+1 {
+			v.fail(pointer, "Too many blocks, at most %d are allowed", MaxBlocks)
+		}
+		return nil
+	}
+
+	object, ok := value.(map[string]any)
+	if !ok {
+		v.fail(pointer, "Block must be an object")
+		return nil
+	}
+
+	for _, key := range sortedKeys(object) {
+		if key != "id" && key != "type" && key != "props" && key != "children" {
+			v.fail(pointer+"/"+pointerEscaper.Replace(key), "Unknown field")
+		}
+	}
+
+	block := &Block{Props: map[string]any{}}
+
+	id, ok := object["id"].(string)
+	switch {
+	case !ok:
+		v.fail(pointer+"/id", "Missing block ID")
+	case id == "" || len(id) > maxBlockIDLength || !blockIDPattern.MatchString(id):
+		v.fail(pointer+"/id", "Block ID must be 1 to %d letters, digits, hyphens or underscores", maxBlockIDLength)
+	default:
+		if other, taken := v.ids[id]; taken {
+			v.fail(pointer+"/id", "Duplicate block ID, also used at %s", other)
+		}
+		v.ids[id] = pointer
+		block.ID = id
+	}
+
+	typeName, ok := object["type"].(string)
+	if !ok {
+		v.fail(pointer+"/type", "Missing block type")
+		return nil
+	}
+	blockType, ok := v.registry.Lookup(typeName)
+	if !ok {
+		v.fail(pointer+"/type", "Unknown block type %q", typeName)
+		return nil
+	}
+	block.Type = typeName
+
+	if parent == nil && !blockType.TopLevel {
+		v.fail(pointer+"/type", "%s blocks cannot be placed at the top level", typeName)
+	}
+	if parent != nil && !allowsChild(parent, blockType) {
+		v.fail(pointer+"/type", "%s blocks cannot be placed in %s blocks", typeName, parent.Name)
+	}
+
+	switch props := object["props"].(type) {
+	case nil:
+		v.props(map[string]any{}, pointer+"/props", blockType, block)
+	case map[string]any:
+		v.props(props, pointer+"/props", blockType, block)
+	default:
+		v.fail(pointer+"/props", "Must be an object")
+	}
+
+	if children, present := object["children"]; present {
+		list, ok := children.([]any)
+		switch {
+		case !blockType.Container:
+			v.fail(pointer+"/children", "%s blocks cannot have children", typeName)
+		case !ok:
+			v.fail(pointer+"/children", "Must be an array")
+		case depth >= MaxDepth && len(list) > 0:
+			v.fail(pointer+"/children", "Blocks are nested too deeply, at most %d levels are allowed", MaxDepth)
+		default:
+			block.Children = v.blockList(list, pointer+"/children", blockType, depth+1)
+		}
+	}
+
+	return block
+}
+
+// props validates the props of a block against its type and stores the normalized values
+func (v *validator) props(props map[string]any, pointer string, blockType *BlockType, block *Block) {
+	for _, name := range sortedKeys(props) {
+		schema, ok := blockType.Props[name]
+		if !ok {
+			v.fail(pointer+"/"+pointerEscaper.Replace(name), "Unknown prop for %s blocks", blockType.Name)
+			continue
+		}
+		if value, ok := v.prop(props[name], pointer+"/"+pointerEscaper.Replace(name), schema); ok {
+			block.Props[name] = value
+		}
+	}
+
+	for _, name := range sortedKeys(blockType.Props) {
+		if _, present := props[name]; !present && blockType.Props[name].Required {
+			v.fail(pointer+"/"+pointerEscaper.Replace(name), "Missing required prop")
+		}
+	}
+}
+
+// prop validates a single prop value and returns it converted to its Go type
+func (v *validator) prop(value any, pointer string, schema PropSchema) (any, bool) {
+	switch schema.Kind {
+	case KindString, KindText:
+		s, ok := value.(string)
+		if !ok {
+			v.fail(pointer, "Must be a string")
+			return nil, false
+		}
+		maxLength := schema.MaxLength
+		if maxLength == 0 {
+			maxLength = defaultMaxStringLength
+			if schema.Kind == KindText {
+				maxLength = defaultMaxTextLength
+			}
+		}
+		if schema.Required && strings.TrimSpace(s) == "" {
+			v.fail(pointer, "Must not be empty")
+			return nil, false
+		}
+		if utf8.RuneCountInString(s) > maxLength {
+			v.fail(pointer, "Must be at most %d characters", maxLength)
+			return nil, false
+		}
+		if schema.Kind == KindString && strings.ContainsAny(s, "\r\n") {
+			v.fail(pointer, "Must be a single line")
+			return nil, false
+		}
+		return s, true
+
+	case KindInteger, KindNumber:
+		number, ok := value.(json.Number)
+		if !ok {
+			v.fail(pointer, "Must be a number")
+			return nil, false
+		}
+		f, err := number.Float64()
+		if err != nil {
+			v.fail(pointer, "Must be a number")
+			return nil, false
+		}
+		if (schema.Min != nil && f < *schema.Min) || (schema.Max != nil && f > *schema.Max) {
+			v.fail(pointer, "Must be %s", describeRange(schema))
+			return nil, false
+		}
+		if schema.Kind == KindInteger {
+			n, err := number.Int64()
+			if err != nil {
+				v.fail(pointer, "Must be an integer")
+				return nil, false
+			}
+			return n, true
+		}
+		return f, true
+
+	case KindBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			v.fail(pointer, "Must be true or false")
+			return nil, false
+		}
+		return b, true
+
+	case KindEnum:
+		s, ok := value.(string)
+		if !ok || !slices.Contains(schema.Values, s) {
+			v.fail(pointer, "Must be one of: %s", strings.Join(schema.Values, ", "))
+			return nil, false
+		}
+		return s, true
+
+	case KindURL, KindLink:
+		s, ok := value.(string)
+		if !ok {
+			v.fail(pointer, "Must be a string")
+			return nil, false
+		}
+		if !validURL(s, schema.Kind == KindLink) {
+			if schema.Kind == KindLink {
+				v.fail(pointer, "Must be an http or https URL, a mailto: or tel: link, a path starting with / or an #anchor")
+			} else {
+				v.fail(pointer, "Must be an http or https URL")
+			}
+			return nil, false
+		}
+		return s, true
+	}
+
+	v.fail(pointer, "Unsupported prop kind %q", schema.Kind)
+	return nil, false
+}
+
+// validURL reports whether s is an absolute http or https URL; links may also be
+// mailto: and tel: links, site paths and anchors. Anything else, like javascript:
+// URLs, is rejected so documents can be rendered without further checks.
+// Browsers read backslashes as slashes, so /\evil.com would leave the site; no URL may contain one.
+func validURL(s string, link bool) bool {
+	if s == "" || len(s) > maxURLLength || strings.Contains(s, `\`) {
+		return false
+	}
+
+	if link && (strings.HasPrefix(s, "#") || (strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//"))) {
+		parsed, err := url.Parse(s)
+		return err == nil && parsed.Scheme == "" && parsed.Host == ""
+	}
+
+	parsed, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		return parsed.Host != ""
+	case "mailto", "tel":
+		return link && parsed.Opaque != ""
+	}
+	return false
+}
+
+// describeRange describes the allowed range of a number prop
+func describeRange(schema PropSchema) string {
+	format := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	switch {
+	case schema.Min != nil && schema.Max != nil:
+		return "between " + format(*schema.Min) + " and " + format(*schema.Max)
+	case schema.Min != nil:
+		return "at least " + format(*schema.Min)
+	default:
+		return "at most " + format(*schema.Max)
+	}
+}
+
+// sortedKeys returns the keys of a map in order, so errors are reported deterministically
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,183 @@
+package blocks
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// document builds the JSON of a current-version document with the given blocks
+func document(t *testing.T, blocks ...any) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"version": CurrentVersion, "blocks": blocks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// nested returns a section holding depth-1 more sections below it, the innermost one a heading
+func nested(depth int) map[string]any {
+	block := map[string]any{"id": "leaf", "type": "heading", "props": map[string]any{"text": "Deep"}}
+	for i := depth - 1; i >= 1; i-- {
+		block = map[string]any{"id": "s" + strconv.Itoa(i), "type": "section", "children": []any{block}}
+	}
+	return block
+}
+
+func TestParseReportsPointer(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		pointer string
+		message string
+	}{
+		{"invalid JSON", `{"version": 1,`, "", "Invalid JSON"},
+		{"trailing data", `{"version": 1, "blocks": []} {}`, "", "Unexpected data after the document"},
+		{"not an object", `[]`, "", "Document must be an object"},
+		{"unknown field escaped", `{"version": 1, "blocks": [], "a/b~c": 1}`, "/a~1b~0c", "Unknown field"},
+		{"missing version", `{"blocks": []}`, "/version", "Missing version"},
+		{"unsupported version", `{"version": 2, "blocks": []}`, "/version", "Unsupported version 2"},
+		{"version not an integer", `{"version": "1", "blocks": []}`, "/version", "Must be an integer"},
+		{"missing blocks", `{"version": 1}`, "/blocks", "Missing blocks"},
+		{"blocks not an array", `{"version": 1, "blocks": {}}`, "/blocks", "Must be an array"},
+		{"block not an object", `{"version": 1, "blocks": [1]}`, "/blocks/0", "Block must be an object"},
+		{"unknown block field", `{"version": 1, "blocks": [{"id": "a", "type": "divider", "style": 1}]}`, "/blocks/0/style", "Unknown field"},
+		{"missing ID", `{"version": 1, "blocks": [{"type": "divider"}]}`, "/blocks/0/id", "Missing block ID"},
+		{"invalid ID", `{"version": 1, "blocks": [{"id": "a b", "type": "divider"}]}`, "/blocks/0/id", "Block ID must be"},
+		{"duplicate ID", `{"version": 1, "blocks": [{"id": "a", "type": "divider"}, {"id": "a", "type": "divider"}]}`, "/blocks/1/id", "also used at /blocks/0"},
+		{"unknown type", `{"version": 1, "blocks": [{"id": "a", "type": "marquee"}]}`, "/blocks/0/type", `Unknown block type "marquee"`},
+		{"not top level", `{"version": 1, "blocks": [{"id": "a", "type": "column"}]}`, "/blocks/0/type", "cannot be placed at the top level"},
+		{"child not allowed", `{"version": 1, "blocks": [{"id": "a", "type": "columns", "children": [{"id": "b", "type": "divider"}]}]}`, "/blocks/0/children/0/type", "divider blocks cannot be placed in columns blocks"},
+		{"children of a leaf", `{"version": 1, "blocks": [{"id": "a", "type": "divider", "children": []}]}`, "/blocks/0/children", "divider blocks cannot have children"},
+		{"props not an object", `{"version": 1, "blocks": [{"id": "a", "type": "divider", "props": []}]}`, "/blocks/0/props", "Must be an object"},
+		{"unknown prop escaped", `{"version": 1, "blocks": [{"id": "a", "type": "divider", "props": {"x~y": 1}}]}`, "/blocks/0/props/x~0y", "Unknown prop for divider blocks"},
+		{"missing required prop", `{"version": 1, "blocks": [{"id": "a", "type": "heading"}]}`, "/blocks/0/props/text", "Missing required prop"},
+		{"blank required prop", `{"version": 1, "blocks": [{"id": "a", "type": "heading", "props": {"text": "  "}}]}`, "/blocks/0/props/text", "Must not be empty"},
+		{"string too long", `{"version": 1, "blocks": [{"id": "a", "type": "button", "props": {"label": "` + strings.Repeat("é", 101) + `", "href": "/"}}]}`, "/blocks/0/props/label", "Must be at most 100 characters"},
+		{"multi-line string", `{"version": 1, "blocks": [{"id": "a", "type": "heading", "props": {"text": "a\nb"}}]}`, "/blocks/0/props/text", "Must be a single line"},
+		{"number out of range", `{"version": 1, "blocks": [{"id": "a", "type": "heading", "props": {"text": "a", "level": 7}}]}`, "/blocks/0/props/level", "Must be between 1 and 6"},
+		{"fractional integer", `{"version": 1, "blocks": [{"id": "a", "type": "heading", "props": {"text": "a", "level": 1.5}}]}`, "/blocks/0/props/level", "Must be an integer"},
+		{"boolean", `{"version": 1, "blocks": [{"id": "a", "type": "button", "props": {"label": "a", "href": "/", "new_tab": "yes"}}]}`, "/blocks/0/props/new_tab", "Must be true or false"},
+		{"enum", `{"version": 1, "blocks": [{"id": "a", "type": "spacer", "props": {"size": "huge"}}]}`, "/blocks/0/props/size", "Must be one of: none, small, medium, large"},
+		{"nested pointer", `{"version": 1, "blocks": [{"id": "a", "type": "columns", "children": [{"id": "b", "type": "column", "children": [{"id": "c", "type": "image", "props": {"src": "/logo.png"}}]}]}]}`, "/blocks/0/children/0/children/0/props/src", "Must be an http or https URL"},
+	}
+
+	registry := DefaultRegistry()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			document, errs := registry.Parse([]byte(test.data))
+			if document != nil {
+				t.Fatal("invalid document was accepted")
+			}
+			for _, err := range errs {
+				if err.Pointer == test.pointer && strings.Contains(err.Message, test.message) {
+					return
+				}
+			}
+			t.Errorf("no error %q at %q, got %+v", test.message, test.pointer, errs)
+		})
+	}
+}
+
+func TestParseNormalizesProps(t *testing.T) {
+	data := document(t,
+		map[string]any{"id": "a", "type": "heading", "props": map[string]any{"text": "Hi", "level": 2}},
+		map[string]any{"id": "b", "type": "columns", "children": []any{
+			map[string]any{"id": "c", "type": "column", "props": map[string]any{"width": 6}},
+		}},
+	)
+
+	document, errs := DefaultRegistry().Parse(data)
+	if errs != nil {
+		t.Fatalf("valid document was rejected: %+v", errs)
+	}
+	if level, ok := document.Blocks[0].Props["level"].(int64); !ok || level != 2 {
+		t.Errorf("level = %#v, want int64 2", document.Blocks[0].Props["level"])
+	}
+	if len(document.Blocks[1].Children) != 1 || document.Blocks[1].Children[0].ID != "c" {
+		t.Errorf("children = %+v, want column c", document.Blocks[1].Children)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	manyBlocks := func(n int) []any {
+		blocks := make([]any, n)
+		for i := range blocks {
+			blocks[i] = map[string]any{"id": "d" + strconv.Itoa(i), "type": "divider"}
+		}
+		return blocks
+	}
+	invalidBlocks := func(n int) []any {
+		blocks := make([]any, n)
+		for i := range blocks {
+			blocks[i] = map[string]any{"id": "!", "type": "divider"}
+		}
+		return blocks
+	}
+
+	tests := []struct {
+		name    string
+		blocks  []any
+		errors  int
+		pointer string
+	}{
+		{"deepest nesting", []any{nested(MaxDepth)}, 0, ""},
+		{"nested too deeply", []any{nested(MaxDepth + 1)}, 1, "/blocks/0" + strings.Repeat("/children/0", MaxDepth-1) + "/children"},
+		{"most blocks", manyBlocks(MaxBlocks), 0, ""},
+		{"too many blocks", manyBlocks(MaxBlocks + 5), 1, "/blocks/2000"},
+		{"errors are capped", invalidBlocks(maxErrors + 50), maxErrors, "/blocks/0/id"},
+	}
+
+	registry := DefaultRegistry()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, errs := registry.Parse(document(t, test.blocks...))
+			if len(errs) != test.errors {
+				t.Fatalf("got %d errors, want %d: %+v", len(errs), test.errors, errs[:min(len(errs), 3)])
+			}
+			if test.errors > 0 && errs[0].Pointer != test.pointer {
+				t.Errorf("error at %q, want %q", errs[0].Pointer, test.pointer)
+			}
+		})
+	}
+}
+
+func TestValidURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		link bool
+		want bool
+	}{
+		{"https://example.com/a?b=c", false, true},
+		{"http://example.com", false, true},
+		{"https://", false, false},
+		{"ftp://example.com", false, false},
+		{"javascript:alert(1)", false, false},
+		{"javascript:alert(1)", true, false},
+		{"JavaScript:alert(1)", true, false},
+		{"data:text/html,<script>", true, false},
+		{"/about", false, false},
+		{"/about/team?x=1#top", true, true},
+		{"#contact", true, true},
+		{"mailto:hello@example.com", true, true},
+		{"mailto:hello@example.com", false, false},
+		{"tel:+3612345678", true, true},
+		{"mailto:", true, false},
+		{"//evil.com", true, false},
+		{`/\evil.com`, true, false},
+		{`/\/evil.com`, true, false},
+		{`https:\\evil.com`, true, false},
+		{`https://example.com\@evil.com`, true, false},
+		{"/a\tb", true, false},
+		{"", true, false},
+		{"/" + strings.Repeat("a", maxURLLength), true, false},
+	}
+
+	for _, test := range tests {
+		if got := validURL(test.url, test.link); got != test.want {
+			t.Errorf("validURL(%q, %v) = %v, want %v", test.url, test.link, got, test.want)
+		}
+	}
+}
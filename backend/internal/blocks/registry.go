@@ -0,0 +1,189 @@
+// Package blocks defines the document model of page content: a versioned tree
+// of typed blocks, the registry of block types with the props they accept, and
+// the validation every document passes before it is stored.
+package blocks
+
+import (
+	"slices"
+	"sort"
+)
+
+// PropKind is the type of value a prop holds
+type PropKind string
+
+// Prop kinds
+const (
+	KindString  PropKind = "string"
+	KindText    PropKind = "text" // Long string, may contain line breaks
+	KindInteger PropKind = "integer"
+	KindNumber  PropKind = "number"
+	KindBoolean PropKind = "boolean"
+	KindEnum    PropKind = "enum"
+	KindURL     PropKind = "url"  // Absolute http or https URL
+	KindLink    PropKind = "link" // URL, mailto: or tel: link, site path or #anchor
+)
+
+// PropSchema describes one prop of a block type
+type PropSchema struct {
+	Kind      PropKind `json:"kind"`
+	Required  bool     `json:"required,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"` // For strings; zero means the kind's default
+	Min       *float64 `json:"min,omitempty"`        // For numbers
+	Max       *float64 `json:"max,omitempty"`        // For numbers
+	Values    []string `json:"values,omitempty"`     // For enums
+}
+
+// BlockType describes a kind of block: the props it accepts and whether it holds other blocks
+type BlockType struct {
+	Name      string                `json:"name"`
+	Props     map[string]PropSchema `json:"props"`
+	Container bool                  `json:"container"`
+	// Children limits the types of child blocks of a container; empty allows any type
+	Children []string `json:"children,omitempty"`
+	// Parents limits where the block may be placed; empty allows anywhere
+	Parents []string `json:"parents,omitempty"`
+	// TopLevel reports whether the block may be placed directly in the document
+	TopLevel bool `json:"top_level"`
+}
+
+// Registry holds the block types documents may use
+type Registry struct {
+	types map[string]*BlockType
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{types: make(map[string]*BlockType)}
+}
+
+// Register adds a block type, replacing any type with the same name
+func (r *Registry) Register(blockType BlockType) {
+	r.types[blockType.Name] = &blockType
+}
+
+// Lookup returns the block type with the given name
+func (r *Registry) Lookup(name string) (*BlockType, bool) {
+	blockType, ok := r.types[name]
+	return blockType, ok
+}
+
+// Types returns all registered block types sorted by name
+func (r *Registry) Types() []*BlockType {
+	types := make([]*BlockType, 0, len(r.types))
+	for _, blockType := range r.types {
+		types = append(types, blockType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// allowsChild reports whether a block of type child may be placed in parent
+func allowsChild(parent *BlockType, child *BlockType) bool {
+	if len(parent.Children) > 0 && !slices.Contains(parent.Children, child.Name) {
+		return false
+	}
+	return len(child.Parents) == 0 || slices.Contains(child.Parents, parent.Name)
+}
+
+// bound returns a pointer to a number, for the Min and Max of a PropSchema
+func bound(n float64) *float64 {
+	return &n
+}
+
+// DefaultRegistry returns a registry of the built-in block types
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+
+	spacing := PropSchema{Kind: KindEnum, Values: []string{"none", "small", "medium", "large"}}
+	align := PropSchema{Kind: KindEnum, Values: []string{"left", "center", "right"}}
+
+	r.Register(BlockType{
+		Name: "section",
+		Props: map[string]PropSchema{
+			"background": {Kind: KindString, MaxLength: 32},
+			"padding":    spacing,
+			"anchor":     {Kind: KindString, MaxLength: 63},
+		},
+		Container: true,
+		TopLevel:  true,
+	})
+	r.Register(BlockType{
+		Name: "columns",
+		Props: map[string]PropSchema{
+			"gap":             spacing,
+			"stack_on_mobile": {Kind: KindBoolean},
+		},
+		Container: true,
+		Children:  []string{"column"},
+		TopLevel:  true,
+	})
+	r.Register(BlockType{
+		Name: "column",
+		Props: map[string]PropSchema{
+			"width": {Kind: KindInteger, Min: bound(1), Max: bound(12)}, // Out of a 12-column grid
+		},
+		Container: true,
+		Parents:   []string{"columns"},
+	})
+	r.Register(BlockType{
+		Name: "heading",
+		Props: map[string]PropSchema{
+			"text":  {Kind: KindString, Required: true, MaxLength: 300},
+			"level": {Kind: KindInteger, Min: bound(1), Max: bound(6)},
+			"align": align,
+		},
+		TopLevel: true,
+	})
+	r.Register(BlockType{
+		Name: "text",
+		Props: map[string]PropSchema{
+			"text":  {Kind: KindText, Required: true},
+			"align": align,
+		},
+		TopLevel: true,
+	})
+	r.Register(BlockType{
+		Name: "image",
+		Props: map[string]PropSchema{
+			"src":    {Kind: KindURL, Required: true},
+			"alt":    {Kind: KindString, MaxLength: 300},
+			"width":  {Kind: KindInteger, Min: bound(1), Max: bound(10000)},
+			"height": {Kind: KindInteger, Min: bound(1), Max: bound(10000)},
+			"link":   {Kind: KindLink},
+		},
+		TopLevel: true,
+	})
+	r.Register(BlockType{
+		Name: "button",
+		Props: map[string]PropSchema{
+			"label":   {Kind: KindString, Required: true, MaxLength: 100},
+			"href":    {Kind: KindLink, Required: true},
+			"variant": {Kind: KindEnum, Values: []string{"primary", "secondary", "link"}},
+			"new_tab": {Kind: KindBoolean},
+		},
+		TopLevel: true,
+	})
+	r.Register(BlockType{
+		Name: "embed",
+		Props: map[string]PropSchema{
+			"url":          {Kind: KindURL, Required: true},
+			"title":        {Kind: KindString, MaxLength: 300},
+			"aspect_ratio": {Kind: KindEnum, Values: []string{"16:9", "4:3", "1:1"}},
+		},
+		TopLevel: true,
+	})
+	r.Register(BlockType{
+		Name: "spacer",
+		Props: map[string]PropSchema{
+			"size": spacing,
+		},
+		TopLevel: true,
+	})
+	r.Register(BlockType{
+		Name:     "divider",
+		Props:    map[string]PropSchema{},
+		TopLevel: true,
+	})
+
+	return r
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/bercivarga/website-builder/internal/app"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/services"
 	"github.com/rs/cors"
 )
 
@@ -11,18 +13,32 @@ import (
 func SetupHandlers(app *app.Application) http.Handler {
 	mux := http.NewServeMux()
 	addPublicRoutes(mux, app)
+	addWellKnownRoutes(mux, app)
 	addAuthRoutes(mux, app)
+	addSessionRoutes(mux, app)
+	addMFARoutes(mux, app)
+	addWebAuthnRoutes(mux, app)
+	addOIDCRoutes(mux, app)
+	addIdentityRoutes(mux, app)
+	addAPITokenRoutes(mux, app)
+	addAdminRoutes(mux, app)
 	addUserRoutes(mux, app)
+	addWorkspaceRoutes(mux, app)
+	addInvitationRoutes(mux, app)
+	addSiteRoutes(mux, app)
+	addPageRoutes(mux, app)
+	addPublishRoutes(mux, app)
+	addBlockRoutes(mux, app)
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000", "http://localhost:5173", "your-frontend-url"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "Authorization"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "Authorization", services.CSRFHeader, services.AuthModeHeader},
 		AllowCredentials: true,
 		// Debug:            true, // Enable for debugging
 	})
 
-	return c.Handler(mux)
+	return c.Handler(ClientIPMiddleware(app.TrustedProxies)(mux))
 }
 
 func addPublicRoutes(mux *http.ServeMux, app *app.Application) {
@@ -31,6 +47,12 @@ func addPublicRoutes(mux *http.ServeMux, app *app.Application) {
 	publicGroup.Get("/health", healthCheckHandler)
 }
 
+func addWellKnownRoutes(mux *http.ServeMux, app *app.Application) {
+	wellKnownGroup := CreateRouteGroup(mux, "/.well-known")
+	wellKnownGroup.Use(LoggingMiddleware(app.Logger))
+	wellKnownGroup.Get("/jwks.json", app.KeyService.JWKS)
+}
+
 func addAuthRoutes(mux *http.ServeMux, app *app.Application) {
 	authGroup := CreateRouteGroup(mux, "/v1/auth")
 	authGroup.Use(LoggingMiddleware(app.Logger))
@@ -38,17 +60,214 @@ func addAuthRoutes(mux *http.ServeMux, app *app.Application) {
 	authGroup.Post("/login", app.AuthService.Login)
 	authGroup.Post("/logout", app.AuthService.Logout)
 	authGroup.Post("/refresh", app.AuthService.Refresh)
+	authGroup.Post("/verify-email", app.AuthService.VerifyEmail)
+	authGroup.Post("/verify-email/resend", app.AuthService.ResendVerificationEmail)
+	authGroup.Post("/confirm-email-change", app.AuthService.ConfirmEmailChange)
+	authGroup.Post("/forgot-password", app.AuthService.ForgotPassword)
+	authGroup.Post("/reset-password", app.AuthService.ResetPassword)
+	authGroup.Post("/magic-link", app.AuthService.RequestMagicLink)
+	authGroup.Post("/magic-link/consume", app.AuthService.ConsumeMagicLink)
+	authGroup.Post("/mfa/verify", app.AuthService.VerifyMFA)
+	authGroup.Post("/webauthn/login/begin", app.WebAuthn.BeginLogin)
+	authGroup.Post("/webauthn/login/finish", app.WebAuthn.FinishLogin)
+}
+
+func addSessionRoutes(mux *http.ServeMux, app *app.Application) {
+	sessionGroup := CreateRouteGroup(mux, "/v1/auth/sessions")
+	sessionGroup.Use(LoggingMiddleware(app.Logger))
+	sessionGroup.Use(app.AuthService.AuthMiddleware)
+	sessionGroup.Use(RequireSession)
+	sessionGroup.Get("", app.SessionService.ListSessions)
+	sessionGroup.Delete("", app.SessionService.RevokeOtherSessions)
+	sessionGroup.Delete("/{id}", app.SessionService.RevokeSession)
+}
+
+func addMFARoutes(mux *http.ServeMux, app *app.Application) {
+	mfaGroup := CreateRouteGroup(mux, "/v1/auth/mfa")
+	mfaGroup.Use(LoggingMiddleware(app.Logger))
+	mfaGroup.Use(app.AuthService.AuthMiddleware)
+	mfaGroup.Use(RequireSession)
+	mfaGroup.Get("", app.AuthService.MFAStatus)
+	mfaGroup.Post("/totp/enroll", app.AuthService.EnrollTOTP)
+	mfaGroup.Post("/totp/confirm", app.AuthService.ConfirmTOTP)
+	mfaGroup.Post("/totp/disable", app.AuthService.DisableTOTP)
+	mfaGroup.Post("/recovery-codes", app.AuthService.RegenerateRecoveryCodes)
+}
+
+func addWebAuthnRoutes(mux *http.ServeMux, app *app.Application) {
+	webAuthnGroup := CreateRouteGroup(mux, "/v1/auth/webauthn")
+	webAuthnGroup.Use(LoggingMiddleware(app.Logger))
+	webAuthnGroup.Use(app.AuthService.AuthMiddleware)
+	webAuthnGroup.Use(RequireSession)
+	webAuthnGroup.Post("/register/begin", app.WebAuthn.BeginRegistration)
+	webAuthnGroup.Post("/register/finish", app.WebAuthn.FinishRegistration)
+	webAuthnGroup.Get("/credentials", app.WebAuthn.ListCredentials)
+	webAuthnGroup.Delete("/credentials/{id}", app.WebAuthn.DeleteCredential)
+}
+
+func addOIDCRoutes(mux *http.ServeMux, app *app.Application) {
+	oidcGroup := CreateRouteGroup(mux, "/v1/auth/oidc")
+	oidcGroup.Use(LoggingMiddleware(app.Logger))
+	oidcGroup.Get("/providers", app.OIDC.ListProviders)
+	oidcGroup.Post("/{provider}/authorize", app.OIDC.Authorize)
+	oidcGroup.Post("/{provider}/callback", app.OIDC.Callback)
+}
+
+func addIdentityRoutes(mux *http.ServeMux, app *app.Application) {
+	identityGroup := CreateRouteGroup(mux, "/v1/auth/identities")
+	identityGroup.Use(LoggingMiddleware(app.Logger))
+	identityGroup.Use(app.AuthService.AuthMiddleware)
+	identityGroup.Use(RequireSession)
+	identityGroup.Get("", app.OIDC.ListIdentities)
+	identityGroup.Post("/link/{provider}", app.OIDC.LinkIdentity)
+	identityGroup.Post("/link/{provider}/callback", app.OIDC.LinkCallback)
+	identityGroup.Delete("/{id}", app.OIDC.UnlinkIdentity)
+}
+
+func addAPITokenRoutes(mux *http.ServeMux, app *app.Application) {
+	apiTokenGroup := CreateRouteGroup(mux, "/v1/auth/api-tokens")
+	apiTokenGroup.Use(LoggingMiddleware(app.Logger))
+	apiTokenGroup.Use(app.AuthService.AuthMiddleware)
+	apiTokenGroup.Use(RequireSession)
+	apiTokenGroup.Get("", app.APITokenService.ListAPITokens)
+	apiTokenGroup.Post("", app.APITokenService.CreateAPIToken)
+	apiTokenGroup.Delete("/{id}", app.APITokenService.RevokeAPIToken)
+}
+
+func addAdminRoutes(mux *http.ServeMux, app *app.Application) {
+	adminGroup := CreateRouteGroup(mux, "/v1/admin")
+	adminGroup.Use(LoggingMiddleware(app.Logger))
+	adminGroup.Use(app.AuthService.AuthMiddleware)
+	adminGroup.Use(RequireSession)
+	adminGroup.With(RequirePermission(rbac.PermissionUsersManage)).Post("/users/{id}/unlock", app.AuthService.UnlockUser)
+	adminGroup.With(RequirePermission(rbac.PermissionUsersManage)).Put("/users/{id}/role", app.AuthService.SetUserRole)
+	adminGroup.With(RequirePermission(rbac.PermissionUsersManage)).Post("/users/{id}/impersonate", app.AuthService.Impersonate)
+	adminGroup.With(RequirePermission(rbac.PermissionAuditRead)).Get("/audit-events", app.AuditService.ListAuditEvents)
 }
 
 func addUserRoutes(mux *http.ServeMux, app *app.Application) {
 	userGroup := CreateRouteGroup(mux, "/v1/user")
 	userGroup.Use(LoggingMiddleware(app.Logger))
 	userGroup.Use(app.AuthService.AuthMiddleware)
+	userGroup.Use(RequirePermission(rbac.PermissionProfileRead))
 	userGroup.Get("/me", app.UserService.GetMe)
-	userGroup.Get("/{id}", app.UserService.GetUser)
+	userGroup.With(RequireSession).Patch("/me", app.UserService.UpdateMe)
+	userGroup.With(RequireSession).Post("/me/password", app.AuthService.ChangePassword)
+	userGroup.With(RequireSession).Post("/me/email", app.AuthService.RequestEmailChange)
+	userGroup.With(RequireSession).Get("/me/exports", app.DataExports.ListExports)
+	userGroup.With(RequireSession).Post("/me/exports", app.DataExports.RequestExport)
+	userGroup.With(RequireSession).Get("/me/exports/{id}", app.DataExports.GetExport)
+	userGroup.With(RequireSession).Get("/me/exports/{id}/download", app.DataExports.DownloadExport)
+	userGroup.With(RequireSession).Post("/me/deletion", app.Deletion.ScheduleDeletion)
+	userGroup.With(RequireSession).Delete("/me/deletion", app.Deletion.CancelDeletion)
+	userGroup.With(RequirePermission(rbac.PermissionUsersRead)).Get("/{id}", app.UserService.GetUser)
 }
 
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+func addWorkspaceRoutes(mux *http.ServeMux, app *app.Application) {
+	workspaces := app.Workspaces
+
+	workspaceGroup := CreateRouteGroup(mux, "/v1/workspaces")
+	workspaceGroup.Use(LoggingMiddleware(app.Logger))
+	workspaceGroup.Use(app.AuthService.AuthMiddleware)
+	workspaceGroup.With(RequirePermission(rbac.PermissionProfileRead)).Get("", workspaces.ListWorkspaces)
+	workspaceGroup.With(RequireSession).Post("", workspaces.CreateWorkspace)
+
+	// Everything below is checked against the caller's role in the workspace
+	readGroup := workspaceGroup.With(workspaces.RequireWorkspacePermission(rbac.PermissionWorkspaceRead))
+	readGroup.Get("/{workspaceID}", workspaces.GetWorkspace)
+	readGroup.Get("/{workspaceID}/members", workspaces.ListMembers)
+	readGroup.Delete("/{workspaceID}/members/{userID}", workspaces.RemoveMember)
+
+	manageGroup := workspaceGroup.With(workspaces.RequireWorkspacePermission(rbac.PermissionWorkspaceManage))
+	manageGroup.Put("/{workspaceID}", workspaces.UpdateWorkspace)
+
+	auditGroup := workspaceGroup.With(workspaces.RequireWorkspacePermission(rbac.PermissionAuditRead))
+	auditGroup.Get("/{workspaceID}/audit-events", app.AuditService.ListWorkspaceAuditEvents)
+
+	membersGroup := workspaceGroup.With(workspaces.RequireWorkspacePermission(rbac.PermissionMembersManage))
+	membersGroup.Put("/{workspaceID}/members/{userID}", workspaces.UpdateMember)
+	membersGroup.Get("/{workspaceID}/invitations", workspaces.ListInvitations)
+	membersGroup.Post("/{workspaceID}/invitations", workspaces.CreateInvitation)
+	membersGroup.Delete("/{workspaceID}/invitations/{id}", workspaces.RevokeInvitation)
+
+	ownerGroup := workspaceGroup.With(workspaces.RequireWorkspacePermission(rbac.PermissionWorkspaceOwn))
+	ownerGroup.Delete("/{workspaceID}", workspaces.DeleteWorkspace)
+	ownerGroup.Post("/{workspaceID}/transfer", workspaces.TransferOwnership)
+}
+
+func addSiteRoutes(mux *http.ServeMux, app *app.Application) {
+	sites := app.Sites
+
+	siteGroup := CreateRouteGroup(mux, "/v1/sites")
+	siteGroup.Use(LoggingMiddleware(app.Logger))
+	siteGroup.Use(app.AuthService.AuthMiddleware)
+	siteGroup.With(RequirePermission(rbac.PermissionSitesRead)).Get("", sites.ListSites)
+	// Creating a site is checked against the caller's role in the workspace named in the body
+	siteGroup.Post("", sites.CreateSite)
+
+	// Everything below is checked against the caller's role in the site's workspace
+	siteGroup.With(sites.RequireSitePermission(rbac.PermissionSitesRead)).Get("/{siteID}", sites.GetSite)
+	siteGroup.With(sites.RequireSitePermission(rbac.PermissionSitesWrite)).Patch("/{siteID}", sites.UpdateSite)
+	siteGroup.With(sites.RequireSitePermission(rbac.PermissionWorkspaceManage)).Delete("/{siteID}", sites.DeleteSite)
+}
+
+func addPageRoutes(mux *http.ServeMux, app *app.Application) {
+	pages := app.Pages
+
+	pageGroup := CreateRouteGroup(mux, "/v1/sites/{siteID}/pages")
+	pageGroup.Use(LoggingMiddleware(app.Logger))
+	pageGroup.Use(app.AuthService.AuthMiddleware)
+
+	readGroup := pageGroup.With(app.Sites.RequireSitePermission(rbac.PermissionPagesRead))
+	readGroup.Get("", pages.ListPages)
+	readGroup.Get("/{pageID}", pages.GetPage)
+	readGroup.Get("/{pageID}/content", pages.GetPageContent)
+	readGroup.Get("/{pageID}/revisions", pages.ListRevisions)
+	readGroup.Get("/{pageID}/revisions/diff", pages.DiffRevisions)
+	readGroup.Get("/{pageID}/revisions/{revisionID}", pages.GetRevision)
+
+	writeGroup := pageGroup.With(app.Sites.RequireSitePermission(rbac.PermissionPagesWrite))
+	writeGroup.Post("", pages.CreatePage)
+	writeGroup.Patch("/{pageID}", pages.UpdatePage)
+	writeGroup.Post("/{pageID}/move", pages.MovePage)
+	writeGroup.Put("/{pageID}/content", pages.UpdatePageContent)
+	writeGroup.Post("/{pageID}/revisions/{revisionID}/restore", pages.RestoreRevision)
+	writeGroup.Delete("/{pageID}", pages.DeletePage)
+
+	readGroup.Get("/{pageID}/published", app.Publishing.GetPublishedContent)
+	pageGroup.With(app.Sites.RequireSitePermission(rbac.PermissionPublish)).Post("/{pageID}/unpublish", app.Publishing.UnpublishPage)
+}
+
+func addPublishRoutes(mux *http.ServeMux, app *app.Application) {
+	publishing := app.Publishing
+
+	publishGroup := CreateRouteGroup(mux, "/v1/sites/{siteID}/publishes")
+	publishGroup.Use(LoggingMiddleware(app.Logger))
+	publishGroup.Use(app.AuthService.AuthMiddleware)
+	publishGroup.With(app.Sites.RequireSitePermission(rbac.PermissionSitesRead)).Get("", publishing.ListPublishes)
+	publishGroup.With(app.Sites.RequireSitePermission(rbac.PermissionSitesRead)).Get("/{publishID}", publishing.GetPublish)
+
+	// Changing the live site needs the publish permission, which impersonation never grants
+	publishGroup.With(app.Sites.RequireSitePermission(rbac.PermissionPublish)).Post("", publishing.PublishSite)
+	publishGroup.With(app.Sites.RequireSitePermission(rbac.PermissionPublish)).Post("/{publishID}/rollback", publishing.RollbackPublish)
+}
+
+func addBlockRoutes(mux *http.ServeMux, app *app.Application) {
+	blockGroup := CreateRouteGroup(mux, "/v1/blocks")
+	blockGroup.Use(LoggingMiddleware(app.Logger))
+	blockGroup.Use(app.AuthService.AuthMiddleware)
+	blockGroup.Use(RequirePermission(rbac.PermissionPagesRead))
+	blockGroup.Get("", app.Pages.ListBlockTypes)
+}
+
+func addInvitationRoutes(mux *http.ServeMux, app *app.Application) {
+	invitationGroup := CreateRouteGroup(mux, "/v1/invitations")
+	invitationGroup.Use(LoggingMiddleware(app.Logger))
+	invitationGroup.Post("/decline", app.Workspaces.DeclineInvitation)
+	invitationGroup.With(app.AuthService.AuthMiddleware, RequireSession).Post("/accept", app.Workspaces.AcceptInvitation)
+}
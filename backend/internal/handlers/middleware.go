@@ -4,8 +4,22 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
 )
 
+// ClientIPMiddleware is a middleware that resolves the client address of every request,
+// believing X-Forwarded-For only from the given proxies. It must run before anything that reads utils.ClientIP.
+func ClientIPMiddleware(proxies utils.TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := utils.WithClientIP(r.Context(), proxies.ClientIP(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 // LoggingMiddleware is a middleware that logs incoming requests and their details.
 func LoggingMiddleware(logger *log.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -21,7 +35,7 @@ func LoggingMiddleware(logger *log.Logger) func(http.Handler) http.Handler {
 			duration := time.Since(start)
 			logger.Printf(
 				"%s %s %s %d %v",
-				r.RemoteAddr,
+				utils.ClientIP(r),
 				r.Method,
 				r.URL.Path,
 				wrapped.statusCode,
@@ -31,6 +45,51 @@ func LoggingMiddleware(logger *log.Logger) func(http.Handler) http.Handler {
 	}
 }
 
+// RequirePermission is a middleware that only lets principals with the given permission through.
+// It must run after AuthMiddleware.
+func RequirePermission(permission rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := rbac.FromContext(r.Context())
+			if principal == nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !principal.Can(permission) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession is a middleware that rejects API tokens and impersonation.
+// Account and credential management needs the user signed in as themselves.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := rbac.FromContext(r.Context())
+		if principal == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if principal.IsAPIToken() {
+			http.Error(w, "This endpoint requires a signed in user", http.StatusForbidden)
+			return
+		}
+
+		if principal.IsImpersonated() {
+			http.Error(w, "Not allowed while impersonating", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
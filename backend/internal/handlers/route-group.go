@@ -24,6 +24,16 @@ func (rg *RouteGroup) Use(middleware func(http.Handler) http.Handler) {
 	rg.middleware = append(rg.middleware, middleware)
 }
 
+// With returns a copy of the group with additional middleware, so single routes can
+// declare what they need, e.g. group.With(RequirePermission(...)).Get(...)
+func (rg *RouteGroup) With(middleware ...func(http.Handler) http.Handler) *RouteGroup {
+	return &RouteGroup{
+		prefix:     rg.prefix,
+		mux:        rg.mux,
+		middleware: append(rg.middleware[:len(rg.middleware):len(rg.middleware)], middleware...),
+	}
+}
+
 // Get adds a GET route to the group
 func (rg *RouteGroup) Get(path string, handler http.HandlerFunc) {
 	rg.handle(http.MethodGet, path, handler)
@@ -39,6 +49,11 @@ func (rg *RouteGroup) Put(path string, handler http.HandlerFunc) {
 	rg.handle(http.MethodPut, path, handler)
 }
 
+// Patch adds a PATCH route to the group
+func (rg *RouteGroup) Patch(path string, handler http.HandlerFunc) {
+	rg.handle(http.MethodPatch, path, handler)
+}
+
 // Delete adds a DELETE route to the group
 func (rg *RouteGroup) Delete(path string, handler http.HandlerFunc) {
 	rg.handle(http.MethodDelete, path, handler)
@@ -52,13 +67,7 @@ func (rg *RouteGroup) handle(method, path string, handler http.HandlerFunc) {
 		wrappedHandler = rg.middleware[i](wrappedHandler)
 	}
 
-	// Register the route with method and path
+	// Register the route with method and path so several methods can share a path
 	fullPath := rg.prefix + path
-	rg.mux.HandleFunc(fullPath, func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		wrappedHandler.ServeHTTP(w, r)
-	})
+	rg.mux.Handle(method+" "+fullPath, wrappedHandler)
 }
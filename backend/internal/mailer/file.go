@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes messages as .eml files into an outbox directory, for local development
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+// NewFileMailer creates a new FileMailer, creating the outbox directory if needed
+func NewFileMailer(from string, dir string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{
+		from: from,
+		dir:  dir,
+	}, nil
+}
+
+// Send writes the message to a new file in the outbox
+func (m *FileMailer) Send(msg *Message) error {
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(m.dir, name), formatMessage(m.from, msg), 0o644)
+}
@@ -0,0 +1,76 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is an email to be delivered
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// Config holds the configuration for the mail transport
+type Config struct {
+	Transport    string // "smtp", "file" or "memory"
+	From         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	OutboxDir    string
+}
+
+// New creates the Mailer for the configured transport, which has to be set explicitly
+func New(config Config) (Mailer, error) {
+	if config.From == "" {
+		config.From = "no-reply@localhost"
+	}
+
+	switch config.Transport {
+	case "smtp":
+		if config.SMTPHost == "" || config.SMTPPort == "" {
+			return nil, fmt.Errorf("missing SMTP host or port")
+		}
+		return NewSMTPMailer(config), nil
+	case "":
+		return nil, fmt.Errorf("missing mail transport")
+	case "file":
+		if config.OutboxDir == "" {
+			config.OutboxDir = "tmp/outbox"
+		}
+		return NewFileMailer(config.From, config.OutboxDir)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unsupported mail transport: %s", config.Transport)
+	}
+}
+
+// formatMessage renders a message as a plain text RFC 5322 email
+func formatMessage(from string, msg *Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", sanitizeHeader(from))
+	fmt.Fprintf(&buf, "To: %s\r\n", sanitizeHeader(msg.To))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", sanitizeHeader(msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// sanitizeHeader strips line breaks so values cannot inject extra headers
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
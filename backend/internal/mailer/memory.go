@@ -0,0 +1,41 @@
+package mailer
+
+import "sync"
+
+// MemoryMailer keeps sent messages in memory, for tests
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryMailer creates a new, empty MemoryMailer
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send records the message
+func (m *MemoryMailer) Send(msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, *msg)
+	return nil
+}
+
+// Messages returns a copy of all recorded messages
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]Message, len(m.messages))
+	copy(messages, m.messages)
+	return messages
+}
+
+// Reset discards all recorded messages
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
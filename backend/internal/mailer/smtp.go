@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer delivers messages through an SMTP server
+type SMTPMailer struct {
+	from string
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates a new SMTPMailer from the given config
+func NewSMTPMailer(config Config) *SMTPMailer {
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+	}
+
+	return &SMTPMailer{
+		from: config.From,
+		addr: net.JoinHostPort(config.SMTPHost, config.SMTPPort),
+		auth: auth,
+	}
+}
+
+// Send delivers the message to the SMTP server
+func (m *SMTPMailer) Send(msg *Message) error {
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, formatMessage(m.from, msg))
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIToken is a long-lived, user-created credential for automation such as CI
+type APIToken struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Name        string     `json:"name"`
+	TokenHash   string     `json:"-"`
+	TokenPrefix string     `json:"token_prefix"` // Start of the token, so users can tell tokens apart
+	Scopes      []string   `json:"scopes"`       // Permissions the token may use, see rbac.APITokenScopes
+	ExpiresAt   *time.Time `json:"expires_at"`   // Nil for tokens that never expire
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	LastUsedIP  string     `json:"last_used_ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Expired reports whether the token has expired
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// APITokenStore is a struct that holds the database connection
+type APITokenStore struct {
+	DB *sql.DB
+}
+
+// APITokenRepository is an interface that defines the methods for API token operations
+type APITokenRepository interface {
+	CreateAPIToken(token *APIToken) error
+	GetAPITokenByHash(tokenHash string) (*APIToken, error)
+	GetAPITokensByUserID(userID int) ([]*APIToken, error)
+	TouchAPIToken(id int, ipAddress string) error
+	DeleteAPIToken(id int, userID int) (bool, error)
+}
+
+// NewAPITokenStore creates a new APITokenStore with the given database connection
+func NewAPITokenStore(db *sql.DB) *APITokenStore {
+	return &APITokenStore{DB: db}
+}
+
+// CreateAPIToken inserts a new API token into the database
+func (s *APITokenStore) CreateAPIToken(token *APIToken) error {
+	query := `INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	err := s.DB.QueryRow(query, token.UserID, token.Name, token.TokenHash, token.TokenPrefix, pq.Array(token.Scopes), token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	return err
+}
+
+// GetAPITokenByHash retrieves an API token by the hash of its secret
+func (s *APITokenStore) GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	query := `SELECT id, user_id, name, token_hash, token_prefix, scopes, expires_at, last_used_at, last_used_ip, created_at FROM api_tokens WHERE token_hash = $1`
+	row := s.DB.QueryRow(query, tokenHash)
+	var token APIToken
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.TokenHash, &token.TokenPrefix, pq.Array(&token.Scopes), &token.ExpiresAt, &token.LastUsedAt, &token.LastUsedIP, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetAPITokensByUserID retrieves all API tokens of a user, newest first
+func (s *APITokenStore) GetAPITokensByUserID(userID int) ([]*APIToken, error) {
+	query := `SELECT id, user_id, name, token_hash, token_prefix, scopes, expires_at, last_used_at, last_used_ip, created_at FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := s.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		var token APIToken
+		err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.TokenHash, &token.TokenPrefix, pq.Array(&token.Scopes), &token.ExpiresAt, &token.LastUsedAt, &token.LastUsedIP, &token.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+	return tokens, rows.Err()
+}
+
+// TouchAPIToken records use of an API token.
+// Writes are throttled to once a minute so busy CI jobs don't all hit the disk.
+func (s *APITokenStore) TouchAPIToken(id int, ipAddress string) error {
+	query := `UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = $1 
+	WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')`
+	_, err := s.DB.Exec(query, ipAddress, id)
+	return err
+}
+
+// DeleteAPIToken removes an API token if it belongs to the given user.
+// It reports whether a token was deleted.
+func (s *APITokenStore) DeleteAPIToken(id int, userID int) (bool, error) {
+	query := `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`
+	result, err := s.DB.Exec(query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
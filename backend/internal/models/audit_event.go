@@ -0,0 +1,163 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Audit event types
+const (
+	AuditEventLogin                = "login"
+	AuditEventLoginFailed          = "login_failed"
+	AuditEventLogout               = "logout"
+	AuditEventTokenRefreshed       = "token_refreshed"
+	AuditEventRefreshTokenReuse    = "refresh_token_reuse"
+	AuditEventSessionRevoked       = "session_revoked"
+	AuditEventPasswordReset        = "password_reset"
+	AuditEventPasswordChanged      = "password_changed"
+	AuditEventEmailChangeRequested = "email_change_requested"
+	AuditEventEmailChanged         = "email_changed"
+	AuditEventMFAEnabled           = "mfa_enabled"
+	AuditEventMFADisabled          = "mfa_disabled"
+	AuditEventRecoveryCodeUsed     = "recovery_code_used"
+	AuditEventWebAuthnRegistered   = "webauthn_registered"
+	AuditEventWebAuthnCloneWarning = "webauthn_clone_warning"
+	AuditEventIdentityLinked       = "identity_linked"
+	AuditEventIdentityUnlinked     = "identity_unlinked"
+	AuditEventAccountLocked        = "account_locked"
+	AuditEventAccountUnlocked      = "account_unlocked"
+	AuditEventRoleChanged          = "role_changed"
+	AuditEventMemberJoined         = "member_joined"
+	AuditEventMemberRemoved        = "member_removed"
+	AuditEventOwnershipTransferred = "ownership_transferred"
+	AuditEventImpersonationStarted = "impersonation_started"
+	AuditEventImpersonatedRequest  = "impersonated_request"
+	AuditEventDeletionScheduled    = "deletion_scheduled"
+	AuditEventDeletionCanceled     = "deletion_canceled"
+	AuditEventAccountDeleted       = "account_deleted"
+	AuditEventSitePublished        = "site_published"
+	AuditEventSiteRolledBack       = "site_rolled_back"
+	AuditEventPageUnpublished      = "page_unpublished"
+)
+
+// AuditEvent is an entry of the append-only audit log.
+// UserID is the account the event is about and ActorID whoever caused it;
+// they differ when e.g. an admin changes someone's role.
+type AuditEvent struct {
+	ID          int64           `json:"id"`
+	ActorID     *int            `json:"actor_id"`
+	UserID      *int            `json:"user_id"`
+	WorkspaceID *int            `json:"workspace_id"`
+	EventType   string          `json:"event_type"`
+	IPAddress   string          `json:"ip_address"`
+	UserAgent   string          `json:"user_agent"`
+	Metadata    json.RawMessage `json:"metadata"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// AuditEventFilter narrows down an audit log query.
+// Zero values don't filter; results are newest first, starting below Before.
+type AuditEventFilter struct {
+	WorkspaceID int // Events in the workspace and account events of its members since they joined
+	UserID      int
+	ActorID     int
+	EventTypes  []string
+	Since       time.Time
+	Until       time.Time
+	Before      int64 // Cursor: only events with a smaller ID
+	Limit       int
+}
+
+// AuditEventStore is a struct that holds the database connection
+type AuditEventStore struct {
+	DB *sql.DB
+}
+
+// AuditEventRepository is an interface that defines the methods for audit event operations
+type AuditEventRepository interface {
+	CreateAuditEvent(event *AuditEvent) error
+	ListAuditEvents(filter AuditEventFilter) ([]*AuditEvent, error)
+}
+
+// NewAuditEventStore creates a new AuditEventStore with the given database connection
+func NewAuditEventStore(db *sql.DB) *AuditEventStore {
+	return &AuditEventStore{DB: db}
+}
+
+// CreateAuditEvent appends an event to the audit log
+func (s *AuditEventStore) CreateAuditEvent(event *AuditEvent) error {
+	if event.Metadata == nil {
+		event.Metadata = json.RawMessage(`{}`)
+	}
+	query := `INSERT INTO audit_events (actor_id, user_id, workspace_id, event_type, ip_address, user_agent, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	err := s.DB.QueryRow(query, event.ActorID, event.UserID, event.WorkspaceID, event.EventType, event.IPAddress, event.UserAgent, []byte(event.Metadata)).Scan(&event.ID, &event.CreatedAt)
+	return err
+}
+
+// ListAuditEvents returns the events matching the filter, newest first
+func (s *AuditEventStore) ListAuditEvents(filter AuditEventFilter) ([]*AuditEvent, error) {
+	var conditions []string
+	var args []any
+
+	// arg adds a query argument and returns its placeholder
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.WorkspaceID != 0 {
+		placeholder := arg(filter.WorkspaceID)
+		// Account events of members only from the time they joined; what they did before is none of the workspace's business
+		conditions = append(conditions, `(workspace_id = `+placeholder+` OR (workspace_id IS NULL AND EXISTS (SELECT 1 FROM workspace_members m
+			WHERE m.workspace_id = `+placeholder+` AND m.user_id = audit_events.user_id AND m.created_at <= audit_events.created_at)))`)
+	}
+	if filter.UserID != 0 {
+		conditions = append(conditions, "user_id = "+arg(filter.UserID))
+	}
+	if filter.ActorID != 0 {
+		conditions = append(conditions, "actor_id = "+arg(filter.ActorID))
+	}
+	if len(filter.EventTypes) > 0 {
+		conditions = append(conditions, "event_type = ANY("+arg(pq.Array(filter.EventTypes))+")")
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(filter.Until))
+	}
+	if filter.Before != 0 {
+		conditions = append(conditions, "id < "+arg(filter.Before))
+	}
+
+	query := `SELECT id, actor_id, user_id, workspace_id, event_type, ip_address, user_agent, metadata, created_at FROM audit_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT " + arg(filter.Limit)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		event := &AuditEvent{}
+		var metadata []byte
+		err := rows.Scan(&event.ID, &event.ActorID, &event.UserID, &event.WorkspaceID, &event.EventType, &event.IPAddress, &event.UserAgent, &metadata, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.Metadata = metadata
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
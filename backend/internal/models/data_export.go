@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DataExport is a requested archive of everything stored about a user
+type DataExport struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"` // "pending", "processing", "ready" or "failed"
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"` // The archive is deleted afterwards
+}
+
+// DataExportStore is a struct that holds the database connection
+type DataExportStore struct {
+	DB *sql.DB
+}
+
+// DataExportRepository is an interface that defines the methods for data export operations
+type DataExportRepository interface {
+	CreateDataExport(export *DataExport) error
+	GetDataExport(id int, userID int) (*DataExport, error)
+	GetDataExportsByUserID(userID int) ([]*DataExport, error)
+	GetDataExportArchive(id int, userID int) ([]byte, error)
+	ClaimDataExport(staleAfter time.Duration) (*DataExport, error)
+	CompleteDataExport(id int, archive []byte, expiresAt time.Time) error
+	FailDataExport(id int, reason string) error
+	DeleteExpiredDataExports(maxAge time.Duration) error
+}
+
+// NewDataExportStore creates a new DataExportStore with the given database connection
+func NewDataExportStore(db *sql.DB) *DataExportStore {
+	return &DataExportStore{DB: db}
+}
+
+// CreateDataExport queues a new export.
+// It returns sql.ErrNoRows if the user already has an export waiting or in progress.
+func (s *DataExportStore) CreateDataExport(export *DataExport) error {
+	query := `INSERT INTO data_exports (user_id)
+	SELECT $1 WHERE NOT EXISTS (SELECT 1 FROM data_exports WHERE user_id = $1 AND status IN ('pending', 'processing'))
+	RETURNING id, status, created_at`
+	return s.DB.QueryRow(query, export.UserID).Scan(&export.ID, &export.Status, &export.CreatedAt)
+}
+
+// GetDataExport retrieves an export of a user, without its archive
+func (s *DataExportStore) GetDataExport(id int, userID int) (*DataExport, error) {
+	query := `SELECT id, user_id, status, error, created_at, completed_at, expires_at FROM data_exports WHERE id = $1 AND user_id = $2`
+	row := s.DB.QueryRow(query, id, userID)
+	var export DataExport
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.Error, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// GetDataExportsByUserID retrieves the exports of a user, newest first
+func (s *DataExportStore) GetDataExportsByUserID(userID int) ([]*DataExport, error) {
+	query := `SELECT id, user_id, status, error, created_at, completed_at, expires_at FROM data_exports WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := s.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []*DataExport
+	for rows.Next() {
+		var export DataExport
+		err := rows.Scan(&export.ID, &export.UserID, &export.Status, &export.Error, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, &export)
+	}
+	return exports, rows.Err()
+}
+
+// GetDataExportArchive retrieves the archive of a finished, unexpired export
+func (s *DataExportStore) GetDataExportArchive(id int, userID int) ([]byte, error) {
+	query := `SELECT archive FROM data_exports WHERE id = $1 AND user_id = $2 AND status = 'ready' AND expires_at > CURRENT_TIMESTAMP`
+	var archive []byte
+	err := s.DB.QueryRow(query, id, userID).Scan(&archive)
+	return archive, err
+}
+
+// ClaimDataExport marks the oldest waiting export as processing and returns it.
+// Exports stuck in processing for longer than staleAfter are picked up again,
+// so a crashed worker doesn't lose them. SKIP LOCKED lets several replicas work side by side.
+// It returns sql.ErrNoRows when there is nothing to do.
+func (s *DataExportStore) ClaimDataExport(staleAfter time.Duration) (*DataExport, error) {
+	query := `UPDATE data_exports SET status = 'processing', started_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM data_exports
+		WHERE status = 'pending' OR (status = 'processing' AND started_at < $1)
+		ORDER BY created_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, user_id, status, error, created_at, completed_at, expires_at`
+	row := s.DB.QueryRow(query, time.Now().Add(-staleAfter))
+	var export DataExport
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.Error, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// CompleteDataExport stores the archive of an export
+func (s *DataExportStore) CompleteDataExport(id int, archive []byte, expiresAt time.Time) error {
+	query := `UPDATE data_exports SET status = 'ready', archive = $1, completed_at = CURRENT_TIMESTAMP, expires_at = $2 WHERE id = $3`
+	_, err := s.DB.Exec(query, archive, expiresAt, id)
+	return err
+}
+
+// FailDataExport records why an export could not be created
+func (s *DataExportStore) FailDataExport(id int, reason string) error {
+	query := `UPDATE data_exports SET status = 'failed', error = $1, completed_at = CURRENT_TIMESTAMP WHERE id = $2`
+	_, err := s.DB.Exec(query, reason, id)
+	return err
+}
+
+// DeleteExpiredDataExports removes exports whose archive may no longer be downloaded
+// and failed exports older than maxAge
+func (s *DataExportStore) DeleteExpiredDataExports(maxAge time.Duration) error {
+	query := `DELETE FROM data_exports WHERE expires_at <= CURRENT_TIMESTAMP OR (status = 'failed' AND completed_at < $1)`
+	_, err := s.DB.Exec(query, time.Now().Add(-maxAge))
+	return err
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Keys failed logins are counted by, and emails sent on unauthenticated requests
+const (
+	ThrottleKeyAccount = "account"
+	ThrottleKeyIP      = "ip"
+	ThrottleKeyEmail   = "email"
+)
+
+// LoginThrottle counts recent failed logins for an account or a client IP
+type LoginThrottle struct {
+	KeyType      string     `json:"key_type"` // "account", "ip" or "email"
+	Key          string     `json:"key"`      // Normalized email, IP address, or kind of email and user ID
+	FailedCount  int        `json:"failed_count"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until"`
+}
+
+// ThrottlePolicy is how many failures a key is allowed and how long it waits after those.
+// Past FreeAttempts failures, each attempt waits BackoffBase, doubling up to BackoffMax,
+// after the previous failure; failures older than Window are forgotten.
+type ThrottlePolicy struct {
+	Window       time.Duration
+	FreeAttempts int
+	BackoffBase  time.Duration
+	BackoffMax   time.Duration
+}
+
+// LoginThrottleStore is a struct that holds the database connection
+type LoginThrottleStore struct {
+	DB *sql.DB
+}
+
+// LoginThrottleRepository is an interface that defines the methods for login throttle operations
+type LoginThrottleRepository interface {
+	GetThrottle(keyType string, key string) (*LoginThrottle, error)
+	ReserveAttempt(keyType string, key string, policy ThrottlePolicy) (*LoginThrottle, error)
+	ReleaseAttempt(keyType string, key string) error
+	LockThrottle(keyType string, key string, until time.Time) error
+	ClearThrottle(keyType string, key string) error
+}
+
+// NewLoginThrottleStore creates a new LoginThrottleStore with the given database connection
+func NewLoginThrottleStore(db *sql.DB) *LoginThrottleStore {
+	return &LoginThrottleStore{DB: db}
+}
+
+// GetThrottle retrieves the failed login count of a key.
+// It returns sql.ErrNoRows if there were no recent failures.
+func (s *LoginThrottleStore) GetThrottle(keyType string, key string) (*LoginThrottle, error) {
+	query := `SELECT key_type, key, failed_count, last_failed_at, locked_until FROM login_throttles WHERE key_type = $1 AND key = $2`
+	row := s.DB.QueryRow(query, keyType, key)
+	var throttle LoginThrottle
+	err := row.Scan(&throttle.KeyType, &throttle.Key, &throttle.FailedCount, &throttle.LastFailedAt, &throttle.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// ReserveAttempt atomically checks that a key may attempt a login and counts the
+// attempt as failed up front, so concurrent attempts can't all pass the same check.
+// Counting starts over once no failure happened within the window.
+// It returns sql.ErrNoRows, counting nothing, if the key has to wait or is locked.
+func (s *LoginThrottleStore) ReserveAttempt(keyType string, key string, policy ThrottlePolicy) (*LoginThrottle, error) {
+	query := `INSERT INTO login_throttles (key_type, key, failed_count, last_failed_at) VALUES ($1, $2, 1, CURRENT_TIMESTAMP)
+	ON CONFLICT (key_type, key) DO UPDATE SET
+		failed_count = CASE
+			WHEN login_throttles.last_failed_at < CURRENT_TIMESTAMP - make_interval(secs => $3) THEN 1
+			ELSE login_throttles.failed_count + 1
+		END,
+		last_failed_at = CURRENT_TIMESTAMP,
+		previous_failed_at = login_throttles.last_failed_at
+	WHERE (login_throttles.locked_until IS NULL OR login_throttles.locked_until <= CURRENT_TIMESTAMP)
+	AND (login_throttles.last_failed_at < CURRENT_TIMESTAMP - make_interval(secs => $3)
+		OR login_throttles.failed_count < $4
+		OR login_throttles.last_failed_at + make_interval(secs => LEAST($5 * power(2, LEAST(login_throttles.failed_count - $4, 30)), $6)) <= CURRENT_TIMESTAMP)
+	RETURNING key_type, key, failed_count, last_failed_at, locked_until`
+	row := s.DB.QueryRow(query, keyType, key, policy.Window.Seconds(), policy.FreeAttempts, policy.BackoffBase.Seconds(), policy.BackoffMax.Seconds())
+	var throttle LoginThrottle
+	err := row.Scan(&throttle.KeyType, &throttle.Key, &throttle.FailedCount, &throttle.LastFailedAt, &throttle.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// ReleaseAttempt takes back an attempt reserved by ReserveAttempt that turned out not to fail,
+// so the wait for the next attempt runs from the failure before it again
+func (s *LoginThrottleStore) ReleaseAttempt(keyType string, key string) error {
+	query := `UPDATE login_throttles SET failed_count = GREATEST(failed_count - 1, 0),
+		last_failed_at = COALESCE(previous_failed_at, last_failed_at), previous_failed_at = NULL
+	WHERE key_type = $1 AND key = $2`
+	_, err := s.DB.Exec(query, keyType, key)
+	return err
+}
+
+// LockThrottle blocks all logins for a key until the given time
+func (s *LoginThrottleStore) LockThrottle(keyType string, key string, until time.Time) error {
+	query := `UPDATE login_throttles SET locked_until = $1 WHERE key_type = $2 AND key = $3`
+	_, err := s.DB.Exec(query, until, keyType, key)
+	return err
+}
+
+// ClearThrottle forgets the failed logins of a key, lifting any lockout
+func (s *LoginThrottleStore) ClearThrottle(keyType string, key string) error {
+	query := `DELETE FROM login_throttles WHERE key_type = $1 AND key = $2`
+	_, err := s.DB.Exec(query, keyType, key)
+	return err
+}
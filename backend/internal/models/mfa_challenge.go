@@ -0,0 +1,70 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MFAChallenge represents a login that passed the password check and awaits a second factor
+type MFAChallenge struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"-"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MFAChallengeStore is a struct that holds the database connection
+type MFAChallengeStore struct {
+	DB *sql.DB
+}
+
+// MFAChallengeRepository is an interface that defines the methods for MFA challenge operations
+type MFAChallengeRepository interface {
+	CreateChallenge(challenge *MFAChallenge) error
+	GetChallengeByTokenHash(tokenHash string) (*MFAChallenge, error)
+	IncrementAttempts(id int) (int, error)
+	DeleteChallenge(id int) error
+}
+
+// NewMFAChallengeStore creates a new MFAChallengeStore with the given database connection
+func NewMFAChallengeStore(db *sql.DB) *MFAChallengeStore {
+	return &MFAChallengeStore{DB: db}
+}
+
+// CreateChallenge inserts a new challenge into the database
+func (s *MFAChallengeStore) CreateChallenge(challenge *MFAChallenge) error {
+	query := `INSERT INTO mfa_challenges (user_id, token_hash, expires_at) VALUES ($1, $2, $3) RETURNING id, created_at`
+	err := s.DB.QueryRow(query, challenge.UserID, challenge.TokenHash, challenge.ExpiresAt).Scan(&challenge.ID, &challenge.CreatedAt)
+	return err
+}
+
+// GetChallengeByTokenHash retrieves an unexpired challenge by the hash of its token
+func (s *MFAChallengeStore) GetChallengeByTokenHash(tokenHash string) (*MFAChallenge, error) {
+	query := `SELECT id, user_id, token_hash, attempts, expires_at, created_at 
+	FROM mfa_challenges 
+	WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP`
+	row := s.DB.QueryRow(query, tokenHash)
+	var challenge MFAChallenge
+	err := row.Scan(&challenge.ID, &challenge.UserID, &challenge.TokenHash, &challenge.Attempts, &challenge.ExpiresAt, &challenge.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// IncrementAttempts records a failed verification and returns the new attempt count
+func (s *MFAChallengeStore) IncrementAttempts(id int) (int, error) {
+	var attempts int
+	query := `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts`
+	err := s.DB.QueryRow(query, id).Scan(&attempts)
+	return attempts, err
+}
+
+// DeleteChallenge removes a challenge from the database
+func (s *MFAChallengeStore) DeleteChallenge(id int) error {
+	query := `DELETE FROM mfa_challenges WHERE id = $1`
+	_, err := s.DB.Exec(query, id)
+	return err
+}
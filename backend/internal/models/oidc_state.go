@@ -0,0 +1,56 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// OIDCState holds the server side state of an OpenID Connect authorization in progress
+type OIDCState struct {
+	ID           int       `json:"id"`
+	StateHash    string    `json:"-"`
+	Provider     string    `json:"provider"`
+	Nonce        string    `json:"-"`
+	CodeVerifier string    `json:"-"`       // PKCE verifier sent with the code exchange
+	UserID       *int      `json:"user_id"` // Set when a signed in user links a new identity
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// OIDCStateStore is a struct that holds the database connection
+type OIDCStateStore struct {
+	DB *sql.DB
+}
+
+// OIDCStateRepository is an interface that defines the methods for OIDC state operations
+type OIDCStateRepository interface {
+	CreateState(state *OIDCState) error
+	ConsumeState(stateHash string, provider string) (*OIDCState, error)
+}
+
+// NewOIDCStateStore creates a new OIDCStateStore with the given database connection
+func NewOIDCStateStore(db *sql.DB) *OIDCStateStore {
+	return &OIDCStateStore{DB: db}
+}
+
+// CreateState inserts a new state into the database
+func (s *OIDCStateStore) CreateState(state *OIDCState) error {
+	query := `INSERT INTO oidc_states (state_hash, provider, nonce, code_verifier, user_id, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	err := s.DB.QueryRow(query, state.StateHash, state.Provider, state.Nonce, state.CodeVerifier, state.UserID, state.ExpiresAt).Scan(&state.ID, &state.CreatedAt)
+	return err
+}
+
+// ConsumeState deletes and returns an unexpired state, so each authorization response can be used only once.
+// It returns sql.ErrNoRows if no such state exists.
+func (s *OIDCStateStore) ConsumeState(stateHash string, provider string) (*OIDCState, error) {
+	query := `DELETE FROM oidc_states 
+	WHERE state_hash = $1 AND provider = $2 AND expires_at > CURRENT_TIMESTAMP
+	RETURNING id, state_hash, provider, nonce, code_verifier, user_id, expires_at, created_at`
+	row := s.DB.QueryRow(query, stateHash, provider)
+	var state OIDCState
+	err := row.Scan(&state.ID, &state.StateHash, &state.Provider, &state.Nonce, &state.CodeVerifier, &state.UserID, &state.ExpiresAt, &state.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
@@ -0,0 +1,348 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrPageCycle is returned when a page would be moved below itself or one of its descendants
+var ErrPageCycle = errors.New("page cannot be moved below itself")
+
+// ErrPagePublished is returned when deleting a page that is, or has a page below it that is, on the live site
+var ErrPagePublished = errors.New("page is published")
+
+// Page is a page of a site. Pages form a tree; the slugs from the top-level
+// page down to a page make up its path, e.g. /about/team.
+type Page struct {
+	ID                  int        `json:"id"`
+	SiteID              int        `json:"site_id"`
+	ParentID            *int       `json:"parent_id"` // Null for top-level pages
+	Title               string     `json:"title"`
+	Slug                string     `json:"slug"` // Unique among the page's siblings
+	Path                string     `json:"path"`
+	Depth               int        `json:"depth"`                 // Zero for top-level pages
+	SortOrder           int        `json:"sort_order"`            // Position among the page's siblings
+	IsHome              bool       `json:"is_home"`               // The page served at the root of the site
+	Status              string     `json:"status"`                // "draft", "published", or "changed" once the draft is ahead
+	PublishedRevisionID *int       `json:"published_revision_id"` // The revision the live site shows
+	PublishedAt         *time.Time `json:"published_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// PageStore is a struct that holds the database connection
+type PageStore struct {
+	DB *sql.DB
+}
+
+// PageRepository is an interface that defines the methods for page operations
+type PageRepository interface {
+	CreatePage(page *Page) error
+	GetPageByID(siteID int, id int) (*Page, error)
+	GetPageTree(siteID int) ([]*Page, error)
+	UpdatePage(page *Page) error
+	MovePage(siteID int, id int, parentID *int, position int) error
+	GetPageContent(siteID int, id int) (json.RawMessage, error)
+	UpdatePageContent(siteID int, id int, revision *PageRevision) error
+	DeletePage(siteID int, id int) (bool, error)
+}
+
+// NewPageStore creates a new PageStore with the given database connection
+func NewPageStore(db *sql.DB) *PageStore {
+	return &PageStore{DB: db}
+}
+
+// pageTreeQuery walks the page tree of the site in $1 from the top-level pages down,
+// building the path, depth and a key that sorts the pages depth-first in sibling order.
+// It also works out the publishing status of each page against the latest publish of the site.
+const pageTreeQuery = `WITH RECURSIVE tree AS (
+		SELECT id, '/' || slug AS path, 0 AS depth, ARRAY[sort_order, id] AS sort_key
+		FROM pages
+		WHERE site_id = $1 AND parent_id IS NULL
+		UNION ALL
+		SELECT p.id, tree.path || '/' || p.slug, tree.depth + 1, tree.sort_key || ARRAY[p.sort_order, p.id]
+		FROM pages p
+		JOIN tree ON p.parent_id = tree.id
+	)
+	SELECT p.id, p.site_id, p.parent_id, p.title, p.slug, tree.path, tree.depth, p.sort_order, p.is_home,
+		CASE
+			WHEN live.page_id IS NULL THEN 'draft'
+			WHEN live.revision_id = (SELECT MAX(r.id) FROM page_revisions r WHERE r.page_id = p.id)
+				AND live.title = p.title AND live.slug = p.slug AND live.parent_id IS NOT DISTINCT FROM p.parent_id
+				AND live.sort_order = p.sort_order AND live.is_home = p.is_home THEN 'published'
+			ELSE 'changed'
+		END,
+		p.published_revision_id, p.published_at, p.created_at, p.updated_at
+	FROM pages p
+	JOIN tree ON tree.id = p.id
+	LEFT JOIN site_publish_pages live ON live.page_id = p.id
+		AND live.publish_id = (SELECT MAX(id) FROM site_publishes WHERE site_id = $1)`
+
+// CreatePage inserts a new page as the last child of its parent.
+// A new home page replaces the previous one.
+// It returns ErrSlugTaken if a sibling already uses the slug.
+func (s *PageStore) CreatePage(page *Page) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if page.IsHome {
+		_, err = tx.Exec(`UPDATE pages SET is_home = FALSE, updated_at = CURRENT_TIMESTAMP WHERE site_id = $1 AND is_home`, page.SiteID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `INSERT INTO pages (site_id, parent_id, title, slug, is_home, sort_order)
+	VALUES ($1, $2, $3, $4, $5, (SELECT COALESCE(MAX(sort_order) + 1, 0) FROM pages WHERE site_id = $1 AND parent_id IS NOT DISTINCT FROM $2))
+	RETURNING id, sort_order, created_at, updated_at`
+	err = tx.QueryRow(query, page.SiteID, page.ParentID, page.Title, page.Slug, page.IsHome).Scan(&page.ID, &page.SortOrder, &page.CreatedAt, &page.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPageByID retrieves a page of a site along with its path
+func (s *PageStore) GetPageByID(siteID int, id int) (*Page, error) {
+	query := pageTreeQuery + ` WHERE p.id = $2`
+	row := s.DB.QueryRow(query, siteID, id)
+	var page Page
+	err := row.Scan(&page.ID, &page.SiteID, &page.ParentID, &page.Title, &page.Slug, &page.Path, &page.Depth, &page.SortOrder, &page.IsHome, &page.Status, &page.PublishedRevisionID, &page.PublishedAt, &page.CreatedAt, &page.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetPageTree retrieves all pages of a site depth-first, each page followed by its
+// children in sibling order
+func (s *PageStore) GetPageTree(siteID int) ([]*Page, error) {
+	query := pageTreeQuery + ` ORDER BY tree.sort_key`
+	rows, err := s.DB.Query(query, siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []*Page
+	for rows.Next() {
+		var page Page
+		err := rows.Scan(&page.ID, &page.SiteID, &page.ParentID, &page.Title, &page.Slug, &page.Path, &page.Depth, &page.SortOrder, &page.IsHome, &page.Status, &page.PublishedRevisionID, &page.PublishedAt, &page.CreatedAt, &page.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, &page)
+	}
+	return pages, rows.Err()
+}
+
+// UpdatePage updates the title, slug and home flag of a page.
+// Making a page the home page takes the flag from the previous one.
+// It returns ErrSlugTaken if a sibling already uses the slug.
+func (s *PageStore) UpdatePage(page *Page) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if page.IsHome {
+		_, err = tx.Exec(`UPDATE pages SET is_home = FALSE, updated_at = CURRENT_TIMESTAMP WHERE site_id = $1 AND is_home AND id <> $2`, page.SiteID, page.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `UPDATE pages SET title = $1, slug = $2, is_home = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4 AND site_id = $5 RETURNING updated_at`
+	err = tx.QueryRow(query, page.Title, page.Slug, page.IsHome, page.ID, page.SiteID).Scan(&page.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MovePage places a page at position among the children of parentID, or among the
+// top-level pages when parentID is nil, and renumbers its new siblings.
+// Descendants move along with the page, so their paths follow.
+// It returns sql.ErrNoRows if the page or parent doesn't exist on the site,
+// ErrPageCycle if the parent is the page itself or one of its descendants, and
+// ErrSlugTaken if a page with the same slug already exists under the new parent.
+func (s *PageStore) MovePage(siteID int, id int, parentID *int, position int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize changes to the tree of a site, so two moves can't form a cycle together
+	var locked int
+	err = tx.QueryRow(`SELECT id FROM sites WHERE id = $1 FOR UPDATE`, siteID).Scan(&locked)
+	if err != nil {
+		return err
+	}
+
+	if parentID != nil {
+		// Walk up from the new parent; finding the page on the way means a cycle
+		query := `WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM pages WHERE id = $1 AND site_id = $2
+			UNION ALL
+			SELECT p.id, p.parent_id FROM pages p JOIN ancestors a ON p.id = a.parent_id
+		)
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE id = $3) FROM ancestors`
+		var ancestors, cycles int
+		err = tx.QueryRow(query, *parentID, siteID, id).Scan(&ancestors, &cycles)
+		if err != nil {
+			return err
+		}
+		if ancestors == 0 {
+			return sql.ErrNoRows
+		}
+		if cycles > 0 {
+			return ErrPageCycle
+		}
+	}
+
+	result, err := tx.Exec(`UPDATE pages SET parent_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND site_id = $3`, parentID, id, siteID)
+	if isUniqueViolation(err) {
+		return ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != 1 {
+		return sql.ErrNoRows
+	}
+
+	rows, err := tx.Query(`SELECT id FROM pages WHERE site_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND id <> $3 ORDER BY sort_order, id`, siteID, parentID, id)
+	if err != nil {
+		return err
+	}
+	var siblings []int64
+	for rows.Next() {
+		var siblingID int64
+		if err := rows.Scan(&siblingID); err != nil {
+			rows.Close()
+			return err
+		}
+		siblings = append(siblings, siblingID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	position = max(0, min(position, len(siblings)))
+	order := make([]int64, 0, len(siblings)+1)
+	order = append(order, siblings[:position]...)
+	order = append(order, int64(id))
+	order = append(order, siblings[position:]...)
+
+	_, err = tx.Exec(`UPDATE pages p SET sort_order = o.ordinality - 1
+	FROM unnest($1::int[]) WITH ORDINALITY AS o(id, ordinality)
+	WHERE p.id = o.id`, pq.Array(order))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPageContent retrieves the block document of a page
+func (s *PageStore) GetPageContent(siteID int, id int) (json.RawMessage, error) {
+	query := `SELECT content FROM pages WHERE id = $1 AND site_id = $2`
+	var content []byte
+	err := s.DB.QueryRow(query, id, siteID).Scan(&content)
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// UpdatePageContent replaces the block document of a page with the content of
+// revision and records the revision, filling in its ID, page and creation time.
+// It returns sql.ErrNoRows if the page doesn't exist on the site.
+func (s *PageStore) UpdatePageContent(siteID int, id int, revision *PageRevision) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE pages SET content = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND site_id = $3`
+	result, err := tx.Exec(query, []byte(revision.Content), id, siteID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != 1 {
+		return sql.ErrNoRows
+	}
+
+	revision.PageID = id
+	query = `INSERT INTO page_revisions (page_id, author_id, message, content, restored_from) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err = tx.QueryRow(query, id, revision.AuthorID, revision.Message, []byte(revision.Content), revision.RestoredFrom).Scan(&revision.ID, &revision.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePage deletes a page of a site along with all pages below it; it reports whether the page existed.
+// It returns ErrPagePublished if one of the pages is live, since that would change the
+// live site without a publish.
+func (s *PageStore) DeletePage(siteID int, id int) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	err = lockSite(tx, siteID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	query := `WITH RECURSIVE subtree AS (
+		SELECT id, published_revision_id FROM pages WHERE id = $1 AND site_id = $2
+		UNION ALL
+		SELECT p.id, p.published_revision_id FROM pages p JOIN subtree s ON p.parent_id = s.id
+	)
+	SELECT COUNT(*) FILTER (WHERE published_revision_id IS NOT NULL) FROM subtree`
+	var published int
+	err = tx.QueryRow(query, id, siteID).Scan(&published)
+	if err != nil {
+		return false, err
+	}
+	if published > 0 {
+		return false, ErrPagePublished
+	}
+
+	result, err := tx.Exec(`DELETE FROM pages WHERE id = $1 AND site_id = $2`, id, siteID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, tx.Commit()
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// PageRevision is an immutable copy of a page's content, taken every time it is saved
+type PageRevision struct {
+	ID           int             `json:"id"`
+	PageID       int             `json:"page_id"`
+	AuthorID     *int            `json:"author_id"` // Null once the author's account is deleted
+	Author       string          `json:"author,omitempty"`
+	Message      string          `json:"message"`
+	Content      json.RawMessage `json:"content,omitempty"` // Left out of revision lists
+	RestoredFrom *int            `json:"restored_from"`     // The revision this one brought back, if any
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// PageRevisionStore is a struct that holds the database connection
+type PageRevisionStore struct {
+	DB *sql.DB
+}
+
+// PageRevisionRepository is an interface that defines the methods for page revision operations.
+// Revisions are created by PageStore.UpdatePageContent.
+type PageRevisionRepository interface {
+	GetRevision(pageID int, id int) (*PageRevision, error)
+	GetRevisionsByPageID(pageID int, before int, limit int) ([]*PageRevision, error)
+	GetAllRevisionsByPageID(pageID int) ([]*PageRevision, error)
+}
+
+// NewPageRevisionStore creates a new PageRevisionStore with the given database connection
+func NewPageRevisionStore(db *sql.DB) *PageRevisionStore {
+	return &PageRevisionStore{DB: db}
+}
+
+// GetRevision retrieves a revision of a page along with its content
+func (s *PageRevisionStore) GetRevision(pageID int, id int) (*PageRevision, error) {
+	query := `SELECT r.id, r.page_id, r.author_id, COALESCE(u.username, ''), r.message, r.content, r.restored_from, r.created_at
+	FROM page_revisions r
+	LEFT JOIN users u ON u.id = r.author_id
+	WHERE r.id = $1 AND r.page_id = $2`
+	row := s.DB.QueryRow(query, id, pageID)
+	var revision PageRevision
+	var content []byte
+	err := row.Scan(&revision.ID, &revision.PageID, &revision.AuthorID, &revision.Author, &revision.Message, &content, &revision.RestoredFrom, &revision.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	revision.Content = content
+	return &revision, nil
+}
+
+// GetRevisionsByPageID retrieves up to limit revisions of a page older than the
+// revision before, newest first and without their content; before 0 starts at the newest
+func (s *PageRevisionStore) GetRevisionsByPageID(pageID int, before int, limit int) ([]*PageRevision, error) {
+	query := `SELECT r.id, r.page_id, r.author_id, COALESCE(u.username, ''), r.message, r.restored_from, r.created_at
+	FROM page_revisions r
+	LEFT JOIN users u ON u.id = r.author_id
+	WHERE r.page_id = $1 AND ($2 = 0 OR r.id < $2)
+	ORDER BY r.id DESC
+	LIMIT $3`
+	rows, err := s.DB.Query(query, pageID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*PageRevision
+	for rows.Next() {
+		var revision PageRevision
+		err := rows.Scan(&revision.ID, &revision.PageID, &revision.AuthorID, &revision.Author, &revision.Message, &revision.RestoredFrom, &revision.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+	return revisions, rows.Err()
+}
+
+// GetAllRevisionsByPageID retrieves every revision of a page with its content, oldest first
+func (s *PageRevisionStore) GetAllRevisionsByPageID(pageID int) ([]*PageRevision, error) {
+	query := `SELECT r.id, r.page_id, r.author_id, COALESCE(u.username, ''), r.message, r.content, r.restored_from, r.created_at
+	FROM page_revisions r
+	LEFT JOIN users u ON u.id = r.author_id
+	WHERE r.page_id = $1
+	ORDER BY r.id`
+	rows, err := s.DB.Query(query, pageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*PageRevision
+	for rows.Next() {
+		var revision PageRevision
+		var content []byte
+		err := rows.Scan(&revision.ID, &revision.PageID, &revision.AuthorID, &revision.Author, &revision.Message, &content, &revision.RestoredFrom, &revision.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revision.Content = content
+		revisions = append(revisions, &revision)
+	}
+	return revisions, rows.Err()
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// RecoveryCodeStore is a struct that holds the database connection
+type RecoveryCodeStore struct {
+	DB *sql.DB
+}
+
+// RecoveryCodeRepository is an interface that defines the methods for recovery code operations
+type RecoveryCodeRepository interface {
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	ConsumeRecoveryCode(userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+	DeleteRecoveryCodes(userID int) error
+}
+
+// NewRecoveryCodeStore creates a new RecoveryCodeStore with the given database connection
+func NewRecoveryCodeStore(db *sql.DB) *RecoveryCodeStore {
+	return &RecoveryCodeStore{DB: db}
+}
+
+// ReplaceRecoveryCodes swaps all recovery codes of a user for a new set of hashed codes
+func (s *RecoveryCodeStore) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeRecoveryCode deletes a matching recovery code, reporting whether one existed
+func (s *RecoveryCodeStore) ConsumeRecoveryCode(userID int, codeHash string) (bool, error) {
+	query := `DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2`
+	result, err := s.DB.Exec(query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (s *RecoveryCodeStore) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1`, userID).Scan(&count)
+	return count, err
+}
+
+// DeleteRecoveryCodes removes all recovery codes of a user
+func (s *RecoveryCodeStore) DeleteRecoveryCodes(userID int) error {
+	query := `DELETE FROM recovery_codes WHERE user_id = $1`
+	_, err := s.DB.Exec(query, userID)
+	return err
+}
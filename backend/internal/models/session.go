@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Session represents a single signed-in device of a user
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// SessionStore is a struct that holds the database connection
+type SessionStore struct {
+	DB *sql.DB
+}
+
+// SessionRepository is an interface that defines the methods for session operations
+type SessionRepository interface {
+	CreateSession(session *Session) error
+	GetSessionByID(id int) (*Session, error)
+	GetSessionsByUserID(userID int) ([]*Session, error)
+	TouchSession(id int) error
+	DeleteSession(id int, userID int) error
+	DeleteSessionsByUserID(userID int) error
+	DeleteOtherSessions(userID int, keepID int) error
+}
+
+// NewSessionStore creates a new SessionStore with the given database connection
+func NewSessionStore(db *sql.DB) *SessionStore {
+	return &SessionStore{DB: db}
+}
+
+// CreateSession inserts a new session into the database
+func (s *SessionStore) CreateSession(session *Session) error {
+	query := `INSERT INTO sessions (user_id, user_agent, ip_address) VALUES ($1, $2, $3) RETURNING id, created_at, last_seen_at`
+	err := s.DB.QueryRow(query, session.UserID, session.UserAgent, session.IPAddress).Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
+	return err
+}
+
+// GetSessionByID retrieves a session by ID from the database
+func (s *SessionStore) GetSessionByID(id int) (*Session, error) {
+	query := `SELECT id, user_id, user_agent, ip_address, created_at, last_seen_at FROM sessions WHERE id = $1`
+	row := s.DB.QueryRow(query, id)
+	var session Session
+	err := row.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSessionsByUserID retrieves the active sessions of a user, most recently used first.
+// A session is active as long as it holds an unexpired refresh token.
+func (s *SessionStore) GetSessionsByUserID(userID int) ([]*Session, error) {
+	query := `SELECT s.id, s.user_id, s.user_agent, s.ip_address, s.created_at, s.last_seen_at 
+	FROM sessions s 
+	WHERE s.user_id = $1 AND EXISTS (
+		SELECT 1 FROM token t 
+		WHERE t.session_id = s.id AND t.token_type = 'refresh' AND t.expires_at > CURRENT_TIMESTAMP
+	)
+	ORDER BY s.last_seen_at DESC`
+	rows, err := s.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.LastSeenAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession records activity on a session.
+// Writes are throttled to once a minute so authenticated requests don't all hit the disk.
+func (s *SessionStore) TouchSession(id int) error {
+	query := `UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP 
+	WHERE id = $1 AND last_seen_at < CURRENT_TIMESTAMP - INTERVAL '1 minute'`
+	_, err := s.DB.Exec(query, id)
+	return err
+}
+
+// DeleteSession removes a session of the given user, revoking all of its tokens
+func (s *SessionStore) DeleteSession(id int, userID int) error {
+	query := `DELETE FROM sessions WHERE id = $1 AND user_id = $2`
+	_, err := s.DB.Exec(query, id, userID)
+	return err
+}
+
+// DeleteSessionsByUserID removes all sessions for a user, revoking all of their tokens
+func (s *SessionStore) DeleteSessionsByUserID(userID int) error {
+	query := `DELETE FROM sessions WHERE user_id = $1`
+	_, err := s.DB.Exec(query, userID)
+	return err
+}
+
+// DeleteOtherSessions removes all sessions of a user except the one with the given ID
+func (s *SessionStore) DeleteOtherSessions(userID int, keepID int) error {
+	query := `DELETE FROM sessions WHERE user_id = $1 AND id <> $2`
+	_, err := s.DB.Exec(query, userID, keepID)
+	return err
+}
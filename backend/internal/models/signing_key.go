@@ -0,0 +1,111 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// signingKeyLockID identifies the advisory lock that serializes key rotation across replicas
+const signingKeyLockID = 7343120
+
+// SigningKey represents a JWT signing key pair stored as a PEM encoded private key
+type SigningKey struct {
+	KID         string     `json:"kid"`
+	Algorithm   string     `json:"algorithm"`
+	PrivateKey  string     `json:"-"`
+	ActivatesAt time.Time  `json:"activates_at"`
+	ExpiresAt   *time.Time `json:"expires_at"` // Set once a newer key replaces this one
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// SigningKeyStore is a struct that holds the database connection
+type SigningKeyStore struct {
+	DB *sql.DB
+}
+
+// SigningKeyRepository is an interface that defines the methods for signing key operations
+type SigningKeyRepository interface {
+	GetSigningKeys() ([]*SigningKey, error)
+	RotateSigningKey(key *SigningKey, rotateBefore time.Time, gracePeriod time.Duration) (bool, error)
+	DeleteExpiredSigningKeys() error
+}
+
+// NewSigningKeyStore creates a new SigningKeyStore with the given database connection
+func NewSigningKeyStore(db *sql.DB) *SigningKeyStore {
+	return &SigningKeyStore{DB: db}
+}
+
+// GetSigningKeys retrieves all keys that have not expired yet
+func (s *SigningKeyStore) GetSigningKeys() ([]*SigningKey, error) {
+	query := `SELECT kid, algorithm, private_key, activates_at, expires_at, created_at 
+	FROM signing_keys 
+	WHERE expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP
+	ORDER BY activates_at`
+	rows, err := s.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*SigningKey
+	for rows.Next() {
+		var key SigningKey
+		err := rows.Scan(&key.KID, &key.Algorithm, &key.PrivateKey, &key.ActivatesAt, &key.ExpiresAt, &key.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	return keys, rows.Err()
+}
+
+// RotateSigningKey stores a new key unless another replica already rotated since rotateBefore.
+// Keys it replaces stay valid for verification until the grace period after the new key activates.
+// It reports whether the key was stored.
+func (s *SigningKeyStore) RotateSigningKey(key *SigningKey, rotateBefore time.Time, gracePeriod time.Duration) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, signingKeyLockID)
+	if err != nil {
+		return false, err
+	}
+
+	// Another replica may have rotated while we waited for the lock
+	var fresh bool
+	query := `SELECT EXISTS (
+		SELECT 1 FROM signing_keys 
+		WHERE expires_at IS NULL AND algorithm = $1 AND created_at > $2
+	)`
+	err = tx.QueryRow(query, key.Algorithm, rotateBefore).Scan(&fresh)
+	if err != nil {
+		return false, err
+	}
+	if fresh {
+		return false, nil
+	}
+
+	query = `UPDATE signing_keys SET expires_at = $1 WHERE expires_at IS NULL`
+	_, err = tx.Exec(query, key.ActivatesAt.Add(gracePeriod))
+	if err != nil {
+		return false, err
+	}
+
+	query = `INSERT INTO signing_keys (kid, algorithm, private_key, activates_at) VALUES ($1, $2, $3, $4) RETURNING created_at`
+	err = tx.QueryRow(query, key.KID, key.Algorithm, key.PrivateKey, key.ActivatesAt).Scan(&key.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// DeleteExpiredSigningKeys removes keys whose grace period has ended
+func (s *SigningKeyStore) DeleteExpiredSigningKeys() error {
+	query := `DELETE FROM signing_keys WHERE expires_at <= CURRENT_TIMESTAMP`
+	_, err := s.DB.Exec(query)
+	return err
+}
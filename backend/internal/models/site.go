@@ -0,0 +1,150 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrSlugTaken is returned when a slug is already in use where it has to be unique
+var ErrSlugTaken = errors.New("slug already taken")
+
+// Site is a website built in a workspace
+type Site struct {
+	ID            int             `json:"id"`
+	WorkspaceID   int             `json:"workspace_id"`
+	OwnerID       *int            `json:"owner_id"` // The user who created the site, null once they are gone
+	Name          string          `json:"name"`
+	Slug          string          `json:"slug"` // Unique across all sites, used in the site's default address
+	DefaultLocale string          `json:"default_locale"`
+	Settings      json.RawMessage `json:"settings"` // Free-form JSON object owned by the frontend
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// SiteStore is a struct that holds the database connection
+type SiteStore struct {
+	DB *sql.DB
+}
+
+// SiteRepository is an interface that defines the methods for site operations
+type SiteRepository interface {
+	CreateSite(site *Site) error
+	GetSiteByID(id int) (*Site, error)
+	GetSitesByWorkspaceID(workspaceID int) ([]*Site, error)
+	GetSitesByUserID(userID int) ([]*Site, error)
+	GetSitesByOwnerID(ownerID int) ([]*Site, error)
+	UpdateSite(site *Site) error
+	DeleteSite(id int) (bool, error)
+}
+
+// NewSiteStore creates a new SiteStore with the given database connection
+func NewSiteStore(db *sql.DB) *SiteStore {
+	return &SiteStore{DB: db}
+}
+
+const siteColumns = `s.id, s.workspace_id, s.owner_id, s.name, s.slug, s.default_locale, s.settings, s.created_at, s.updated_at`
+
+// CreateSite inserts a new site into the database.
+// It returns ErrSlugTaken if another site already uses the slug.
+func (s *SiteStore) CreateSite(site *Site) error {
+	if site.Settings == nil {
+		site.Settings = json.RawMessage(`{}`)
+	}
+	query := `INSERT INTO sites (workspace_id, owner_id, name, slug, default_locale, settings) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`
+	err := s.DB.QueryRow(query, site.WorkspaceID, site.OwnerID, site.Name, site.Slug, site.DefaultLocale, []byte(site.Settings)).Scan(&site.ID, &site.CreatedAt, &site.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrSlugTaken
+	}
+	return err
+}
+
+// GetSiteByID retrieves a site by ID from the database
+func (s *SiteStore) GetSiteByID(id int) (*Site, error) {
+	query := `SELECT ` + siteColumns + ` FROM sites s WHERE s.id = $1`
+	return scanSite(s.DB.QueryRow(query, id))
+}
+
+// GetSitesByWorkspaceID retrieves the sites of a workspace
+func (s *SiteStore) GetSitesByWorkspaceID(workspaceID int) ([]*Site, error) {
+	query := `SELECT ` + siteColumns + ` FROM sites s WHERE s.workspace_id = $1 ORDER BY s.name, s.id`
+	return s.querySites(query, workspaceID)
+}
+
+// GetSitesByUserID retrieves the sites of every workspace a user is a member of
+func (s *SiteStore) GetSitesByUserID(userID int) ([]*Site, error) {
+	query := `SELECT ` + siteColumns + `
+	FROM sites s
+	JOIN workspace_members m ON m.workspace_id = s.workspace_id
+	WHERE m.user_id = $1
+	ORDER BY s.name, s.id`
+	return s.querySites(query, userID)
+}
+
+// GetSitesByOwnerID retrieves the sites a user created
+func (s *SiteStore) GetSitesByOwnerID(ownerID int) ([]*Site, error) {
+	query := `SELECT ` + siteColumns + ` FROM sites s WHERE s.owner_id = $1 ORDER BY s.created_at`
+	return s.querySites(query, ownerID)
+}
+
+// UpdateSite updates an existing site in the database.
+// It returns ErrSlugTaken if another site already uses the slug.
+func (s *SiteStore) UpdateSite(site *Site) error {
+	query := `UPDATE sites SET name = $1, slug = $2, default_locale = $3, settings = $4, updated_at = CURRENT_TIMESTAMP WHERE id = $5 RETURNING updated_at`
+	err := s.DB.QueryRow(query, site.Name, site.Slug, site.DefaultLocale, []byte(site.Settings), site.ID).Scan(&site.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrSlugTaken
+	}
+	return err
+}
+
+// DeleteSite deletes a site by ID; it reports whether the site existed
+func (s *SiteStore) DeleteSite(id int) (bool, error) {
+	query := `DELETE FROM sites WHERE id = $1`
+	result, err := s.DB.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// querySites runs a query selecting siteColumns and collects the sites
+func (s *SiteStore) querySites(query string, args ...any) ([]*Site, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []*Site
+	for rows.Next() {
+		site, err := scanSite(rows)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, rows.Err()
+}
+
+// scanSite reads a row selected with siteColumns
+func scanSite(row interface{ Scan(...any) error }) (*Site, error) {
+	var site Site
+	var settings []byte
+	err := row.Scan(&site.ID, &site.WorkspaceID, &site.OwnerID, &site.Name, &site.Slug, &site.DefaultLocale, &settings, &site.CreatedAt, &site.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	site.Settings = settings
+	return &site, nil
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
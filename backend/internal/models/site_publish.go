@@ -0,0 +1,424 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrNothingToPublish is returned when a publish would not put any page live
+var ErrNothingToPublish = errors.New("nothing to publish")
+
+// ErrParentNotPublished is returned when a publish would leave a live page below a page that isn't live
+var ErrParentNotPublished = errors.New("parent page is not published")
+
+// Publish actions
+const (
+	PublishActionPublish   = "publish"
+	PublishActionRollback  = "rollback"
+	PublishActionUnpublish = "unpublish"
+)
+
+// SitePublish is a change to what the live version of a site shows.
+// Each one records every page that was live right after it.
+type SitePublish struct {
+	ID          int              `json:"id"`
+	SiteID      int              `json:"site_id"`
+	PublisherID *int             `json:"publisher_id"` // Null once the publisher's account is deleted
+	Publisher   string           `json:"publisher,omitempty"`
+	Action      string           `json:"action"` // "publish", "rollback" or "unpublish"
+	Message     string           `json:"message"`
+	RollbackOf  *int             `json:"rollback_of"` // The publish a rollback returned to
+	PageCount   int              `json:"page_count"`  // Pages live after the change
+	Pages       []*PublishedPage `json:"pages,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+// PublishedPage is a page as it was live after a publish: where it sat in the
+// page tree, what it was called and the revision it showed
+type PublishedPage struct {
+	PageID     int           `json:"page_id"`
+	ParentID   *int          `json:"parent_id"`
+	Title      string        `json:"title"`
+	Slug       string        `json:"slug"`
+	Path       string        `json:"path"`
+	IsHome     bool          `json:"is_home"`
+	RevisionID int           `json:"revision_id"`
+	Revision   *PageRevision `json:"revision,omitempty"`
+}
+
+// SitePublishStore is a struct that holds the database connection
+type SitePublishStore struct {
+	DB *sql.DB
+}
+
+// SitePublishRepository is an interface that defines the methods for publishing operations
+type SitePublishRepository interface {
+	PublishPages(siteID int, pageIDs []int, publish *SitePublish) error
+	RollbackToPublish(siteID int, publishID int, publish *SitePublish) error
+	UnpublishPage(siteID int, pageID int, publish *SitePublish) error
+	GetPublish(siteID int, id int) (*SitePublish, error)
+	GetPublishesBySiteID(siteID int, before int, limit int) ([]*SitePublish, error)
+	GetPublishedPage(siteID int, pageID int) (*PublishedPage, error)
+}
+
+// NewSitePublishStore creates a new SitePublishStore with the given database connection
+func NewSitePublishStore(db *sql.DB) *SitePublishStore {
+	return &SitePublishStore{DB: db}
+}
+
+// publishedTreeQuery walks the pages of the publish in $1 from the top-level pages down,
+// building their live paths from the slugs and parents they were published with
+const publishedTreeQuery = `WITH RECURSIVE tree AS (
+		SELECT page_id, '/' || slug AS path, ARRAY[sort_order, page_id] AS sort_key
+		FROM site_publish_pages
+		WHERE publish_id = $1 AND parent_id IS NULL
+		UNION ALL
+		SELECT pp.page_id, tree.path || '/' || pp.slug, tree.sort_key || ARRAY[pp.sort_order, pp.page_id]
+		FROM site_publish_pages pp
+		JOIN tree ON pp.parent_id = tree.page_id
+		WHERE pp.publish_id = $1
+	)
+	SELECT pp.page_id, pp.parent_id, pp.title, pp.slug, COALESCE(tree.path, ''), pp.is_home, pp.revision_id
+	FROM site_publish_pages pp
+	LEFT JOIN tree ON tree.page_id = pp.page_id
+	WHERE pp.publish_id = $1`
+
+// PublishPages puts the latest revision of the given pages live, or of every page of
+// the site when pageIDs is empty, all in one transaction. Their titles, slugs and
+// places in the page tree go live with them; other live pages stay as they were.
+// Pages whose content was never saved are published with a revision of their current content.
+// It returns sql.ErrNoRows if a page doesn't exist on the site, ErrNothingToPublish
+// if the site has no pages, ErrParentNotPublished if a page would be live below a
+// page that isn't, and ErrSlugTaken if two live siblings would share a slug.
+func (s *SitePublishStore) PublishPages(siteID int, pageIDs []int, publish *SitePublish) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockSite(tx, siteID)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, 0, len(pageIDs))
+	seen := make(map[int]bool, len(pageIDs))
+	for _, id := range pageIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, int64(id))
+		}
+	}
+	if len(ids) == 0 {
+		rows, err := tx.Query(`SELECT id FROM pages WHERE site_id = $1`, siteID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return ErrNothingToPublish
+		}
+	}
+
+	query := `INSERT INTO page_revisions (page_id, author_id, message, content)
+	SELECT p.id, $3, 'Published', p.content FROM pages p
+	WHERE p.site_id = $1 AND p.id = ANY($2::int[])
+	AND NOT EXISTS (SELECT 1 FROM page_revisions r WHERE r.page_id = p.id)`
+	_, err = tx.Exec(query, siteID, pq.Array(ids), publish.PublisherID)
+	if err != nil {
+		return err
+	}
+
+	previous, err := latestPublish(tx, siteID)
+	if err != nil {
+		return err
+	}
+
+	publish.Action = PublishActionPublish
+	err = recordPublish(tx, siteID, publish, previous, ids, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RollbackToPublish makes the live site show exactly the pages it showed after an
+// earlier publish, with the revisions, titles and places they had then.
+// Pages deleted since then stay gone.
+// It returns sql.ErrNoRows if the publish doesn't belong to the site.
+func (s *SitePublishStore) RollbackToPublish(siteID int, publishID int, publish *SitePublish) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockSite(tx, siteID)
+	if err != nil {
+		return err
+	}
+
+	var found int
+	err = tx.QueryRow(`SELECT id FROM site_publishes WHERE id = $1 AND site_id = $2`, publishID, siteID).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	publish.Action = PublishActionRollback
+	publish.RollbackOf = &publishID
+	err = recordPublish(tx, siteID, publish, publishID, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UnpublishPage takes a page off the live site along with the live pages below it;
+// their drafts and revisions stay.
+// It returns sql.ErrNoRows if the page doesn't exist on the site or isn't published.
+func (s *SitePublishStore) UnpublishPage(siteID int, pageID int, publish *SitePublish) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockSite(tx, siteID)
+	if err != nil {
+		return err
+	}
+
+	previous, err := latestPublish(tx, siteID)
+	if err != nil {
+		return err
+	}
+
+	// The pages below it on the live site, which may differ from those below its draft
+	query := `WITH RECURSIVE subtree AS (
+		SELECT page_id FROM site_publish_pages WHERE publish_id = $1 AND page_id = $2
+		UNION ALL
+		SELECT pp.page_id FROM site_publish_pages pp JOIN subtree s ON pp.parent_id = s.page_id
+		WHERE pp.publish_id = $1
+	)
+	SELECT page_id FROM subtree`
+	rows, err := tx.Query(query, previous, pageID)
+	if err != nil {
+		return err
+	}
+	var dropped []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		dropped = append(dropped, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(dropped) == 0 {
+		return sql.ErrNoRows
+	}
+
+	publish.Action = PublishActionUnpublish
+	err = recordPublish(tx, siteID, publish, previous, nil, dropped)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockSite serializes publishing on a site, so every publish records a consistent set of live pages
+func lockSite(tx *sql.Tx, siteID int) error {
+	var locked int
+	return tx.QueryRow(`SELECT id FROM sites WHERE id = $1 FOR UPDATE`, siteID).Scan(&locked)
+}
+
+// latestPublish returns the ID of the newest publish of a site, whose pages are the live site, or 0 if there is none
+func latestPublish(tx *sql.Tx, siteID int) (int, error) {
+	var id int
+	err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM site_publishes WHERE site_id = $1`, siteID).Scan(&id)
+	return id, err
+}
+
+// recordPublish adds a publish to the history and makes its pages the live site.
+// Those are the pages in fresh as their drafts stand now, published at their latest
+// revisions, plus the pages of the publish from except those in fresh or dropped.
+func recordPublish(tx *sql.Tx, siteID int, publish *SitePublish, from int, fresh []int64, dropped []int64) error {
+	publish.SiteID = siteID
+	query := `INSERT INTO site_publishes (site_id, publisher_id, action, message, rollback_of) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := tx.QueryRow(query, siteID, publish.PublisherID, publish.Action, publish.Message, publish.RollbackOf).Scan(&publish.ID, &publish.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO site_publish_pages (publish_id, page_id, revision_id, parent_id, title, slug, sort_order, is_home)
+	SELECT $1, p.id, (SELECT MAX(r.id) FROM page_revisions r WHERE r.page_id = p.id), p.parent_id, p.title, p.slug, p.sort_order, p.is_home
+	FROM pages p
+	WHERE p.site_id = $2 AND p.id = ANY($3::int[])`
+	result, err := tx.Exec(query, publish.ID, siteID, pq.Array(fresh))
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != int64(len(fresh)) {
+		return sql.ErrNoRows
+	}
+
+	// A page that goes live as the home page takes over from the one that was
+	skip := append(append([]int64{}, fresh...), dropped...)
+	query = `INSERT INTO site_publish_pages (publish_id, page_id, revision_id, parent_id, title, slug, sort_order, is_home)
+	SELECT $1, pp.page_id, pp.revision_id, pp.parent_id, pp.title, pp.slug, pp.sort_order,
+		pp.is_home AND NOT EXISTS (SELECT 1 FROM site_publish_pages h WHERE h.publish_id = $1 AND h.is_home)
+	FROM site_publish_pages pp
+	WHERE pp.publish_id = $2 AND NOT pp.page_id = ANY($3::int[])`
+	_, err = tx.Exec(query, publish.ID, from, pq.Array(skip))
+	if isUniqueViolation(err) {
+		return ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	// Every live page must be reachable from the top of the live site
+	query = `WITH RECURSIVE tree AS (
+		SELECT page_id FROM site_publish_pages WHERE publish_id = $1 AND parent_id IS NULL
+		UNION ALL
+		SELECT pp.page_id FROM site_publish_pages pp JOIN tree ON pp.parent_id = tree.page_id
+		WHERE pp.publish_id = $1
+	)
+	SELECT (SELECT COUNT(*) FROM site_publish_pages WHERE publish_id = $1), (SELECT COUNT(*) FROM tree)`
+	var live, reachable int
+	err = tx.QueryRow(query, publish.ID).Scan(&live, &reachable)
+	if err != nil {
+		return err
+	}
+	if reachable < live {
+		return ErrParentNotPublished
+	}
+	publish.PageCount = live
+
+	query = `UPDATE pages SET published_revision_id = NULL, published_at = NULL
+	WHERE site_id = $1 AND published_revision_id IS NOT NULL
+	AND id NOT IN (SELECT page_id FROM site_publish_pages WHERE publish_id = $2)`
+	_, err = tx.Exec(query, siteID, publish.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE pages p SET published_revision_id = pp.revision_id, published_at = CURRENT_TIMESTAMP
+	FROM site_publish_pages pp
+	WHERE pp.publish_id = $1 AND pp.page_id = p.id
+	AND (p.published_revision_id IS DISTINCT FROM pp.revision_id OR p.id = ANY($2::int[]))`
+	_, err = tx.Exec(query, publish.ID, pq.Array(fresh))
+	return err
+}
+
+// GetPublish retrieves a publish of a site along with the pages that were live after it
+func (s *SitePublishStore) GetPublish(siteID int, id int) (*SitePublish, error) {
+	query := `SELECT sp.id, sp.site_id, sp.publisher_id, COALESCE(u.username, ''), sp.action, sp.message, sp.rollback_of,
+		(SELECT COUNT(*) FROM site_publish_pages pp WHERE pp.publish_id = sp.id), sp.created_at
+	FROM site_publishes sp
+	LEFT JOIN users u ON u.id = sp.publisher_id
+	WHERE sp.id = $1 AND sp.site_id = $2`
+	row := s.DB.QueryRow(query, id, siteID)
+	var publish SitePublish
+	err := row.Scan(&publish.ID, &publish.SiteID, &publish.PublisherID, &publish.Publisher, &publish.Action, &publish.Message, &publish.RollbackOf, &publish.PageCount, &publish.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.Query(publishedTreeQuery+` ORDER BY tree.sort_key NULLS LAST, pp.page_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	publish.Pages = []*PublishedPage{}
+	for rows.Next() {
+		var page PublishedPage
+		err := rows.Scan(&page.PageID, &page.ParentID, &page.Title, &page.Slug, &page.Path, &page.IsHome, &page.RevisionID)
+		if err != nil {
+			return nil, err
+		}
+		publish.Pages = append(publish.Pages, &page)
+	}
+	return &publish, rows.Err()
+}
+
+// GetPublishesBySiteID retrieves up to limit publishes of a site older than the
+// publish before, newest first; before 0 starts at the newest
+func (s *SitePublishStore) GetPublishesBySiteID(siteID int, before int, limit int) ([]*SitePublish, error) {
+	query := `SELECT sp.id, sp.site_id, sp.publisher_id, COALESCE(u.username, ''), sp.action, sp.message, sp.rollback_of,
+		(SELECT COUNT(*) FROM site_publish_pages pp WHERE pp.publish_id = sp.id), sp.created_at
+	FROM site_publishes sp
+	LEFT JOIN users u ON u.id = sp.publisher_id
+	WHERE sp.site_id = $1 AND ($2 = 0 OR sp.id < $2)
+	ORDER BY sp.id DESC
+	LIMIT $3`
+	rows, err := s.DB.Query(query, siteID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var publishes []*SitePublish
+	for rows.Next() {
+		var publish SitePublish
+		err := rows.Scan(&publish.ID, &publish.SiteID, &publish.PublisherID, &publish.Publisher, &publish.Action, &publish.Message, &publish.RollbackOf, &publish.PageCount, &publish.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		publishes = append(publishes, &publish)
+	}
+	return publishes, rows.Err()
+}
+
+// GetPublishedPage retrieves a page of a site as the live site shows it, along with its revision
+func (s *SitePublishStore) GetPublishedPage(siteID int, pageID int) (*PublishedPage, error) {
+	var publishID int
+	err := s.DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM site_publishes WHERE site_id = $1`, siteID).Scan(&publishID)
+	if err != nil {
+		return nil, err
+	}
+
+	var page PublishedPage
+	err = s.DB.QueryRow(publishedTreeQuery+` AND pp.page_id = $2`, publishID, pageID).
+		Scan(&page.PageID, &page.ParentID, &page.Title, &page.Slug, &page.Path, &page.IsHome, &page.RevisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT r.id, r.page_id, r.author_id, COALESCE(u.username, ''), r.message, r.content, r.restored_from, r.created_at
+	FROM page_revisions r
+	LEFT JOIN users u ON u.id = r.author_id
+	WHERE r.id = $1`
+	var revision PageRevision
+	var content []byte
+	err = s.DB.QueryRow(query, page.RevisionID).Scan(&revision.ID, &revision.PageID, &revision.AuthorID, &revision.Author, &revision.Message, &content, &revision.RestoredFrom, &revision.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	revision.Content = content
+	page.Revision = &revision
+	return &page, nil
+}
@@ -7,13 +7,15 @@ import (
 
 // Token represents a user authentication token
 type Token struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	Token     string    `json:"token"`
-	TokenType string    `json:"token_type"` // "access" or "refresh"
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	SessionID *int       `json:"session_id"`
+	Token     string     `json:"token"`
+	TokenType string     `json:"token_type"` // "access", "refresh", "impersonation" or a single-use type such as "email_verification"
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at"` // Set once a refresh token has been exchanged
 }
 
 // TokenStore is a struct that holds the database connection
@@ -25,10 +27,14 @@ type TokenStore struct {
 type TokenRepository interface {
 	CreateToken(token *Token) error
 	GetTokenByID(id int) (*Token, error)
-	GetTokenByUserID(userID int) (*Token, error)
+	GetTokenByTokenID(tokenID string) (*Token, error)
 	GetTokensByUserID(userID int) ([]*Token, error)
 	DeleteToken(id int) error
 	DeleteTokensByUserID(userID int) error
+	DeleteTokensBySessionID(sessionID int, tokenType string) error
+	DeleteTokensByType(userID int, tokenType string) error
+	ConsumeToken(token string, tokenType string) (*Token, error)
+	MarkTokenRotated(id int) (bool, error)
 	UpdateToken(token *Token) error
 }
 
@@ -39,50 +45,29 @@ func NewTokenStore(db *sql.DB) *TokenStore {
 
 // CreateToken inserts a new token into the database
 func (s *TokenStore) CreateToken(token *Token) error {
-	query := `INSERT INTO token (user_id, token, token_type, expires_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
-	err := s.DB.QueryRow(query, token.UserID, token.Token, token.TokenType, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt, &token.UpdatedAt)
+	query := `INSERT INTO token (user_id, session_id, token, token_type, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at`
+	err := s.DB.QueryRow(query, token.UserID, token.SessionID, token.Token, token.TokenType, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt, &token.UpdatedAt)
 	return err
 }
 
 // GetTokenByID retrieves a token by ID from the database
 func (s *TokenStore) GetTokenByID(id int) (*Token, error) {
-	query := `SELECT id, user_id, token, token_type, created_at, updated_at, expires_at FROM token WHERE id = $1`
+	query := `SELECT id, user_id, session_id, token, token_type, created_at, updated_at, expires_at, rotated_at FROM token WHERE id = $1`
 	row := s.DB.QueryRow(query, id)
 	var token Token
-	err := row.Scan(&token.ID, &token.UserID, &token.Token, &token.TokenType, &token.CreatedAt, &token.UpdatedAt, &token.ExpiresAt)
+	err := row.Scan(&token.ID, &token.UserID, &token.SessionID, &token.Token, &token.TokenType, &token.CreatedAt, &token.UpdatedAt, &token.ExpiresAt, &token.RotatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &token, nil
 }
 
-// GetTokenByUserID retrieves a token by user ID from the database
-func (s *TokenStore) GetTokenByUserID(userID int) (*Token, error) {
-	query := `SELECT id, user_id, token, token_type, created_at, updated_at, expires_at 
-	FROM token 
-	WHERE user_id = $1 AND token_type = 'access'
-	ORDER BY created_at DESC LIMIT 1`
-
-	row := s.DB.QueryRow(query, userID)
-
-	var token Token
-	err := row.Scan(&token.ID, &token.UserID, &token.Token, &token.TokenType, &token.CreatedAt, &token.UpdatedAt, &token.ExpiresAt)
-	if err != nil {
-		return nil, err
-	}
-
-	return &token, nil
-}
-
-// GetRefreshTokenByUserID retrieves the refresh token by user ID from the database
-func (s *TokenStore) GetRefreshTokenByUserID(userID int) (*Token, error) {
-	query := `SELECT id, user_id, token, token_type, created_at, updated_at, expires_at 
-						FROM token 
-						WHERE user_id = $1 AND token_type = 'refresh'
-						ORDER BY created_at DESC LIMIT 1`
-	row := s.DB.QueryRow(query, userID)
+// GetTokenByTokenID retrieves a token by its unique token identifier (the JWT token_id claim)
+func (s *TokenStore) GetTokenByTokenID(tokenID string) (*Token, error) {
+	query := `SELECT id, user_id, session_id, token, token_type, created_at, updated_at, expires_at, rotated_at FROM token WHERE token = $1`
+	row := s.DB.QueryRow(query, tokenID)
 	var token Token
-	err := row.Scan(&token.ID, &token.UserID, &token.Token, &token.TokenType, &token.CreatedAt, &token.UpdatedAt, &token.ExpiresAt)
+	err := row.Scan(&token.ID, &token.UserID, &token.SessionID, &token.Token, &token.TokenType, &token.CreatedAt, &token.UpdatedAt, &token.ExpiresAt, &token.RotatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -91,7 +76,7 @@ func (s *TokenStore) GetRefreshTokenByUserID(userID int) (*Token, error) {
 
 // GetTokensByUserID retrieves all tokens for a user from the database
 func (s *TokenStore) GetTokensByUserID(userID int) ([]*Token, error) {
-	query := `SELECT id, user_id, token, token_type, created_at, updated_at, expires_at FROM token WHERE user_id = $1`
+	query := `SELECT id, user_id, session_id, token, token_type, created_at, updated_at, expires_at, rotated_at FROM token WHERE user_id = $1`
 	rows, err := s.DB.Query(query, userID)
 	if err != nil {
 		return nil, err
@@ -101,13 +86,13 @@ func (s *TokenStore) GetTokensByUserID(userID int) ([]*Token, error) {
 	var tokens []*Token
 	for rows.Next() {
 		var token Token
-		err := rows.Scan(&token.ID, &token.UserID, &token.Token, &token.TokenType, &token.CreatedAt, &token.UpdatedAt, &token.ExpiresAt)
+		err := rows.Scan(&token.ID, &token.UserID, &token.SessionID, &token.Token, &token.TokenType, &token.CreatedAt, &token.UpdatedAt, &token.ExpiresAt, &token.RotatedAt)
 		if err != nil {
 			return nil, err
 		}
 		tokens = append(tokens, &token)
 	}
-	return tokens, nil
+	return tokens, rows.Err()
 }
 
 // DeleteToken removes a token from the database
@@ -124,9 +109,54 @@ func (s *TokenStore) DeleteTokensByUserID(userID int) error {
 	return err
 }
 
+// DeleteTokensBySessionID removes all tokens of the given type that belong to a session
+func (s *TokenStore) DeleteTokensBySessionID(sessionID int, tokenType string) error {
+	query := `DELETE FROM token WHERE session_id = $1 AND token_type = $2`
+	_, err := s.DB.Exec(query, sessionID, tokenType)
+	return err
+}
+
+// DeleteTokensByType removes all tokens of the given type for a user
+func (s *TokenStore) DeleteTokensByType(userID int, tokenType string) error {
+	query := `DELETE FROM token WHERE user_id = $1 AND token_type = $2`
+	_, err := s.DB.Exec(query, userID, tokenType)
+	return err
+}
+
+// ConsumeToken deletes and returns an unexpired single-use token.
+// It returns sql.ErrNoRows if the token does not exist, has expired or was already used.
+func (s *TokenStore) ConsumeToken(token string, tokenType string) (*Token, error) {
+	query := `DELETE FROM token 
+	WHERE token = $1 AND token_type = $2 AND expires_at > CURRENT_TIMESTAMP
+	RETURNING id, user_id, session_id, token, token_type, created_at, updated_at, expires_at, rotated_at`
+	row := s.DB.QueryRow(query, token, tokenType)
+	var t Token
+	err := row.Scan(&t.ID, &t.UserID, &t.SessionID, &t.Token, &t.TokenType, &t.CreatedAt, &t.UpdatedAt, &t.ExpiresAt, &t.RotatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// MarkTokenRotated flags a refresh token as exchanged.
+// It reports false if the token had already been rotated, which makes
+// concurrent refreshes with the same token race-safe.
+func (s *TokenStore) MarkTokenRotated(id int) (bool, error) {
+	query := `UPDATE token SET rotated_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND rotated_at IS NULL`
+	result, err := s.DB.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
 // UpdateToken updates an existing token in the database
 func (s *TokenStore) UpdateToken(token *Token) error {
-	query := `UPDATE token SET user_id = $1, token = $2, token_type = $3, expires_at = $4, updated_at = CURRENT_TIMESTAMP WHERE id = $5`
-	_, err := s.DB.Exec(query, token.UserID, token.Token, token.TokenType, token.ExpiresAt, token.ID)
+	query := `UPDATE token SET user_id = $1, session_id = $2, token = $3, token_type = $4, expires_at = $5, updated_at = CURRENT_TIMESTAMP WHERE id = $6`
+	_, err := s.DB.Exec(query, token.UserID, token.SessionID, token.Token, token.TokenType, token.ExpiresAt, token.ID)
 	return err
 }
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserTOTP represents the TOTP authenticator of a user.
+// It only protects logins once ConfirmedAt is set.
+type UserTOTP struct {
+	UserID       int        `json:"user_id"`
+	Secret       string     `json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// TOTPStore is a struct that holds the database connection
+type TOTPStore struct {
+	DB *sql.DB
+}
+
+// TOTPRepository is an interface that defines the methods for TOTP operations
+type TOTPRepository interface {
+	SaveTOTP(totp *UserTOTP) error
+	GetTOTPByUserID(userID int) (*UserTOTP, error)
+	ConfirmTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	DeleteTOTP(userID int) error
+}
+
+// NewTOTPStore creates a new TOTPStore with the given database connection
+func NewTOTPStore(db *sql.DB) *TOTPStore {
+	return &TOTPStore{DB: db}
+}
+
+// SaveTOTP stores a new unconfirmed authenticator, replacing an earlier unfinished enrollment
+func (s *TOTPStore) SaveTOTP(totp *UserTOTP) error {
+	query := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, confirmed_at = NULL, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+	RETURNING created_at`
+	err := s.DB.QueryRow(query, totp.UserID, totp.Secret).Scan(&totp.CreatedAt)
+	return err
+}
+
+// GetTOTPByUserID retrieves the authenticator of a user from the database
+func (s *TOTPStore) GetTOTPByUserID(userID int) (*UserTOTP, error) {
+	query := `SELECT user_id, secret, confirmed_at, last_used_step, created_at FROM user_totp WHERE user_id = $1`
+	row := s.DB.QueryRow(query, userID)
+	var totp UserTOTP
+	err := row.Scan(&totp.UserID, &totp.Secret, &totp.ConfirmedAt, &totp.LastUsedStep, &totp.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// ConfirmTOTP enables the authenticator of a user
+func (s *TOTPStore) ConfirmTOTP(userID int) error {
+	query := `UPDATE user_totp SET confirmed_at = CURRENT_TIMESTAMP WHERE user_id = $1`
+	_, err := s.DB.Exec(query, userID)
+	return err
+}
+
+// UseTOTPStep records the time step of an accepted code.
+// It reports false if that step or a later one was already used, so codes cannot be replayed.
+func (s *TOTPStore) UseTOTPStep(userID int, step int64) (bool, error) {
+	query := `UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+	result, err := s.DB.Exec(query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// DeleteTOTP removes the authenticator of a user
+func (s *TOTPStore) DeleteTOTP(userID int) error {
+	query := `DELETE FROM user_totp WHERE user_id = $1`
+	_, err := s.DB.Exec(query, userID)
+	return err
+}
@@ -7,12 +7,27 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID           int       `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"` // Don't include in JSON
-	Username     string    `json:"username"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID              int        `json:"id"`
+	Email           string     `json:"email"`
+	PasswordHash    string     `json:"-"` // Don't include in JSON
+	Username        string     `json:"username"`
+	DisplayName     string     `json:"display_name"`
+	AvatarURL       string     `json:"avatar_url"`
+	Locale          string     `json:"locale"`   // BCP 47 language tag such as "en" or "pt-BR"
+	Timezone        string     `json:"timezone"` // IANA time zone such as "Europe/Budapest"
+	EmailVerified   bool       `json:"email_verified"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Role            string     `json:"role"` // "owner", "admin", "editor" or "viewer"
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"` // Set while the account waits to be deleted
+}
+
+// OwnershipTransfer is a workspace handed to another member when its owner's account was deleted
+type OwnershipTransfer struct {
+	WorkspaceID int
+	SuccessorID int
 }
 
 // UserStore is a struct that holds the database connection
@@ -26,7 +41,17 @@ type UserRepository interface {
 	GetUserByID(id int) (*User, error)
 	GetUserByEmail(email string) (*User, error)
 	UpdateUser(user *User) error
+	UpdateProfile(user *User) error
+	UpdatePasswordHash(id int, hash string) error
 	DeleteUser(id int) error
+	DeleteAccount(id int) ([]OwnershipTransfer, error)
+	MarkEmailVerified(id int) error
+	ChangeEmail(id int, email string) error
+	UpdateUserRole(id int, role string) error
+	CountUsersByRole(role string) (int, error)
+	ScheduleDeletion(id int, at time.Time) error
+	CancelDeletion(id int) error
+	GetUsersDueForDeletion() ([]int, error)
 }
 
 // NewUserStore creates a new UserStore with the given database connection
@@ -36,17 +61,17 @@ func NewUserStore(db *sql.DB) *UserStore {
 
 // CreateUser inserts a new user into the database
 func (s *UserStore) CreateUser(user *User) error {
-	query := `INSERT INTO users (email, password_hash, username) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at`
-	err := s.DB.QueryRow(query, user.Email, user.PasswordHash, user.Username).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	query := `INSERT INTO users (email, password_hash, username) VALUES ($1, $2, $3) RETURNING id, display_name, avatar_url, locale, timezone, role, created_at, updated_at`
+	err := s.DB.QueryRow(query, user.Email, user.PasswordHash, user.Username).Scan(&user.ID, &user.DisplayName, &user.AvatarURL, &user.Locale, &user.Timezone, &user.Role, &user.CreatedAt, &user.UpdatedAt)
 	return err
 }
 
 // GetUserByID retrieves a user by ID from the database
 func (s *UserStore) GetUserByID(id int) (*User, error) {
-	query := `SELECT id, email, password_hash, username, created_at, updated_at FROM users WHERE id = $1`
+	query := `SELECT id, email, password_hash, username, display_name, avatar_url, locale, timezone, email_verified, email_verified_at, role, deletion_scheduled_at, created_at, updated_at FROM users WHERE id = $1`
 	row := s.DB.QueryRow(query, id)
 	var user User
-	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Username, &user.CreatedAt, &user.UpdatedAt)
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Locale, &user.Timezone, &user.EmailVerified, &user.EmailVerifiedAt, &user.Role, &user.DeletionScheduledAt, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -55,10 +80,10 @@ func (s *UserStore) GetUserByID(id int) (*User, error) {
 
 // GetUserByEmail retrieves a user by email from the database
 func (s *UserStore) GetUserByEmail(email string) (*User, error) {
-	query := `SELECT id, email, password_hash, username, created_at, updated_at FROM users WHERE email = $1`
+	query := `SELECT id, email, password_hash, username, display_name, avatar_url, locale, timezone, email_verified, email_verified_at, role, deletion_scheduled_at, created_at, updated_at FROM users WHERE email = $1`
 	row := s.DB.QueryRow(query, email)
 	var user User
-	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Username, &user.CreatedAt, &user.UpdatedAt)
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Locale, &user.Timezone, &user.EmailVerified, &user.EmailVerifiedAt, &user.Role, &user.DeletionScheduledAt, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -67,14 +92,158 @@ func (s *UserStore) GetUserByEmail(email string) (*User, error) {
 
 // UpdateUser updates an existing user in the database
 func (s *UserStore) UpdateUser(user *User) error {
-	query := `UPDATE users SET email = $1, password_hash = $2, username = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4`
-	_, err := s.DB.Exec(query, user.Email, user.PasswordHash, user.Username, user.ID)
+	query := `UPDATE users SET email = $1, password_hash = $2, username = $3, display_name = $4, avatar_url = $5, locale = $6, timezone = $7, updated_at = CURRENT_TIMESTAMP WHERE id = $8`
+	_, err := s.DB.Exec(query, user.Email, user.PasswordHash, user.Username, user.DisplayName, user.AvatarURL, user.Locale, user.Timezone, user.ID)
+	return err
+}
+
+// UpdateProfile saves the profile fields of a user and leaves the email and password as they are,
+// so a profile edit never undoes a credential change made in the meantime
+func (s *UserStore) UpdateProfile(user *User) error {
+	query := `UPDATE users SET username = $1, display_name = $2, avatar_url = $3, locale = $4, timezone = $5, updated_at = CURRENT_TIMESTAMP WHERE id = $6 RETURNING updated_at`
+	return s.DB.QueryRow(query, user.Username, user.DisplayName, user.AvatarURL, user.Locale, user.Timezone, user.ID).Scan(&user.UpdatedAt)
+}
+
+// UpdatePasswordHash replaces the password hash of a user and leaves the rest as it is,
+// so a password change never undoes a profile or email change made in the meantime
+func (s *UserStore) UpdatePasswordHash(id int, hash string) error {
+	query := `UPDATE users SET password_hash = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+	_, err := s.DB.Exec(query, hash, id)
 	return err
 }
 
-// DeleteUser deletes a user from the database
+// DeleteUser deletes a user from the database.
+// Sessions, tokens, credentials and memberships go with it.
 func (s *UserStore) DeleteUser(id int) error {
 	query := `DELETE FROM users WHERE id = $1`
 	_, err := s.DB.Exec(query, id)
 	return err
 }
+
+// DeleteAccount deletes a user together with everything that belongs to them, in one transaction.
+// Workspaces the user owns that have other members go to the highest ranked, longest standing
+// other member; workspaces nobody else belongs to are deleted with their content.
+func (s *UserStore) DeleteAccount(id int) ([]OwnershipTransfer, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT m.workspace_id FROM workspace_members m
+	WHERE m.user_id = $1 AND m.role = 'owner'
+	AND EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = m.workspace_id AND o.user_id <> $1)
+	ORDER BY m.workspace_id
+	FOR UPDATE`
+	rows, err := tx.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	var transfers []OwnershipTransfer
+	for rows.Next() {
+		var transfer OwnershipTransfer
+		if err := rows.Scan(&transfer.WorkspaceID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range transfers {
+		query = `SELECT user_id FROM workspace_members
+		WHERE workspace_id = $1 AND user_id <> $2
+		ORDER BY CASE role WHEN 'admin' THEN 1 WHEN 'editor' THEN 2 ELSE 3 END, created_at
+		LIMIT 1`
+		err = tx.QueryRow(query, transfers[i].WorkspaceID, id).Scan(&transfers[i].SuccessorID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = transferOwnership(tx, transfers[i].WorkspaceID, id, transfers[i].SuccessorID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query = `DELETE FROM workspaces w
+	WHERE EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id = $1 AND m.role = 'owner')
+	AND NOT EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = w.id AND o.user_id <> $1)`
+	_, err = tx.Exec(query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfers, tx.Commit()
+}
+
+// MarkEmailVerified records that a user has proven ownership of their email address
+func (s *UserStore) MarkEmailVerified(id int) error {
+	query := `UPDATE users SET email_verified = TRUE, email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+	_, err := s.DB.Exec(query, id)
+	return err
+}
+
+// ChangeEmail switches a user to a new email address they have just proven to own
+func (s *UserStore) ChangeEmail(id int, email string) error {
+	query := `UPDATE users SET email = $1, email_verified = TRUE, email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+	_, err := s.DB.Exec(query, email, id)
+	return err
+}
+
+// UpdateUserRole changes the role of a user
+func (s *UserStore) UpdateUserRole(id int, role string) error {
+	query := `UPDATE users SET role = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+	_, err := s.DB.Exec(query, role, id)
+	return err
+}
+
+// CountUsersByRole counts the users with the given role
+func (s *UserStore) CountUsersByRole(role string) (int, error) {
+	query := `SELECT COUNT(*) FROM users WHERE role = $1`
+	var count int
+	err := s.DB.QueryRow(query, role).Scan(&count)
+	return count, err
+}
+
+// ScheduleDeletion marks a user to be deleted at the given time
+func (s *UserStore) ScheduleDeletion(id int, at time.Time) error {
+	query := `UPDATE users SET deletion_scheduled_at = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+	_, err := s.DB.Exec(query, at, id)
+	return err
+}
+
+// CancelDeletion keeps a user that was scheduled for deletion
+func (s *UserStore) CancelDeletion(id int) error {
+	query := `UPDATE users SET deletion_scheduled_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+	_, err := s.DB.Exec(query, id)
+	return err
+}
+
+// GetUsersDueForDeletion returns the IDs of users whose grace period has ended
+func (s *UserStore) GetUsersDueForDeletion() ([]int, error) {
+	query := `SELECT id FROM users WHERE deletion_scheduled_at <= CURRENT_TIMESTAMP ORDER BY deletion_scheduled_at`
+	rows, err := s.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
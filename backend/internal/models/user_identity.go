@@ -0,0 +1,98 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserIdentity links a user to an account at an external OpenID Connect provider
+type UserIdentity struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"-"` // The provider's stable user ID ("sub" claim)
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// UserIdentityStore is a struct that holds the database connection
+type UserIdentityStore struct {
+	DB *sql.DB
+}
+
+// UserIdentityRepository is an interface that defines the methods for user identity operations
+type UserIdentityRepository interface {
+	CreateIdentity(identity *UserIdentity) error
+	GetIdentity(provider string, subject string) (*UserIdentity, error)
+	GetIdentitiesByUserID(userID int) ([]*UserIdentity, error)
+	TouchIdentity(id int, email string) error
+	DeleteIdentity(id int, userID int) (bool, error)
+}
+
+// NewUserIdentityStore creates a new UserIdentityStore with the given database connection
+func NewUserIdentityStore(db *sql.DB) *UserIdentityStore {
+	return &UserIdentityStore{DB: db}
+}
+
+// CreateIdentity inserts a new identity into the database
+func (s *UserIdentityStore) CreateIdentity(identity *UserIdentity) error {
+	query := `INSERT INTO user_identities (user_id, provider, subject, email, last_login_at) VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP) RETURNING id, created_at, last_login_at`
+	err := s.DB.QueryRow(query, identity.UserID, identity.Provider, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt, &identity.LastLoginAt)
+	return err
+}
+
+// GetIdentity retrieves the identity of a provider account from the database
+func (s *UserIdentityStore) GetIdentity(provider string, subject string) (*UserIdentity, error) {
+	query := `SELECT id, user_id, provider, subject, email, created_at, last_login_at FROM user_identities WHERE provider = $1 AND subject = $2`
+	row := s.DB.QueryRow(query, provider, subject)
+	var identity UserIdentity
+	err := row.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// GetIdentitiesByUserID retrieves all identities linked to a user
+func (s *UserIdentityStore) GetIdentitiesByUserID(userID int) ([]*UserIdentity, error) {
+	query := `SELECT id, user_id, provider, subject, email, created_at, last_login_at FROM user_identities WHERE user_id = $1 ORDER BY created_at`
+	rows, err := s.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*UserIdentity
+	for rows.Next() {
+		var identity UserIdentity
+		err := rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+	return identities, rows.Err()
+}
+
+// TouchIdentity records a login through an identity and the email the provider reported for it
+func (s *UserIdentityStore) TouchIdentity(id int, email string) error {
+	query := `UPDATE user_identities SET email = $1, last_login_at = CURRENT_TIMESTAMP WHERE id = $2`
+	_, err := s.DB.Exec(query, email, id)
+	return err
+}
+
+// DeleteIdentity removes an identity if it belongs to the given user.
+// It reports whether an identity was deleted.
+func (s *UserIdentityStore) DeleteIdentity(id int, userID int) (bool, error) {
+	query := `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`
+	result, err := s.DB.Exec(query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// WebAuthnCeremony holds the server side state of a registration or login ceremony in progress
+type WebAuthnCeremony struct {
+	ID           int             `json:"id"`
+	TokenHash    string          `json:"-"`
+	CeremonyType string          `json:"ceremony_type"` // "registration" or "login"
+	UserID       *int            `json:"user_id"`
+	SessionData  json.RawMessage `json:"-"`
+	ExpiresAt    time.Time       `json:"expires_at"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// WebAuthnCeremonyStore is a struct that holds the database connection
+type WebAuthnCeremonyStore struct {
+	DB *sql.DB
+}
+
+// WebAuthnCeremonyRepository is an interface that defines the methods for WebAuthn ceremony operations
+type WebAuthnCeremonyRepository interface {
+	CreateCeremony(ceremony *WebAuthnCeremony) error
+	ConsumeCeremony(tokenHash string, ceremonyType string) (*WebAuthnCeremony, error)
+}
+
+// NewWebAuthnCeremonyStore creates a new WebAuthnCeremonyStore with the given database connection
+func NewWebAuthnCeremonyStore(db *sql.DB) *WebAuthnCeremonyStore {
+	return &WebAuthnCeremonyStore{DB: db}
+}
+
+// CreateCeremony inserts a new ceremony into the database
+func (s *WebAuthnCeremonyStore) CreateCeremony(ceremony *WebAuthnCeremony) error {
+	query := `INSERT INTO webauthn_ceremonies (token_hash, ceremony_type, user_id, session_data, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := s.DB.QueryRow(query, ceremony.TokenHash, ceremony.CeremonyType, ceremony.UserID, []byte(ceremony.SessionData), ceremony.ExpiresAt).Scan(&ceremony.ID, &ceremony.CreatedAt)
+	return err
+}
+
+// ConsumeCeremony deletes and returns an unexpired ceremony, so each challenge can be answered only once.
+// It returns sql.ErrNoRows if no such ceremony exists.
+func (s *WebAuthnCeremonyStore) ConsumeCeremony(tokenHash string, ceremonyType string) (*WebAuthnCeremony, error) {
+	query := `DELETE FROM webauthn_ceremonies 
+	WHERE token_hash = $1 AND ceremony_type = $2 AND expires_at > CURRENT_TIMESTAMP
+	RETURNING id, token_hash, ceremony_type, user_id, session_data, expires_at, created_at`
+	row := s.DB.QueryRow(query, tokenHash, ceremonyType)
+	var ceremony WebAuthnCeremony
+	var sessionData []byte
+	err := row.Scan(&ceremony.ID, &ceremony.TokenHash, &ceremony.CeremonyType, &ceremony.UserID, &sessionData, &ceremony.ExpiresAt, &ceremony.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	ceremony.SessionData = sessionData
+	return &ceremony, nil
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WebAuthnCredential represents a passkey or security key registered by a user
+type WebAuthnCredential struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	Name            string     `json:"name"`
+	CredentialID    []byte     `json:"-"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"attestation_type"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-"`
+	Transports      []string   `json:"transports"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+}
+
+// WebAuthnCredentialStore is a struct that holds the database connection
+type WebAuthnCredentialStore struct {
+	DB *sql.DB
+}
+
+// WebAuthnCredentialRepository is an interface that defines the methods for WebAuthn credential operations
+type WebAuthnCredentialRepository interface {
+	CreateCredential(credential *WebAuthnCredential) error
+	GetCredentialsByUserID(userID int) ([]*WebAuthnCredential, error)
+	UpdateCredentialUsage(id int, signCount uint32, backupState bool) error
+	DeleteCredential(id int, userID int) (bool, error)
+}
+
+// NewWebAuthnCredentialStore creates a new WebAuthnCredentialStore with the given database connection
+func NewWebAuthnCredentialStore(db *sql.DB) *WebAuthnCredentialStore {
+	return &WebAuthnCredentialStore{DB: db}
+}
+
+// CreateCredential inserts a new credential into the database
+func (s *WebAuthnCredentialStore) CreateCredential(credential *WebAuthnCredential) error {
+	query := `INSERT INTO webauthn_credentials 
+	(user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, created_at`
+	err := s.DB.QueryRow(query,
+		credential.UserID,
+		credential.Name,
+		credential.CredentialID,
+		credential.PublicKey,
+		credential.AttestationType,
+		credential.AAGUID,
+		int64(credential.SignCount),
+		pq.Array(credential.Transports),
+		credential.BackupEligible,
+		credential.BackupState,
+	).Scan(&credential.ID, &credential.CreatedAt)
+	return err
+}
+
+// GetCredentialsByUserID retrieves all credentials of a user from the database
+func (s *WebAuthnCredentialStore) GetCredentialsByUserID(userID int) ([]*WebAuthnCredential, error) {
+	query := `SELECT id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, created_at, last_used_at 
+	FROM webauthn_credentials 
+	WHERE user_id = $1 
+	ORDER BY created_at`
+	rows, err := s.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []*WebAuthnCredential
+	for rows.Next() {
+		var credential WebAuthnCredential
+		var signCount int64
+		err := rows.Scan(
+			&credential.ID,
+			&credential.UserID,
+			&credential.Name,
+			&credential.CredentialID,
+			&credential.PublicKey,
+			&credential.AttestationType,
+			&credential.AAGUID,
+			&signCount,
+			pq.Array(&credential.Transports),
+			&credential.BackupEligible,
+			&credential.BackupState,
+			&credential.CreatedAt,
+			&credential.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credential.SignCount = uint32(signCount)
+		credentials = append(credentials, &credential)
+	}
+	return credentials, rows.Err()
+}
+
+// UpdateCredentialUsage stores the signature counter and backup state reported by the last login
+func (s *WebAuthnCredentialStore) UpdateCredentialUsage(id int, signCount uint32, backupState bool) error {
+	query := `UPDATE webauthn_credentials SET sign_count = $1, backup_state = $2, last_used_at = CURRENT_TIMESTAMP WHERE id = $3`
+	_, err := s.DB.Exec(query, int64(signCount), backupState, id)
+	return err
+}
+
+// DeleteCredential removes a credential of the given user, reporting whether it existed
+func (s *WebAuthnCredentialStore) DeleteCredential(id int, userID int) (bool, error) {
+	query := `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`
+	result, err := s.DB.Exec(query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
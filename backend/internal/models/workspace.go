@@ -0,0 +1,249 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Workspace groups the sites a team works on together
+type Workspace struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"` // Role of the requesting user, when listed for them
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WorkspaceMember is a user's membership in a workspace
+type WorkspaceMember struct {
+	WorkspaceID int       `json:"workspace_id"`
+	UserID      int       `json:"user_id"`
+	Email       string    `json:"email"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"` // "owner", "admin", "editor" or "viewer"
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WorkspaceStore is a struct that holds the database connection
+type WorkspaceStore struct {
+	DB *sql.DB
+}
+
+// WorkspaceRepository is an interface that defines the methods for workspace and membership operations
+type WorkspaceRepository interface {
+	CreateWorkspace(workspace *Workspace, ownerID int) error
+	GetWorkspaceByID(id int) (*Workspace, error)
+	GetWorkspacesByUserID(userID int) ([]*Workspace, error)
+	UpdateWorkspace(workspace *Workspace) error
+	DeleteWorkspace(id int) error
+	GetMember(workspaceID int, userID int) (*WorkspaceMember, error)
+	GetMembers(workspaceID int) ([]*WorkspaceMember, error)
+	AddMember(workspaceID int, userID int, role string) error
+	UpdateMemberRole(workspaceID int, userID int, role string) error
+	RemoveMember(workspaceID int, userID int) error
+	TransferOwnership(workspaceID int, fromUserID int, toUserID int) error
+	GetSharedWorkspacesOwnedBy(userID int) ([]*Workspace, error)
+}
+
+// NewWorkspaceStore creates a new WorkspaceStore with the given database connection
+func NewWorkspaceStore(db *sql.DB) *WorkspaceStore {
+	return &WorkspaceStore{DB: db}
+}
+
+// CreateWorkspace inserts a new workspace and makes the given user its owner
+func (s *WorkspaceStore) CreateWorkspace(workspace *Workspace, ownerID int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO workspaces (name) VALUES ($1) RETURNING id, created_at, updated_at`
+	err = tx.QueryRow(query, workspace.Name).Scan(&workspace.ID, &workspace.CreatedAt, &workspace.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, 'owner')`, workspace.ID, ownerID)
+	if err != nil {
+		return err
+	}
+
+	workspace.Role = "owner"
+	return tx.Commit()
+}
+
+// GetWorkspaceByID retrieves a workspace by ID from the database
+func (s *WorkspaceStore) GetWorkspaceByID(id int) (*Workspace, error) {
+	query := `SELECT id, name, created_at, updated_at FROM workspaces WHERE id = $1`
+	row := s.DB.QueryRow(query, id)
+	var workspace Workspace
+	err := row.Scan(&workspace.ID, &workspace.Name, &workspace.CreatedAt, &workspace.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// GetWorkspacesByUserID retrieves the workspaces a user is a member of, along with their role
+func (s *WorkspaceStore) GetWorkspacesByUserID(userID int) ([]*Workspace, error) {
+	query := `SELECT w.id, w.name, m.role, w.created_at, w.updated_at 
+	FROM workspaces w
+	JOIN workspace_members m ON m.workspace_id = w.id
+	WHERE m.user_id = $1
+	ORDER BY w.name, w.id`
+	rows, err := s.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workspaces []*Workspace
+	for rows.Next() {
+		var workspace Workspace
+		err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.Role, &workspace.CreatedAt, &workspace.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, &workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// UpdateWorkspace updates an existing workspace in the database
+func (s *WorkspaceStore) UpdateWorkspace(workspace *Workspace) error {
+	query := `UPDATE workspaces SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING updated_at`
+	return s.DB.QueryRow(query, workspace.Name, workspace.ID).Scan(&workspace.UpdatedAt)
+}
+
+// DeleteWorkspace deletes a workspace along with its memberships and invitations
+func (s *WorkspaceStore) DeleteWorkspace(id int) error {
+	query := `DELETE FROM workspaces WHERE id = $1`
+	_, err := s.DB.Exec(query, id)
+	return err
+}
+
+// GetMember retrieves the membership of a user in a workspace
+func (s *WorkspaceStore) GetMember(workspaceID int, userID int) (*WorkspaceMember, error) {
+	query := `SELECT m.workspace_id, m.user_id, u.email, u.username, m.role, m.created_at 
+	FROM workspace_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.workspace_id = $1 AND m.user_id = $2`
+	row := s.DB.QueryRow(query, workspaceID, userID)
+	var member WorkspaceMember
+	err := row.Scan(&member.WorkspaceID, &member.UserID, &member.Email, &member.Username, &member.Role, &member.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetMembers retrieves all members of a workspace
+func (s *WorkspaceStore) GetMembers(workspaceID int) ([]*WorkspaceMember, error) {
+	query := `SELECT m.workspace_id, m.user_id, u.email, u.username, m.role, m.created_at 
+	FROM workspace_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.workspace_id = $1
+	ORDER BY m.created_at`
+	rows, err := s.DB.Query(query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*WorkspaceMember
+	for rows.Next() {
+		var member WorkspaceMember
+		err := rows.Scan(&member.WorkspaceID, &member.UserID, &member.Email, &member.Username, &member.Role, &member.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	return members, rows.Err()
+}
+
+// AddMember adds a user to a workspace; an existing member keeps their role
+func (s *WorkspaceStore) AddMember(workspaceID int, userID int, role string) error {
+	query := `INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT (workspace_id, user_id) DO NOTHING`
+	_, err := s.DB.Exec(query, workspaceID, userID, role)
+	return err
+}
+
+// UpdateMemberRole changes the role of a workspace member
+func (s *WorkspaceStore) UpdateMemberRole(workspaceID int, userID int, role string) error {
+	query := `UPDATE workspace_members SET role = $1 WHERE workspace_id = $2 AND user_id = $3`
+	_, err := s.DB.Exec(query, role, workspaceID, userID)
+	return err
+}
+
+// RemoveMember removes a user from a workspace
+func (s *WorkspaceStore) RemoveMember(workspaceID int, userID int) error {
+	query := `DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2`
+	_, err := s.DB.Exec(query, workspaceID, userID)
+	return err
+}
+
+// TransferOwnership makes another member the owner; the previous owner becomes an admin.
+// It returns sql.ErrNoRows if either user is not in the expected role.
+func (s *WorkspaceStore) TransferOwnership(workspaceID int, fromUserID int, toUserID int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = transferOwnership(tx, workspaceID, fromUserID, toUserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// transferOwnership swaps the owner of a workspace within a transaction
+func transferOwnership(tx *sql.Tx, workspaceID int, fromUserID int, toUserID int) error {
+	// Demote first; the unique owner index allows only one owner at a time
+	result, err := tx.Exec(`UPDATE workspace_members SET role = 'admin' WHERE workspace_id = $1 AND user_id = $2 AND role = 'owner'`, workspaceID, fromUserID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != 1 {
+		return sql.ErrNoRows
+	}
+
+	result, err = tx.Exec(`UPDATE workspace_members SET role = 'owner' WHERE workspace_id = $1 AND user_id = $2 AND role <> 'owner'`, workspaceID, toUserID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetSharedWorkspacesOwnedBy retrieves the workspaces a user owns that have other members
+func (s *WorkspaceStore) GetSharedWorkspacesOwnedBy(userID int) ([]*Workspace, error) {
+	query := `SELECT w.id, w.name, m.role, w.created_at, w.updated_at 
+	FROM workspaces w
+	JOIN workspace_members m ON m.workspace_id = w.id
+	WHERE m.user_id = $1 AND m.role = 'owner'
+	AND EXISTS (SELECT 1 FROM workspace_members o WHERE o.workspace_id = w.id AND o.user_id <> $1)
+	ORDER BY w.name, w.id`
+	rows, err := s.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workspaces []*Workspace
+	for rows.Next() {
+		var workspace Workspace
+		err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.Role, &workspace.CreatedAt, &workspace.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, &workspace)
+	}
+	return workspaces, rows.Err()
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// WorkspaceInvitation is a pending invitation for an email address to join a workspace
+type WorkspaceInvitation struct {
+	ID          int       `json:"id"`
+	WorkspaceID int       `json:"workspace_id"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"` // Role the invitee gets on accepting
+	TokenHash   string    `json:"-"`
+	InvitedBy   *int      `json:"invited_by"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// WorkspaceInvitationStore is a struct that holds the database connection
+type WorkspaceInvitationStore struct {
+	DB *sql.DB
+}
+
+// WorkspaceInvitationRepository is an interface that defines the methods for workspace invitation operations
+type WorkspaceInvitationRepository interface {
+	CreateInvitation(invitation *WorkspaceInvitation) error
+	GetInvitationByTokenHash(tokenHash string) (*WorkspaceInvitation, error)
+	GetInvitationsByWorkspaceID(workspaceID int) ([]*WorkspaceInvitation, error)
+	DeleteInvitation(id int, workspaceID int) (bool, error)
+	ConsumeInvitation(tokenHash string) (*WorkspaceInvitation, error)
+}
+
+// NewWorkspaceInvitationStore creates a new WorkspaceInvitationStore with the given database connection
+func NewWorkspaceInvitationStore(db *sql.DB) *WorkspaceInvitationStore {
+	return &WorkspaceInvitationStore{DB: db}
+}
+
+// CreateInvitation stores an invitation, replacing any pending invitation for the same address
+func (s *WorkspaceInvitationStore) CreateInvitation(invitation *WorkspaceInvitation) error {
+	query := `INSERT INTO workspace_invitations (workspace_id, email, role, token_hash, invited_by, expires_at) VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (workspace_id, lower(email)) DO UPDATE SET
+		email = EXCLUDED.email,
+		role = EXCLUDED.role,
+		token_hash = EXCLUDED.token_hash,
+		invited_by = EXCLUDED.invited_by,
+		expires_at = EXCLUDED.expires_at,
+		created_at = CURRENT_TIMESTAMP
+	RETURNING id, created_at`
+	err := s.DB.QueryRow(query, invitation.WorkspaceID, invitation.Email, invitation.Role, invitation.TokenHash, invitation.InvitedBy, invitation.ExpiresAt).Scan(&invitation.ID, &invitation.CreatedAt)
+	return err
+}
+
+// GetInvitationByTokenHash retrieves an unexpired invitation by the hash of its token
+func (s *WorkspaceInvitationStore) GetInvitationByTokenHash(tokenHash string) (*WorkspaceInvitation, error) {
+	query := `SELECT id, workspace_id, email, role, token_hash, invited_by, expires_at, created_at FROM workspace_invitations WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP`
+	row := s.DB.QueryRow(query, tokenHash)
+	var invitation WorkspaceInvitation
+	err := row.Scan(&invitation.ID, &invitation.WorkspaceID, &invitation.Email, &invitation.Role, &invitation.TokenHash, &invitation.InvitedBy, &invitation.ExpiresAt, &invitation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// GetInvitationsByWorkspaceID retrieves the unexpired invitations of a workspace
+func (s *WorkspaceInvitationStore) GetInvitationsByWorkspaceID(workspaceID int) ([]*WorkspaceInvitation, error) {
+	query := `SELECT id, workspace_id, email, role, token_hash, invited_by, expires_at, created_at FROM workspace_invitations 
+	WHERE workspace_id = $1 AND expires_at > CURRENT_TIMESTAMP
+	ORDER BY created_at DESC`
+	rows, err := s.DB.Query(query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*WorkspaceInvitation
+	for rows.Next() {
+		var invitation WorkspaceInvitation
+		err := rows.Scan(&invitation.ID, &invitation.WorkspaceID, &invitation.Email, &invitation.Role, &invitation.TokenHash, &invitation.InvitedBy, &invitation.ExpiresAt, &invitation.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, &invitation)
+	}
+	return invitations, rows.Err()
+}
+
+// DeleteInvitation withdraws an invitation of a workspace.
+// It reports whether an invitation was deleted.
+func (s *WorkspaceInvitationStore) DeleteInvitation(id int, workspaceID int) (bool, error) {
+	query := `DELETE FROM workspace_invitations WHERE id = $1 AND workspace_id = $2`
+	result, err := s.DB.Exec(query, id, workspaceID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// ConsumeInvitation deletes and returns an unexpired invitation, so it can be answered only once.
+// It returns sql.ErrNoRows if no such invitation exists.
+func (s *WorkspaceInvitationStore) ConsumeInvitation(tokenHash string) (*WorkspaceInvitation, error) {
+	query := `DELETE FROM workspace_invitations 
+	WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP
+	RETURNING id, workspace_id, email, role, token_hash, invited_by, expires_at, created_at`
+	row := s.DB.QueryRow(query, tokenHash)
+	var invitation WorkspaceInvitation
+	err := row.Scan(&invitation.ID, &invitation.WorkspaceID, &invitation.Email, &invitation.Role, &invitation.TokenHash, &invitation.InvitedBy, &invitation.ExpiresAt, &invitation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
@@ -0,0 +1,207 @@
+// Package rbac defines the roles and permissions users act with and the
+// principal that authenticated requests carry in their context.
+package rbac
+
+import (
+	"context"
+	"slices"
+)
+
+// Role is a named set of permissions
+type Role string
+
+// Roles, from most to least privileged
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+// Permission is a single action a principal may be allowed to perform
+type Permission string
+
+// Permissions checked by handlers. The names double as API token scopes.
+const (
+	PermissionProfileRead Permission = "user:read"
+	PermissionUsersRead   Permission = "users:read"
+	PermissionUsersManage Permission = "users:manage"
+	PermissionSitesRead   Permission = "sites:read"
+	PermissionSitesWrite  Permission = "sites:write"
+	PermissionPagesRead   Permission = "pages:read"
+	PermissionPagesWrite  Permission = "pages:write"
+	PermissionPublish     Permission = "publish"
+
+	PermissionWorkspaceRead   Permission = "workspace:read"
+	PermissionWorkspaceManage Permission = "workspace:manage"
+	PermissionMembersManage   Permission = "members:manage"
+	PermissionWorkspaceOwn    Permission = "workspace:own" // Delete and transfer a workspace
+
+	PermissionAuditRead Permission = "audit:read"
+)
+
+// APITokenScopes lists the permissions that can be granted to API tokens.
+// Managing users is left to people signed in as themselves.
+var APITokenScopes = []Permission{
+	PermissionProfileRead,
+	PermissionSitesRead,
+	PermissionSitesWrite,
+	PermissionPagesRead,
+	PermissionPagesWrite,
+	PermissionPublish,
+}
+
+// impersonationDenied lists the permissions support staff don't get while
+// impersonating a user, however privileged that user is
+var impersonationDenied = []Permission{
+	PermissionUsersManage,
+	PermissionWorkspaceManage,
+	PermissionMembersManage,
+	PermissionWorkspaceOwn,
+	PermissionPublish,
+}
+
+var viewerPermissions = []Permission{
+	PermissionProfileRead,
+	PermissionSitesRead,
+	PermissionPagesRead,
+	PermissionWorkspaceRead,
+}
+
+var editorPermissions = append(slices.Clone(viewerPermissions),
+	PermissionSitesWrite,
+	PermissionPagesWrite,
+	PermissionPublish,
+)
+
+var adminPermissions = append(slices.Clone(editorPermissions),
+	PermissionUsersRead,
+	PermissionUsersManage,
+	PermissionWorkspaceManage,
+	PermissionMembersManage,
+	PermissionAuditRead,
+)
+
+var ownerPermissions = append(slices.Clone(adminPermissions),
+	PermissionWorkspaceOwn,
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner:  ownerPermissions,
+	RoleAdmin:  adminPermissions,
+	RoleEditor: editorPermissions,
+	RoleViewer: viewerPermissions,
+}
+
+var roleRanks = map[Role]int{
+	RoleOwner:  4,
+	RoleAdmin:  3,
+	RoleEditor: 2,
+	RoleViewer: 1,
+}
+
+// Valid reports whether the role is one of the defined roles
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Has reports whether the role grants the permission
+func (r Role) Has(permission Permission) bool {
+	return slices.Contains(rolePermissions[r], permission)
+}
+
+// CanManage reports whether someone with this role may change the role of
+// someone with the other role, or hand out the other role.
+// Owners manage everyone; everyone else only roles below their own.
+func (r Role) CanManage(other Role) bool {
+	return r == RoleOwner || roleRanks[r] > roleRanks[other]
+}
+
+// Principal is the authenticated user a request acts for
+type Principal struct {
+	UserID    int
+	Email     string
+	Role      Role
+	SessionID int      // Zero for API tokens
+	TokenID   string   // ID of the access token, empty for API tokens
+	APIToken  int      // ID of the API token, zero for sessions
+	Scopes    []string // Scopes of the API token
+
+	// ImpersonatorID is the admin acting as this user, zero unless impersonating.
+	// SessionID is then the admin's session.
+	ImpersonatorID int
+}
+
+// IsAPIToken reports whether the request was authenticated with an API token
+func (p *Principal) IsAPIToken() bool {
+	return p.APIToken != 0
+}
+
+// IsImpersonated reports whether an admin is acting as the user
+func (p *Principal) IsImpersonated() bool {
+	return p.ImpersonatorID != 0
+}
+
+// ActorID returns the user really performing the request
+func (p *Principal) ActorID() int {
+	if p.IsImpersonated() {
+		return p.ImpersonatorID
+	}
+	return p.UserID
+}
+
+// Can reports whether the principal may perform an action.
+// API tokens are further limited to their scopes.
+func (p *Principal) Can(permission Permission) bool {
+	return p.Role.Has(permission) && p.allows(permission)
+}
+
+// CanIn reports whether the principal may perform an action in a workspace.
+// Inside a workspace the membership role applies instead of the user's own role.
+func (p *Principal) CanIn(membership *Membership, permission Permission) bool {
+	return membership.Role.Has(permission) && p.allows(permission)
+}
+
+// allows applies the limits of how the principal authenticated on top of its role
+func (p *Principal) allows(permission Permission) bool {
+	if p.IsAPIToken() && !slices.Contains(p.Scopes, string(permission)) {
+		return false
+	}
+	if p.IsImpersonated() && slices.Contains(impersonationDenied, permission) {
+		return false
+	}
+	return true
+}
+
+// Membership is the principal's role in the workspace a request is scoped to
+type Membership struct {
+	WorkspaceID int
+	Role        Role
+}
+
+type principalKey struct{}
+
+type membershipKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of an authenticated request, or nil
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// WithMembership returns a copy of ctx carrying the principal's workspace membership
+func WithMembership(ctx context.Context, membership *Membership) context.Context {
+	return context.WithValue(ctx, membershipKey{}, membership)
+}
+
+// MembershipFromContext returns the workspace membership of a workspace-scoped request, or nil
+func MembershipFromContext(ctx context.Context) *Membership {
+	membership, _ := ctx.Value(membershipKey{}).(*Membership)
+	return membership
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+)
+
+const accountDeletionCheckInterval = time.Hour
+
+// AccountDeletionService deletes accounts on request after a grace period
+// in which the user can still change their mind
+type AccountDeletionService struct {
+	users       *models.UserStore
+	workspaces  *models.WorkspaceStore
+	events      *models.AuditEventStore
+	auth        *AuthService
+	mail        *MailService
+	logger      *log.Logger
+	gracePeriod time.Duration
+}
+
+// DeleteAccountRequest confirms an account deletion with the current password.
+// Accounts without a password leave it out and sign in again instead.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// DeletionResponse tells when an account will be deleted
+type DeletionResponse struct {
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
+
+// NewAccountDeletionService creates a new AccountDeletionService; accounts are deleted gracePeriod after the request
+func NewAccountDeletionService(users *models.UserStore, workspaces *models.WorkspaceStore, events *models.AuditEventStore, auth *AuthService, mail *MailService, logger *log.Logger, gracePeriod time.Duration) *AccountDeletionService {
+	if gracePeriod == 0 {
+		gracePeriod = 30 * 24 * time.Hour
+	}
+
+	return &AccountDeletionService{
+		users:       users,
+		workspaces:  workspaces,
+		events:      events,
+		auth:        auth,
+		mail:        mail,
+		logger:      logger,
+		gracePeriod: gracePeriod,
+	}
+}
+
+// ScheduleDeletion handles the current user asking to delete their account.
+// Workspaces the user owns alone are deleted with the account; workspaces with
+// other members have to be handed over first.
+func (s *AccountDeletionService) ScheduleDeletion(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	var req DeleteAccountRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.users.GetUserByID(principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !s.auth.confirmIdentity(w, r, user, req.Password) {
+		return
+	}
+
+	shared, err := s.workspaces.GetSharedWorkspacesOwnedBy(user.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(shared) > 0 {
+		names := make([]string, 0, len(shared))
+		for _, workspace := range shared {
+			names = append(names, workspace.Name)
+		}
+		http.Error(w, "Transfer ownership of these workspaces first: "+strings.Join(names, ", "), http.StatusConflict)
+		return
+	}
+
+	// Asking again keeps the original date
+	deleteAt := time.Now().Add(s.gracePeriod)
+	if user.DeletionScheduledAt != nil {
+		deleteAt = *user.DeletionScheduledAt
+	} else {
+		err = s.users.ScheduleDeletion(user.ID, deleteAt)
+		if err != nil {
+			http.Error(w, "Failed to schedule deletion", http.StatusInternalServerError)
+			return
+		}
+
+		s.auth.recordAuditEvent(r, user.ID, models.AuditEventDeletionScheduled, map[string]any{
+			"delete_at": deleteAt,
+		})
+
+		go s.mail.SendAccountDeletionScheduledEmail(user.Email, deleteAt)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(DeletionResponse{DeletionScheduledAt: deleteAt})
+}
+
+// CancelDeletion handles the current user keeping their account during the grace period
+func (s *AccountDeletionService) CancelDeletion(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	user, err := s.users.GetUserByID(principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if user.DeletionScheduledAt == nil {
+		http.Error(w, "Account is not scheduled for deletion", http.StatusNotFound)
+		return
+	}
+
+	err = s.users.CancelDeletion(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to cancel deletion", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, user.ID, models.AuditEventDeletionCanceled, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Start deletes accounts whose grace period has ended in the background
+func (s *AccountDeletionService) Start() {
+	go func() {
+		ticker := time.NewTicker(accountDeletionCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			s.deleteDueAccounts()
+			<-ticker.C
+		}
+	}()
+}
+
+// deleteDueAccounts deletes every account whose grace period has ended
+func (s *AccountDeletionService) deleteDueAccounts() {
+	ids, err := s.users.GetUsersDueForDeletion()
+	if err != nil {
+		s.logger.Printf("failed to find accounts due for deletion: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		if err := s.deleteAccount(id); err != nil {
+			s.logger.Printf("failed to delete account %d: %v", id, err)
+		}
+	}
+}
+
+// deleteAccount removes a user and everything that belongs to them in one transaction.
+// Workspaces that gained members during the grace period go to their most senior
+// remaining member; workspaces nobody else uses are deleted with their content.
+// Sessions, tokens, credentials, memberships and exports are removed by the database
+// cascade; the audit log is kept.
+func (s *AccountDeletionService) deleteAccount(userID int) error {
+	transfers, err := s.users.DeleteAccount(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, transfer := range transfers {
+		s.recordSystemEvent(transfer.SuccessorID, &transfer.WorkspaceID, models.AuditEventOwnershipTransferred, map[string]any{
+			"previous_owner_id": userID,
+			"reason":            "account_deleted",
+		})
+	}
+
+	s.recordSystemEvent(userID, nil, models.AuditEventAccountDeleted, nil)
+	return nil
+}
+
+// recordSystemEvent appends an event the application caused on its own to the audit log
+func (s *AccountDeletionService) recordSystemEvent(userID int, workspaceID *int, eventType string, metadata map[string]any) {
+	var encoded json.RawMessage
+	if metadata != nil {
+		encoded, _ = json.Marshal(metadata)
+	}
+
+	s.events.CreateAuditEvent(&models.AuditEvent{
+		UserID:      &userID,
+		WorkspaceID: workspaceID,
+		EventType:   eventType,
+		Metadata:    encoded,
+	})
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+// APITokenService handles creating, listing and revoking API tokens
+type APITokenService struct {
+	store *models.APITokenStore
+}
+
+// CreateAPITokenRequest describes a new API token
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"` // Optional; omit for a token that never expires
+}
+
+// CreateAPITokenResponse includes the token secret, which is only ever shown once
+type CreateAPITokenResponse struct {
+	*models.APIToken
+	Token string `json:"token"`
+}
+
+// NewAPITokenService creates a new APITokenService with the given APITokenStore
+func NewAPITokenService(store *models.APITokenStore) *APITokenService {
+	return &APITokenService{
+		store: store,
+	}
+}
+
+// CreateAPIToken handles creating an API token for the current user
+func (s *APITokenService) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	var req CreateAPITokenRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Scopes) == 0 {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Name) > 100 {
+		http.Error(w, "Name must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !slices.Contains(rbac.APITokenScopes, rbac.Permission(scope)) {
+			http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "Expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := utils.GenerateAPIToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	// Only the hash is stored; the secret cannot be recovered later
+	token := &models.APIToken{
+		UserID:      principal.UserID,
+		Name:        req.Name,
+		TokenHash:   utils.HashToken(secret),
+		TokenPrefix: secret[:len(utils.APITokenPrefix)+6],
+		Scopes:      slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		ExpiresAt:   req.ExpiresAt,
+	}
+
+	err = s.store.CreateAPIToken(token)
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPITokenResponse{
+		APIToken: token,
+		Token:    secret,
+	})
+}
+
+// ListAPITokens handles listing the API tokens of the current user
+func (s *APITokenService) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	tokens, err := s.store.GetAPITokensByUserID(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list tokens", http.StatusInternalServerError)
+		return
+	}
+
+	if tokens == nil {
+		tokens = []*models.APIToken{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RevokeAPIToken handles deleting an API token of the current user
+func (s *APITokenService) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.store.DeleteAPIToken(id, principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticateAPIToken authenticates a request made with an API token and passes it on
+func (s *AuthService) authenticateAPIToken(w http.ResponseWriter, r *http.Request, next http.Handler, secret string) {
+	token, err := s.apiTokenStore.GetAPITokenByHash(utils.HashToken(secret))
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	if token.Expired() {
+		http.Error(w, "Token expired", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(token.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	s.apiTokenStore.TouchAPIToken(token.ID, utils.ClientIP(r))
+
+	// Add the principal to request context; it can do at most what its scopes allow
+	r = r.WithContext(rbac.WithPrincipal(r.Context(), &rbac.Principal{
+		UserID:   user.ID,
+		Email:    user.Email,
+		Role:     rbac.Role(user.Role),
+		APIToken: token.ID,
+		Scopes:   token.Scopes,
+	}))
+
+	next.ServeHTTP(w, r)
+}
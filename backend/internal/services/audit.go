@@ -0,0 +1,164 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// AuditService handles querying the audit log
+type AuditService struct {
+	store *models.AuditEventStore
+}
+
+// AuditEventPage is one page of audit events.
+// NextCursor is passed as cursor to fetch the next page and is null on the last one.
+type AuditEventPage struct {
+	Events     []*models.AuditEvent `json:"events"`
+	NextCursor *int64               `json:"next_cursor"`
+}
+
+// NewAuditService creates a new AuditService with the given AuditEventStore
+func NewAuditService(store *models.AuditEventStore) *AuditService {
+	return &AuditService{
+		store: store,
+	}
+}
+
+// recordAuditEvent appends an event about a user to the audit log.
+// The actor is the authenticated principal, the admin when impersonating, or the
+// user itself on public routes like login; userID 0 records an event about no known account.
+// Failing to record an event never fails the request that triggered it.
+func (s *AuthService) recordAuditEvent(r *http.Request, userID int, eventType string, metadata map[string]any) {
+	var encoded json.RawMessage
+	if metadata != nil {
+		encoded, _ = json.Marshal(metadata)
+	}
+
+	event := &models.AuditEvent{
+		EventType: eventType,
+		IPAddress: utils.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Metadata:  encoded,
+	}
+
+	if userID != 0 {
+		event.UserID = &userID
+		event.ActorID = &userID
+	}
+	if principal := rbac.FromContext(r.Context()); principal != nil {
+		actorID := principal.ActorID()
+		event.ActorID = &actorID
+	}
+	if membership := rbac.MembershipFromContext(r.Context()); membership != nil {
+		event.WorkspaceID = &membership.WorkspaceID
+	}
+
+	s.eventStore.CreateAuditEvent(event)
+}
+
+// ListAuditEvents handles administrators querying the whole audit log
+func (s *AuditService) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	s.listAuditEvents(w, r, 0)
+}
+
+// ListWorkspaceAuditEvents handles workspace admins querying the events of their
+// workspace and the account events of its members
+func (s *AuditService) ListWorkspaceAuditEvents(w http.ResponseWriter, r *http.Request) {
+	membership := rbac.MembershipFromContext(r.Context())
+	s.listAuditEvents(w, r, membership.WorkspaceID)
+}
+
+// listAuditEvents writes a page of audit events matching the query parameters
+func (s *AuditService) listAuditEvents(w http.ResponseWriter, r *http.Request, workspaceID int) {
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.WorkspaceID = workspaceID
+
+	// Fetch one extra event to know whether there is a next page
+	pageSize := filter.Limit
+	filter.Limit++
+
+	events, err := s.store.ListAuditEvents(filter)
+	if err != nil {
+		http.Error(w, "Failed to list audit events", http.StatusInternalServerError)
+		return
+	}
+
+	page := AuditEventPage{Events: events}
+	if len(events) > pageSize {
+		page.Events = events[:pageSize]
+		page.NextCursor = &page.Events[pageSize-1].ID
+	}
+	if page.Events == nil {
+		page.Events = []*models.AuditEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// auditFilterFromQuery reads the filter of an audit log query from the query string:
+// user_id, actor_id, event_type (comma-separated), since and until (RFC 3339),
+// cursor and limit
+func auditFilterFromQuery(r *http.Request) (models.AuditEventFilter, error) {
+	query := r.URL.Query()
+	filter := models.AuditEventFilter{Limit: defaultAuditPageSize}
+
+	var err error
+	if value := query.Get("user_id"); value != "" {
+		if filter.UserID, err = strconv.Atoi(value); err != nil {
+			return filter, errors.New("Invalid user_id")
+		}
+	}
+	if value := query.Get("actor_id"); value != "" {
+		if filter.ActorID, err = strconv.Atoi(value); err != nil {
+			return filter, errors.New("Invalid actor_id")
+		}
+	}
+	for _, value := range query["event_type"] {
+		for _, eventType := range strings.Split(value, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				filter.EventTypes = append(filter.EventTypes, eventType)
+			}
+		}
+	}
+	if value := query.Get("since"); value != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errors.New("Invalid since, expected RFC 3339")
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errors.New("Invalid until, expected RFC 3339")
+		}
+	}
+	if value := query.Get("cursor"); value != "" {
+		if filter.Before, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return filter, errors.New("Invalid cursor")
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 {
+			return filter, errors.New("Invalid limit")
+		}
+		filter.Limit = min(filter.Limit, maxAuditPageSize)
+	}
+
+	return filter, nil
+}
@@ -1,21 +1,46 @@
 package services
 
 import (
-	"context"
 	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
 	"github.com/bercivarga/website-builder/internal/utils"
 )
 
 // AuthService is a struct that holds the token store and user store
 type AuthService struct {
-	store     *models.TokenStore
-	authUtils *utils.AuthUtils
-	userStore *models.UserStore
+	store             models.TokenRepository
+	sessionStore      models.SessionRepository
+	authUtils         *utils.AuthUtils
+	userStore         *models.UserStore
+	eventStore        models.AuditEventRepository
+	totpStore         *models.TOTPStore
+	recoveryCodeStore *models.RecoveryCodeStore
+	challengeStore    *models.MFAChallengeStore
+	apiTokenStore     *models.APITokenStore
+	throttleStore     models.LoginThrottleRepository
+	hasher            utils.PasswordHasher
+	mail              *MailService
+	cookies           CookieConfig
+	logger            *log.Logger
+}
+
+// AuthStores groups the stores used by AuthService
+type AuthStores struct {
+	Tokens         *models.TokenStore
+	Sessions       *models.SessionStore
+	Users          *models.UserStore
+	AuditEvents    *models.AuditEventStore
+	TOTP           *models.TOTPStore
+	RecoveryCodes  *models.RecoveryCodeStore
+	MFAChallenges  *models.MFAChallengeStore
+	APITokens      *models.APITokenStore
+	LoginThrottles *models.LoginThrottleStore
 }
 
 // UserCredentials represents login credentials
@@ -27,32 +52,58 @@ type UserCredentials struct {
 
 // TokenResponse represents the response after successful authentication
 type TokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 	ExpiresIn    int64  `json:"expires_in"`
 	TokenType    string `json:"token_type"`
+	CSRFToken    string `json:"csrf_token,omitempty"` // Only in cookie mode, where the tokens are cookies
+
+	accessExpires  time.Time
+	refreshExpires time.Time
 }
 
-// NewAuthService creates a new AuthService with the given stores and auth utils
-func NewAuthService(store *models.TokenStore, authUtils *utils.AuthUtils, userStore *models.UserStore) *AuthService {
+// NewAuthService creates a new AuthService with the given stores, auth utils, password hasher, mail service, cookie mode and logger
+func NewAuthService(stores AuthStores, authUtils *utils.AuthUtils, hasher utils.PasswordHasher, mail *MailService, cookies CookieConfig, logger *log.Logger) *AuthService {
 	return &AuthService{
-		store:     store,
-		authUtils: authUtils,
-		userStore: userStore,
+		store:             stores.Tokens,
+		sessionStore:      stores.Sessions,
+		authUtils:         authUtils,
+		userStore:         stores.Users,
+		eventStore:        stores.AuditEvents,
+		totpStore:         stores.TOTP,
+		recoveryCodeStore: stores.RecoveryCodes,
+		challengeStore:    stores.MFAChallenges,
+		apiTokenStore:     stores.APITokens,
+		throttleStore:     stores.LoginThrottles,
+		hasher:            hasher,
+		mail:              mail,
+		cookies:           cookies,
+		logger:            logger,
 	}
 }
 
-// AuthMiddleware is a middleware for protecting routes
+// AuthMiddleware is a middleware for protecting routes.
+// It accepts either a JWT access token or an API token, and in cookie mode the access token cookie.
 func (s *AuthService) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		tokenString, err := utils.ExtractTokenFromHeader(authHeader)
+		// Extract token from Authorization header or cookie
+		tokenString, fromCookie, err := s.requestToken(r, accessTokenCookie)
 		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
+		// Browsers send cookies along with cross-site requests, headers they don't
+		if fromCookie && !s.validCSRF(r) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		if !fromCookie && utils.IsAPIToken(tokenString) {
+			s.authenticateAPIToken(w, r, next, tokenString)
+			return
+		}
+
 		// Verify token
 		claims, err := s.authUtils.VerifyToken(tokenString)
 		if err != nil {
@@ -61,29 +112,46 @@ func (s *AuthService) AuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Check if token type is access
-		if claims.Type != "access" {
+		if claims.Type != "access" && claims.Type != "impersonation" {
 			http.Error(w, "Invalid token type", http.StatusUnauthorized)
 			return
 		}
 
 		// Check if token exists in database
-		tokenDB, err := s.store.GetTokenByUserID(claims.UserID)
+		tokenDB, err := s.store.GetTokenByTokenID(claims.TokenID)
 		if err != nil {
 			http.Error(w, "Token not found", http.StatusUnauthorized)
 			return
 		}
 
-		// Verify token ID matches
-		if tokenDB.Token != claims.TokenID {
+		// Verify the token belongs to the session it claims
+		if !tokenMatchesClaims(tokenDB, claims) {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		// Add claims and userId to request context
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, "claims", claims)
-		ctx = context.WithValue(ctx, "userID", claims.UserID)
-		r = r.WithContext(ctx)
+		// Roles are read on every request so role changes apply immediately
+		user, err := s.userStore.GetUserByID(claims.UserID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusUnauthorized)
+			return
+		}
+
+		if claims.Type == "impersonation" {
+			s.authenticateImpersonation(w, r, next, claims, user)
+			return
+		}
+
+		s.sessionStore.TouchSession(claims.SessionID)
+
+		// Add the principal to request context
+		r = r.WithContext(rbac.WithPrincipal(r.Context(), &rbac.Principal{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Role:      rbac.Role(user.Role),
+			SessionID: claims.SessionID,
+			TokenID:   claims.TokenID,
+		}))
 
 		next.ServeHTTP(w, r)
 	})
@@ -100,10 +168,24 @@ func (s *AuthService) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse attempts from throttled accounts and IPs, and count this one, before checking anything
+	accountKey := throttleAccountKey(creds.Email)
+	ip := utils.ClientIP(r)
+	throttle, wait, err := s.reserveLoginAttempt(accountKey, ip)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		tooManyLoginAttempts(w, wait)
+		return
+	}
+
 	// Validate user credentials
 	user, err := s.userStore.GetUserByEmail(creds.Email)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			s.recordFailedLogin(r, accountKey, nil, "password", throttle)
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
@@ -114,34 +196,104 @@ func (s *AuthService) Login(w http.ResponseWriter, r *http.Request) {
 	// Verify password
 	err = utils.ComparePasswords(creds.Password, user.PasswordHash)
 	if err != nil {
+		s.recordFailedLogin(r, accountKey, user, "password", throttle)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	s.releaseLoginAttempt(accountKey, ip)
+
+	// Upgrade legacy or weaker hashes now that we know the plaintext password.
+	// A failed upgrade must not block the login; it is retried next time.
+	if s.hasher.NeedsRehash(user.PasswordHash) {
+		if newHash, err := s.hasher.Hash(creds.Password); err == nil {
+			user.PasswordHash = newHash
+			s.userStore.UpdatePasswordHash(user.ID, newHash)
+		}
+	}
 
-	// Generate access token
-	accessToken, accessTokenID, accessExpires, err := s.authUtils.GenerateAccessToken(user.ID, user.Email)
+	s.completeLogin(w, r, user)
+}
+
+// completeLogin finishes a login whose first factor checked out.
+// Users with a second factor get an MFA challenge instead of tokens, and
+// their failed logins are only forgotten once the second factor checks out too.
+func (s *AuthService) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	mfaEnabled, err := s.mfaEnabled(user.ID)
 	if err != nil {
-		http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Generate refresh token
-	refreshToken, refreshTokenID, refreshExpires, err := s.authUtils.GenerateRefreshToken(user.ID, user.Email)
-	if err != nil {
-		http.Error(w, "Failed to generate refresh token", http.StatusInternalServerError)
+	if mfaEnabled {
+		challenge, err := s.createMFAChallenge(user)
+		if err != nil {
+			http.Error(w, "Failed to create MFA challenge", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(challenge)
 		return
 	}
 
-	// Delete old tokens
-	err = s.store.DeleteTokensByUserID(user.ID)
+	s.signIn(w, r, user)
+}
+
+// signIn ends a login whose every required factor checked out: the failed logins
+// of the account are forgotten and a new session is answered with its tokens
+func (s *AuthService) signIn(w http.ResponseWriter, r *http.Request, user *models.User) {
+	s.throttleStore.ClearThrottle(models.ThrottleKeyAccount, throttleAccountKey(user.Email))
+
+	// Every login gets its own session so other devices stay signed in
+	response, err := s.startSession(r, user)
 	if err != nil {
-		http.Error(w, "Failed to delete old tokens", http.StatusInternalServerError)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		return
 	}
 
+	s.writeTokenResponse(w, response, s.wantsCookies(r))
+}
+
+// startSession creates a new session for the user and issues a token pair bound to it
+func (s *AuthService) startSession(r *http.Request, user *models.User) (*TokenResponse, error) {
+	session := &models.Session{
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IPAddress: utils.ClientIP(r),
+	}
+
+	err := s.sessionStore.CreateSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventLogin, map[string]any{
+		"session_id": session.ID,
+	})
+
+	return s.issueTokens(user.ID, user.Email, session.ID)
+}
+
+// issueTokens generates and stores a new access and refresh token for a session.
+// A session's refresh tokens form one token family: rotating keeps the family
+// alive, and revoking the session revokes every token in it.
+func (s *AuthService) issueTokens(userID int, email string, sessionID int) (*TokenResponse, error) {
+	// Generate access token
+	accessToken, accessTokenID, accessExpires, err := s.authUtils.GenerateAccessToken(userID, email, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate refresh token
+	refreshToken, refreshTokenID, refreshExpires, err := s.authUtils.GenerateRefreshToken(userID, email, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Store access token in database
 	accessTokenDB := &models.Token{
-		UserID:    user.ID,
+		UserID:    userID,
+		SessionID: &sessionID,
 		Token:     accessTokenID,
 		TokenType: "access",
 		ExpiresAt: accessExpires,
@@ -149,13 +301,13 @@ func (s *AuthService) Login(w http.ResponseWriter, r *http.Request) {
 
 	err = s.store.CreateToken(accessTokenDB)
 	if err != nil {
-		http.Error(w, "Failed to store token", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	// Store refresh token in database
 	refreshTokenDB := &models.Token{
-		UserID:    user.ID,
+		UserID:    userID,
+		SessionID: &sessionID,
 		Token:     refreshTokenID,
 		TokenType: "refresh",
 		ExpiresAt: refreshExpires,
@@ -163,20 +315,25 @@ func (s *AuthService) Login(w http.ResponseWriter, r *http.Request) {
 
 	err = s.store.CreateToken(refreshTokenDB)
 	if err != nil {
-		http.Error(w, "Failed to store refresh token", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
-	// Send response
-	response := TokenResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(accessExpires.Sub(time.Now()).Seconds()),
-		TokenType:    "Bearer",
-	}
+	return &TokenResponse{
+		AccessToken:    accessToken,
+		RefreshToken:   refreshToken,
+		ExpiresIn:      int64(time.Until(accessExpires).Seconds()),
+		TokenType:      "Bearer",
+		accessExpires:  accessExpires,
+		refreshExpires: refreshExpires,
+	}, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// tokenMatchesClaims reports whether a stored token is the one described by the JWT claims
+func tokenMatchesClaims(token *models.Token, claims *utils.Claims) bool {
+	return token.TokenType == claims.Type &&
+		token.UserID == claims.UserID &&
+		token.SessionID != nil &&
+		*token.SessionID == claims.SessionID
 }
 
 // Register handles user registration
@@ -196,8 +353,13 @@ func (s *AuthService) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(creds.Password) > utils.MaxPasswordLength {
+		http.Error(w, "Password must be at most 72 bytes", http.StatusBadRequest)
+		return
+	}
+
 	// Hash password
-	passwordHash, err := utils.HashPassword(creds.Password)
+	passwordHash, err := s.hasher.Hash(creds.Password)
 	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
@@ -216,15 +378,24 @@ func (s *AuthService) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The account exists either way; a lost email can be resent
+	err = s.sendVerificationEmail(user)
+	if err != nil {
+		s.logger.Printf("failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("User created successfully"))
 }
 
-// Refresh handles token refresh requests
+// Refresh handles token refresh requests.
+// Every refresh rotates the refresh token; presenting an already rotated
+// token means it was leaked, so the whole token family is revoked.
 func (s *AuthService) Refresh(w http.ResponseWriter, r *http.Request) {
-	// Extract refresh token from Authorization header
-	authHeader := r.Header.Get("Authorization")
-	tokenString, err := utils.ExtractTokenFromHeader(authHeader)
+	// Extract refresh token from Authorization header or cookie.
+	// The refresh cookie is SameSite and a forged refresh only rotates the
+	// victim's own cookies, so no CSRF token is needed; reloaded pages get theirs here.
+	tokenString, fromCookie, err := s.requestToken(r, refreshTokenCookie)
 	if err != nil {
 		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
 		return
@@ -238,18 +409,25 @@ func (s *AuthService) Refresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if refresh token exists in database
-	refreshToken, err := s.store.GetRefreshTokenByUserID(claims.UserID) // Need this method
+	refreshToken, err := s.store.GetTokenByTokenID(claims.TokenID)
 	if err != nil {
 		http.Error(w, "Refresh token not found", http.StatusUnauthorized)
 		return
 	}
 
-	// Verify token ID matches
-	if refreshToken.Token != claims.TokenID {
+	// Verify the token belongs to the session it claims
+	if !tokenMatchesClaims(refreshToken, claims) {
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
 
+	// A rotated token must never come back
+	if refreshToken.RotatedAt != nil {
+		s.revokeTokenFamily(r, claims)
+		http.Error(w, "Refresh token reuse detected", http.StatusUnauthorized)
+		return
+	}
+
 	// Check if refresh token is expired
 	if time.Now().After(refreshToken.ExpiresAt) {
 		// Delete expired refresh token
@@ -258,55 +436,66 @@ func (s *AuthService) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete old access token for this user (if exists)
-	oldToken, err := s.store.GetTokenByUserID(claims.UserID)
-	if err == nil {
-		s.store.DeleteToken(oldToken.ID)
-	}
-
-	// Generate new access token
-	accessToken, accessTokenID, accessExpires, err := s.authUtils.GenerateAccessToken(claims.UserID, claims.Email)
+	// Invalidate the presented token; losing this race means another request used it first
+	rotated, err := s.store.MarkTokenRotated(refreshToken.ID)
 	if err != nil {
-		http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
+		http.Error(w, "Failed to rotate refresh token", http.StatusInternalServerError)
 		return
 	}
-
-	// Store new access token
-	accessTokenDB := &models.Token{
-		UserID:    claims.UserID,
-		Token:     accessTokenID,
-		TokenType: "access",
-		ExpiresAt: accessExpires,
+	if !rotated {
+		s.revokeTokenFamily(r, claims)
+		http.Error(w, "Refresh token reuse detected", http.StatusUnauthorized)
+		return
 	}
 
-	err = s.store.CreateToken(accessTokenDB)
+	// Delete old access tokens of this session
+	err = s.store.DeleteTokensBySessionID(claims.SessionID, "access")
 	if err != nil {
-		http.Error(w, "Failed to store token", http.StatusInternalServerError)
+		http.Error(w, "Failed to revoke old tokens", http.StatusInternalServerError)
 		return
 	}
 
-	// Send response
-	response := TokenResponse{
-		AccessToken:  accessToken,
-		RefreshToken: tokenString, // Keep the same refresh token
-		ExpiresIn:    int64(accessExpires.Sub(time.Now()).Seconds()),
-		TokenType:    "Bearer",
+	// Issue a new token pair in the same family
+	response, err := s.issueTokens(claims.UserID, claims.Email, claims.SessionID)
+	if err != nil {
+		http.Error(w, "Failed to generate tokens", http.StatusInternalServerError)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	s.sessionStore.TouchSession(claims.SessionID)
+
+	s.recordAuditEvent(r, claims.UserID, models.AuditEventTokenRefreshed, map[string]any{
+		"session_id": claims.SessionID,
+	})
+
+	// A refresh token kept in a cookie is rotated into a cookie again
+	s.writeTokenResponse(w, response, fromCookie || s.wantsCookies(r))
 }
 
-// Logout handles user logout by revoking tokens
+// revokeTokenFamily revokes the session a reused refresh token belongs to and records an audit event
+func (s *AuthService) revokeTokenFamily(r *http.Request, claims *utils.Claims) {
+	s.sessionStore.DeleteSession(claims.SessionID, claims.UserID)
+
+	s.recordAuditEvent(r, claims.UserID, models.AuditEventRefreshTokenReuse, map[string]any{
+		"session_id": claims.SessionID,
+		"token_id":   claims.TokenID,
+	})
+}
+
+// Logout handles user logout by revoking the current session
 func (s *AuthService) Logout(w http.ResponseWriter, r *http.Request) {
-	// Extract access token from Authorization header
-	authHeader := r.Header.Get("Authorization")
-	tokenString, err := utils.ExtractTokenFromHeader(authHeader)
+	// Extract access token from Authorization header or cookie
+	tokenString, fromCookie, err := s.requestToken(r, accessTokenCookie)
 	if err != nil {
 		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
 		return
 	}
 
+	if fromCookie && !s.validCSRF(r) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
 	// Verify access token
 	claims, err := s.authUtils.VerifyToken(tokenString)
 	if err != nil {
@@ -314,13 +503,31 @@ func (s *AuthService) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Revoke all tokens for this user
-	err = s.store.DeleteTokensByUserID(claims.UserID)
+	// Ending an impersonation only drops its token; the admin's own session lives on
+	if claims.Type == "impersonation" {
+		token, err := s.store.GetTokenByTokenID(claims.TokenID)
+		if err == nil && tokenMatchesClaims(token, claims) {
+			s.store.DeleteToken(token.ID)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Impersonation ended"))
+		return
+	}
+
+	// Revoke only this session; its tokens are removed with it
+	err = s.sessionStore.DeleteSession(claims.SessionID, claims.UserID)
 	if err != nil {
 		http.Error(w, "Failed to logout", http.StatusInternalServerError)
 		return
 	}
 
+	s.recordAuditEvent(r, claims.UserID, models.AuditEventLogout, map[string]any{
+		"session_id": claims.SessionID,
+	})
+
+	s.clearAuthCookies(w)
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Logged out successfully"))
 }
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+// Names of the cookies set in cookie mode
+const (
+	accessTokenCookie  = "wb_access"
+	refreshTokenCookie = "wb_refresh"
+	csrfTokenCookie    = "wb_csrf"
+
+	// CSRFHeader must repeat the CSRF cookie on state-changing requests authenticated by cookie
+	CSRFHeader = "X-CSRF-Token"
+	// AuthModeHeader set to "cookie" asks for tokens as cookies instead of in the response body
+	AuthModeHeader = "X-Auth-Mode"
+)
+
+// CookieConfig configures the optional cookie mode, in which browsers keep their
+// tokens in HttpOnly cookies instead of storage that scripts can read.
+// Enabled only allows it; each client opts in with AuthModeHeader, so others keep getting tokens in the body.
+type CookieConfig struct {
+	Enabled  bool
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
+}
+
+// csrfCookieName returns the name of the CSRF cookie.
+// Secure cookies use the __Host- prefix, which subdomains cannot overwrite.
+func (c CookieConfig) csrfCookieName() string {
+	if c.Secure && c.Domain == "" {
+		return "__Host-" + csrfTokenCookie
+	}
+	return csrfTokenCookie
+}
+
+// requestToken returns the bearer token of a request, falling back to a cookie in cookie mode.
+// It reports whether the token came from a cookie.
+func (s *AuthService) requestToken(r *http.Request, cookieName string) (string, bool, error) {
+	tokenString, err := utils.ExtractTokenFromHeader(r.Header.Get("Authorization"))
+	if err == nil || !s.cookies.Enabled {
+		return tokenString, false, err
+	}
+
+	cookie, cookieErr := r.Cookie(cookieName)
+	if cookieErr != nil || cookie.Value == "" {
+		return "", false, err
+	}
+	return cookie.Value, true, nil
+}
+
+// validCSRF reports whether a request authenticated by cookie proved it was sent by our frontend.
+// Safe methods don't change state and need no proof.
+func (s *AuthService) validCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	cookie, err := r.Cookie(s.cookies.csrfCookieName())
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	header := r.Header.Get(CSRFHeader)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1
+}
+
+// wantsCookies reports whether the client asked for cookie mode and it is enabled
+func (s *AuthService) wantsCookies(r *http.Request) bool {
+	return s.cookies.Enabled && r.Header.Get(AuthModeHeader) == "cookie"
+}
+
+// writeTokenResponse answers a successful login or refresh.
+// In cookie mode the tokens are only set as cookies and the body carries the CSRF token instead.
+func (s *AuthService) writeTokenResponse(w http.ResponseWriter, response *TokenResponse, cookies bool) {
+	if cookies {
+		csrfToken, err := utils.GenerateTokenID()
+		if err != nil {
+			http.Error(w, "Failed to generate CSRF token", http.StatusInternalServerError)
+			return
+		}
+
+		s.setCookie(w, accessTokenCookie, response.AccessToken, "/", response.accessExpires, true)
+		s.setCookie(w, refreshTokenCookie, response.RefreshToken, "/v1/auth", response.refreshExpires, true)
+		s.setCookie(w, s.cookies.csrfCookieName(), csrfToken, "/", response.refreshExpires, false)
+
+		response = &TokenResponse{
+			ExpiresIn: response.ExpiresIn,
+			TokenType: "Cookie",
+			CSRFToken: csrfToken,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// clearAuthCookies removes the cookies set by writeTokenResponse
+func (s *AuthService) clearAuthCookies(w http.ResponseWriter) {
+	if !s.cookies.Enabled {
+		return
+	}
+
+	s.setCookie(w, accessTokenCookie, "", "/", time.Time{}, true)
+	s.setCookie(w, refreshTokenCookie, "", "/v1/auth", time.Time{}, true)
+	s.setCookie(w, s.cookies.csrfCookieName(), "", "/", time.Time{}, false)
+}
+
+// setCookie sets a cookie that expires with the token it holds; a zero expiry deletes it
+func (s *AuthService) setCookie(w http.ResponseWriter, name, value, path string, expires time.Time, httpOnly bool) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Secure:   s.cookies.Secure,
+		HttpOnly: httpOnly,
+		SameSite: s.cookies.SameSite,
+	}
+
+	// The __Host- prefix forbids a domain attribute
+	if name != "__Host-"+csrfTokenCookie {
+		cookie.Domain = s.cookies.Domain
+	}
+
+	if expires.IsZero() {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expires
+		cookie.MaxAge = int(time.Until(expires).Seconds())
+	}
+
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+const (
+	emailChangeTTL = 24 * time.Hour
+
+	// Accounts without a password confirm sensitive changes by having signed in this recently
+	reauthenticationMaxAge = 10 * time.Minute
+)
+
+// ChangePasswordRequest carries the current password and the one replacing it
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangeEmailRequest carries the new email address, confirmed with the current password.
+// Accounts without a password leave it out and sign in again instead.
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// ChangePassword handles the current user choosing a new password.
+// Every other session is signed out, the one making the change stays.
+func (s *AuthService) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	var req ChangePasswordRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.NewPassword) > utils.MaxPasswordLength {
+		http.Error(w, "Password must be at most 72 bytes", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err = utils.ComparePasswords(req.CurrentPassword, user.PasswordHash)
+	if err != nil {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	passwordHash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.userStore.UpdatePasswordHash(user.ID, passwordHash)
+	if err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.sessionStore.DeleteOtherSessions(user.ID, principal.SessionID)
+	if err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventPasswordChanged, nil)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Password changed successfully"))
+}
+
+// RequestEmailChange handles the current user asking to move their account to a new address.
+// Nothing changes until the link sent to the new address is opened.
+func (s *AuthService) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	var req ChangeEmailRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(email, "@") {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !s.confirmIdentity(w, r, user, req.Password) {
+		return
+	}
+
+	if strings.EqualFold(email, user.Email) {
+		http.Error(w, "This is already your email address", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.userStore.GetUserByEmail(email); err == nil {
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	}
+
+	// The token carries the new address, so confirming it proves ownership of exactly that one
+	token, tokenID, expiresAt, err := s.authUtils.GenerateActionToken(user.ID, email, "email_change", emailChangeTTL)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.store.DeleteTokensByType(user.ID, "email_change")
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.store.CreateToken(&models.Token{
+		UserID:    user.ID,
+		Token:     utils.HashToken(tokenID),
+		TokenType: "email_change",
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		http.Error(w, "Failed to store token", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.mail.SendEmailChangeEmail(email, token)
+	if err != nil {
+		http.Error(w, "Failed to send confirmation email", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventEmailChangeRequested, map[string]any{
+		"new_email": email,
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("A confirmation link has been sent to the new address"))
+}
+
+// ConfirmEmailChange handles switching an account to the new address with the emailed token.
+// The old address is told about the change.
+func (s *AuthService) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := s.authUtils.VerifyToken(req.Token)
+	if err != nil || claims.Type != "email_change" {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// Consuming the token makes it single-use
+	token, err := s.store.ConsumeToken(utils.HashToken(claims.TokenID), "email_change")
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(token.UserID)
+	if err != nil || user.ID != claims.UserID {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// Someone may have registered the address since the change was requested
+	if _, err := s.userStore.GetUserByEmail(claims.Email); err == nil {
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	}
+
+	oldEmail := user.Email
+	err = s.userStore.ChangeEmail(user.ID, claims.Email)
+	if err != nil {
+		http.Error(w, "Failed to change email", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventEmailChanged, map[string]any{
+		"old_email": oldEmail,
+		"new_email": claims.Email,
+	})
+
+	go s.mail.SendEmailChangedEmail(oldEmail, claims.Email)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Email changed successfully"))
+}
+
+// confirmIdentity checks that a sensitive change comes from the account owner and writes
+// the error response if not. Users with a password have to enter it; users who only sign
+// in through an identity provider or a magic link have to have signed in within the last
+// few minutes, which the current session proves.
+func (s *AuthService) confirmIdentity(w http.ResponseWriter, r *http.Request, user *models.User, password string) bool {
+	if user.PasswordHash != "" {
+		err := utils.ComparePasswords(password, user.PasswordHash)
+		if err != nil {
+			http.Error(w, "Current password is incorrect", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+
+	session, err := s.sessionStore.GetSessionByID(rbac.FromContext(r.Context()).SessionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Session not found", http.StatusUnauthorized)
+			return false
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+
+	if time.Since(session.CreatedAt) > reauthenticationMaxAge {
+		http.Error(w, "Sign in again to confirm this change", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+)
+
+const (
+	// Archives can be downloaded for a week
+	dataExportTTL = 7 * 24 * time.Hour
+
+	// Exports a worker claimed but didn't finish in time are retried
+	dataExportStaleAfter = 15 * time.Minute
+
+	dataExportPollInterval = time.Minute
+)
+
+// DataExportStores groups the stores the data export reads from
+type DataExportStores struct {
+	Exports     *models.DataExportStore
+	Users       *models.UserStore
+	Sessions    *models.SessionStore
+	Workspaces  *models.WorkspaceStore
+	APITokens   *models.APITokenStore
+	Identities  *models.UserIdentityStore
+	Passkeys    *models.WebAuthnCredentialStore
+	AuditEvents *models.AuditEventStore
+	Sites       *models.SiteStore
+	Pages       *models.PageStore
+	Revisions   *models.PageRevisionStore
+}
+
+// DataExportService creates downloadable archives of everything stored about a user.
+// Archives are built in the background, so requesting one returns right away.
+type DataExportService struct {
+	store    *models.DataExportStore
+	users    *models.UserStore
+	sections []exportSection
+	mail     *MailService
+	logger   *log.Logger
+	wake     chan struct{}
+}
+
+// exportedPage is a page of an exported site with its current content and every revision
+type exportedPage struct {
+	*models.Page
+	Content   json.RawMessage        `json:"content"`
+	Revisions []*models.PageRevision `json:"revisions"`
+}
+
+// exportSection is one JSON file of an export archive
+type exportSection struct {
+	name string
+	load func(userID int) (any, error)
+}
+
+// NewDataExportService creates a new DataExportService reading from the given stores
+func NewDataExportService(stores DataExportStores, mail *MailService, logger *log.Logger) *DataExportService {
+	return &DataExportService{
+		store: stores.Exports,
+		users: stores.Users,
+		sections: []exportSection{
+			{"profile.json", func(userID int) (any, error) { return stores.Users.GetUserByID(userID) }},
+			{"sessions.json", func(userID int) (any, error) { return stores.Sessions.GetSessionsByUserID(userID) }},
+			{"workspaces.json", func(userID int) (any, error) { return stores.Workspaces.GetWorkspacesByUserID(userID) }},
+			{"api_tokens.json", func(userID int) (any, error) { return stores.APITokens.GetAPITokensByUserID(userID) }},
+			{"identities.json", func(userID int) (any, error) { return stores.Identities.GetIdentitiesByUserID(userID) }},
+			{"passkeys.json", func(userID int) (any, error) { return stores.Passkeys.GetCredentialsByUserID(userID) }},
+			{"sites.json", func(userID int) (any, error) { return stores.Sites.GetSitesByOwnerID(userID) }},
+			{"pages.json", func(userID int) (any, error) { return allSitePages(stores, userID) }},
+			{"audit_events.json", func(userID int) (any, error) { return allAuditEvents(stores.AuditEvents, userID) }},
+		},
+		mail:   mail,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// allAuditEvents pages through every audit event about a user
+func allAuditEvents(store *models.AuditEventStore, userID int) ([]*models.AuditEvent, error) {
+	var events []*models.AuditEvent
+	filter := models.AuditEventFilter{UserID: userID, Limit: maxAuditPageSize}
+	for {
+		page, err := store.ListAuditEvents(filter)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+		if len(page) < filter.Limit {
+			return events, nil
+		}
+		filter.Before = page[len(page)-1].ID
+	}
+}
+
+// allSitePages collects the pages of every site a user created, with their content and history
+func allSitePages(stores DataExportStores, userID int) ([]*exportedPage, error) {
+	sites, err := stores.Sites.GetSitesByOwnerID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := []*exportedPage{}
+	for _, site := range sites {
+		tree, err := stores.Pages.GetPageTree(site.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, page := range tree {
+			content, err := stores.Pages.GetPageContent(site.ID, page.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			revisions, err := stores.Revisions.GetAllRevisionsByPageID(page.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			pages = append(pages, &exportedPage{Page: page, Content: content, Revisions: revisions})
+		}
+	}
+	return pages, nil
+}
+
+// RequestExport handles the current user asking for an archive of their data
+func (s *DataExportService) RequestExport(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	export := &models.DataExport{UserID: principal.UserID}
+	err := s.store.CreateDataExport(export)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "An export is already in progress", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create export", http.StatusInternalServerError)
+		return
+	}
+	export.UserID = principal.UserID
+
+	// Start right away instead of waiting for the next poll
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(export)
+}
+
+// ListExports handles listing the data exports of the current user
+func (s *DataExportService) ListExports(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	exports, err := s.store.GetDataExportsByUserID(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list exports", http.StatusInternalServerError)
+		return
+	}
+
+	if exports == nil {
+		exports = []*models.DataExport{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exports)
+}
+
+// GetExport handles checking on a data export of the current user
+func (s *DataExportService) GetExport(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid export ID", http.StatusBadRequest)
+		return
+	}
+
+	export, err := s.store.GetDataExport(id, principal.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Export not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(export)
+}
+
+// DownloadExport handles downloading the ZIP archive of a finished data export
+func (s *DataExportService) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid export ID", http.StatusBadRequest)
+		return
+	}
+
+	archive, err := s.store.GetDataExportArchive(id, principal.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Export not found or not ready", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="website-builder-export-`+strconv.Itoa(id)+`.zip"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+	w.Write(archive)
+}
+
+// Start processes queued exports in the background
+func (s *DataExportService) Start() {
+	go func() {
+		ticker := time.NewTicker(dataExportPollInterval)
+		defer ticker.Stop()
+
+		for {
+			s.processPending()
+
+			if err := s.store.DeleteExpiredDataExports(dataExportTTL); err != nil {
+				s.logger.Printf("failed to delete expired data exports: %v", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.wake:
+			}
+		}
+	}()
+}
+
+// processPending builds archives until no export is waiting
+func (s *DataExportService) processPending() {
+	for {
+		export, err := s.store.ClaimDataExport(dataExportStaleAfter)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				s.logger.Printf("failed to claim data export: %v", err)
+			}
+			return
+		}
+
+		archive, err := s.buildArchive(export.UserID)
+		if err != nil {
+			s.logger.Printf("failed to build data export %d: %v", export.ID, err)
+			s.store.FailDataExport(export.ID, "Failed to collect data")
+			continue
+		}
+
+		err = s.store.CompleteDataExport(export.ID, archive, time.Now().Add(dataExportTTL))
+		if err != nil {
+			s.logger.Printf("failed to store data export %d: %v", export.ID, err)
+			continue
+		}
+
+		if user, err := s.users.GetUserByID(export.UserID); err == nil {
+			s.mail.SendDataExportReadyEmail(user.Email)
+		}
+	}
+}
+
+// buildArchive writes every export section of a user as a JSON file into a ZIP archive
+func (s *DataExportService) buildArchive(userID int) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	files := make([]string, 0, len(s.sections))
+	for _, section := range s.sections {
+		data, err := section.load(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = writeJSONFile(archive, section.name, data)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, section.name)
+	}
+
+	err := writeJSONFile(archive, "manifest.json", map[string]any{
+		"user_id":      userID,
+		"generated_at": time.Now().UTC(),
+		"files":        files,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeJSONFile adds a file with the indented JSON encoding of data to a ZIP archive
+func writeJSONFile(archive *zip.Writer, name string, data any) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+// Impersonation tokens can't be refreshed; support asks for a new one instead
+const impersonationTTL = 15 * time.Minute
+
+// ImpersonateRequest explains why an admin needs to act as a user
+type ImpersonateRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ImpersonationResponse carries the access token for acting as another user.
+// It is always returned in the body so the admin's own cookies stay untouched.
+type ImpersonationResponse struct {
+	AccessToken string       `json:"access_token"`
+	ExpiresIn   int64        `json:"expires_in"`
+	TokenType   string       `json:"token_type"`
+	User        *models.User `json:"user"`
+}
+
+// Impersonate handles an administrator getting a short-lived token to act as another user.
+// Admins can only impersonate users whose role they could manage.
+func (s *AuthService) Impersonate(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ImpersonateRequest
+
+	// Parse JSON body
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	if id == principal.UserID {
+		http.Error(w, "You cannot impersonate yourself", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if !principal.Role.CanManage(rbac.Role(user.Role)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	actor := utils.Actor{UserID: principal.UserID, Email: principal.Email}
+	accessToken, tokenID, expiresAt, err := s.authUtils.GenerateImpersonationToken(user.ID, user.Email, principal.SessionID, actor, impersonationTTL)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.store.CreateToken(&models.Token{
+		UserID:    user.ID,
+		SessionID: &principal.SessionID,
+		Token:     tokenID,
+		TokenType: "impersonation",
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		http.Error(w, "Failed to store token", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventImpersonationStarted, map[string]any{
+		"reason":     strings.TrimSpace(req.Reason),
+		"token_id":   tokenID,
+		"expires_at": expiresAt,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ImpersonationResponse{
+		AccessToken: accessToken,
+		ExpiresIn:   int64(time.Until(expiresAt).Seconds()),
+		TokenType:   "Bearer",
+		User:        user,
+	})
+}
+
+// authenticateImpersonation authenticates a request made with an impersonation token and passes it on.
+// The admin must still be allowed to manage users, destructive requests are refused
+// and every request is written to the audit log.
+func (s *AuthService) authenticateImpersonation(w http.ResponseWriter, r *http.Request, next http.Handler, claims *utils.Claims, user *models.User) {
+	if claims.Actor == nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	admin, err := s.userStore.GetUserByID(claims.Actor.UserID)
+	if err != nil || !rbac.Role(admin.Role).Has(rbac.PermissionUsersManage) {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	// Support may look around but not delete anything on the user's behalf
+	if r.Method == http.MethodDelete {
+		http.Error(w, "Not allowed while impersonating", http.StatusForbidden)
+		return
+	}
+
+	s.sessionStore.TouchSession(claims.SessionID)
+
+	r = r.WithContext(rbac.WithPrincipal(r.Context(), &rbac.Principal{
+		UserID:         user.ID,
+		Email:          user.Email,
+		Role:           rbac.Role(user.Role),
+		SessionID:      claims.SessionID,
+		TokenID:        claims.TokenID,
+		ImpersonatorID: admin.ID,
+	}))
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventImpersonatedRequest, map[string]any{
+		"method":   r.Method,
+		"path":     r.URL.Path,
+		"token_id": claims.TokenID,
+	})
+
+	next.ServeHTTP(w, r)
+}
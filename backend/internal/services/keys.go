@@ -0,0 +1,161 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+// KeyConfig holds the configuration for signing key rotation
+type KeyConfig struct {
+	Algorithm        string
+	RotationInterval time.Duration
+	GracePeriod      time.Duration // How long replaced keys keep verifying tokens
+	ReloadInterval   time.Duration
+}
+
+// KeyService keeps the JWT key set in sync with the database and rotates keys on schedule
+type KeyService struct {
+	store  *models.SigningKeyStore
+	keys   *utils.KeySet
+	config KeyConfig
+	logger *log.Logger
+}
+
+// NewKeyService creates a new KeyService with the given store, key set and config
+func NewKeyService(store *models.SigningKeyStore, keys *utils.KeySet, config KeyConfig, logger *log.Logger) *KeyService {
+	// Set default intervals if not provided
+	if config.Algorithm == "" {
+		config.Algorithm = utils.AlgorithmRS256
+	}
+	if config.RotationInterval == 0 {
+		config.RotationInterval = 30 * 24 * time.Hour
+	}
+	if config.ReloadInterval == 0 {
+		config.ReloadInterval = 5 * time.Minute
+	}
+
+	return &KeyService{
+		store:  store,
+		keys:   keys,
+		config: config,
+		logger: logger,
+	}
+}
+
+// Start periodically syncs the key set in the background
+func (s *KeyService) Start() {
+	go func() {
+		ticker := time.NewTicker(s.config.ReloadInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := s.Sync(); err != nil {
+				s.logger.Printf("failed to sync signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// Sync rotates the signing key if it is due and loads all valid keys into the key set
+func (s *KeyService) Sync() error {
+	keys, err := s.store.GetSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if s.rotationDue(keys, now) {
+		// New keys are published before they sign anything, so other replicas
+		// and JWKS caches know them by the time tokens show up
+		activatesAt := now.Add(2 * s.config.ReloadInterval)
+		if len(keys) == 0 {
+			activatesAt = now
+		}
+
+		err = s.rotate(now, activatesAt)
+		if err != nil {
+			return err
+		}
+
+		keys, err = s.store.GetSigningKeys()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.store.DeleteExpiredSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	signingKeys := make([]*utils.SigningKey, 0, len(keys))
+	for _, key := range keys {
+		private, err := utils.ParsePrivateKey(key.PrivateKey)
+		if err != nil {
+			return err
+		}
+		signingKeys = append(signingKeys, &utils.SigningKey{
+			ID:          key.KID,
+			Algorithm:   key.Algorithm,
+			Private:     private,
+			ActivatesAt: key.ActivatesAt,
+		})
+	}
+
+	s.keys.Replace(signingKeys)
+	return nil
+}
+
+// rotationDue reports whether the newest key is too old or uses a different algorithm
+func (s *KeyService) rotationDue(keys []*models.SigningKey, now time.Time) bool {
+	var newest *models.SigningKey
+	for _, key := range keys {
+		if key.ExpiresAt == nil {
+			newest = key
+		}
+	}
+
+	return newest == nil ||
+		newest.Algorithm != s.config.Algorithm ||
+		newest.CreatedAt.Before(now.Add(-s.config.RotationInterval))
+}
+
+func (s *KeyService) rotate(now time.Time, activatesAt time.Time) error {
+	key, err := utils.GenerateSigningKey(s.config.Algorithm, activatesAt)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := utils.EncodePrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+
+	rotated, err := s.store.RotateSigningKey(&models.SigningKey{
+		KID:         key.ID,
+		Algorithm:   key.Algorithm,
+		PrivateKey:  encoded,
+		ActivatesAt: key.ActivatesAt,
+	}, now.Add(-s.config.RotationInterval), s.config.GracePeriod)
+	if err != nil {
+		return err
+	}
+
+	if rotated {
+		s.logger.Printf("rotated signing key, new kid %s activates at %s", key.ID, activatesAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// JWKS handles serving the public signing keys as a JSON Web Key Set
+func (s *KeyService) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	// Short enough that verifiers see a new key before it starts signing
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(s.keys.JWKS())
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+)
+
+const (
+	// Failed logins are forgotten after a day without failures
+	loginFailureWindow = 24 * time.Hour
+
+	// Failures allowed before each further attempt has to wait,
+	// starting at loginBackoffBase and doubling up to loginBackoffMax
+	accountFreeAttempts = 3
+	ipFreeAttempts      = 20
+	loginBackoffBase    = time.Second
+	loginBackoffMax     = 15 * time.Minute
+
+	// Every accountLockoutThreshold failures lock the account and notify its owner
+	accountLockoutThreshold = 10
+	accountLockoutDuration  = 30 * time.Minute
+)
+
+// An email with a token is sent to a user at most once per emailCooldown for each kind of token
+const emailCooldown = time.Minute
+
+// throttleAccountKey normalizes an email so case variations share one counter
+func throttleAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// throttleRetryAt returns when the next login for a throttled key is allowed
+func throttleRetryAt(throttle *models.LoginThrottle, freeAttempts int) time.Time {
+	retryAt := throttle.LastFailedAt
+	if throttle.FailedCount >= freeAttempts {
+		exponent := float64(throttle.FailedCount - freeAttempts)
+		delay := time.Duration(math.Min(float64(loginBackoffBase)*math.Pow(2, exponent), float64(loginBackoffMax)))
+		retryAt = retryAt.Add(delay)
+	}
+
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(retryAt) {
+		retryAt = *throttle.LockedUntil
+	}
+	return retryAt
+}
+
+// Throttle policies of accounts and client IPs
+var (
+	accountThrottlePolicy = models.ThrottlePolicy{
+		Window:       loginFailureWindow,
+		FreeAttempts: accountFreeAttempts,
+		BackoffBase:  loginBackoffBase,
+		BackoffMax:   loginBackoffMax,
+	}
+	ipThrottlePolicy = models.ThrottlePolicy{
+		Window:       loginFailureWindow,
+		FreeAttempts: ipFreeAttempts,
+		BackoffBase:  loginBackoffBase,
+		BackoffMax:   loginBackoffMax,
+	}
+	emailThrottlePolicy = models.ThrottlePolicy{
+		Window:       emailCooldown,
+		FreeAttempts: 1,
+		BackoffBase:  emailCooldown,
+		BackoffMax:   emailCooldown,
+	}
+)
+
+// reserveLoginAttempt counts a login attempt for the account and IP as failed before
+// anything is checked, so concurrent attempts can't all get past the backoff; attempts
+// that succeed are taken back with releaseLoginAttempt. When either key is throttled
+// nothing is counted and it returns how long to wait instead.
+// The throttles live in Postgres so every replica sees the same counts.
+func (s *AuthService) reserveLoginAttempt(accountKey string, ip string) (*models.LoginThrottle, time.Duration, error) {
+	account, err := s.throttleStore.ReserveAttempt(models.ThrottleKeyAccount, accountKey, accountThrottlePolicy)
+	if err == sql.ErrNoRows {
+		wait, err := s.throttleWait(models.ThrottleKeyAccount, accountKey, accountFreeAttempts)
+		return nil, wait, err
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	_, err = s.throttleStore.ReserveAttempt(models.ThrottleKeyIP, ip, ipThrottlePolicy)
+	if err == sql.ErrNoRows {
+		s.throttleStore.ReleaseAttempt(models.ThrottleKeyAccount, accountKey)
+		wait, err := s.throttleWait(models.ThrottleKeyIP, ip, ipFreeAttempts)
+		return nil, wait, err
+	}
+	if err != nil {
+		s.throttleStore.ReleaseAttempt(models.ThrottleKeyAccount, accountKey)
+		return nil, 0, err
+	}
+
+	return account, 0, nil
+}
+
+// releaseLoginAttempt takes back an attempt reserved by reserveLoginAttempt whose factor checked out
+func (s *AuthService) releaseLoginAttempt(accountKey string, ip string) {
+	s.throttleStore.ReleaseAttempt(models.ThrottleKeyAccount, accountKey)
+	s.throttleStore.ReleaseAttempt(models.ThrottleKeyIP, ip)
+}
+
+// throttleWait returns how long a throttled key has to wait, at least loginBackoffBase
+// since it was throttled a moment ago
+func (s *AuthService) throttleWait(keyType string, key string, freeAttempts int) (time.Duration, error) {
+	throttle, err := s.throttleStore.GetThrottle(keyType, key)
+	if err == sql.ErrNoRows {
+		return loginBackoffBase, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return max(time.Until(throttleRetryAt(throttle, freeAttempts)), loginBackoffBase), nil
+}
+
+// recordFailedLogin records a failed login whose attempt reserveLoginAttempt already
+// counted, whether the password or the second factor was wrong, and locks the account
+// every accountLockoutThreshold failures.
+// The user is nil for unknown emails, which are throttled all the same.
+func (s *AuthService) recordFailedLogin(r *http.Request, accountKey string, user *models.User, factor string, throttle *models.LoginThrottle) {
+	userID := 0
+	if user != nil {
+		userID = user.ID
+	}
+	s.recordAuditEvent(r, userID, models.AuditEventLoginFailed, map[string]any{
+		"email":  accountKey,
+		"factor": factor,
+	})
+
+	if throttle.FailedCount%accountLockoutThreshold != 0 {
+		return
+	}
+
+	lockedUntil := time.Now().Add(accountLockoutDuration)
+	err := s.throttleStore.LockThrottle(models.ThrottleKeyAccount, accountKey, lockedUntil)
+	if err != nil || user == nil {
+		return
+	}
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventAccountLocked, map[string]any{
+		"failed_count": throttle.FailedCount,
+		"locked_until": lockedUntil,
+	})
+
+	// Send in the background so response times don't reveal which addresses exist
+	go func() {
+		if err := s.mail.SendAccountLockedEmail(user.Email, lockedUntil); err != nil {
+			s.logger.Printf("failed to send account locked email to user %d: %v", user.ID, err)
+		}
+	}()
+}
+
+// sendTokenEmailInBackground emails a user a token of the given type unless one was sent
+// within emailCooldown, so unauthenticated requests can't flood an inbox. It runs in the
+// background so response times don't reveal which addresses exist; failures are logged.
+func (s *AuthService) sendTokenEmailInBackground(user *models.User, tokenType string, send func(*models.User) error) {
+	go func() {
+		key := tokenType + ":" + strconv.Itoa(user.ID)
+		_, err := s.throttleStore.ReserveAttempt(models.ThrottleKeyEmail, key, emailThrottlePolicy)
+		if err == sql.ErrNoRows {
+			return
+		}
+		if err != nil {
+			s.logger.Printf("failed to check %s email cooldown of user %d: %v", tokenType, user.ID, err)
+			return
+		}
+
+		if err := send(user); err != nil {
+			s.logger.Printf("failed to send %s email to user %d: %v", tokenType, user.ID, err)
+		}
+	}()
+}
+
+// tooManyLoginAttempts rejects a throttled login
+func tooManyLoginAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+}
+
+// UnlockUser handles an administrator lifting the login lockout of a user
+func (s *AuthService) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	adminID := rbac.FromContext(r.Context()).UserID
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.throttleStore.ClearThrottle(models.ThrottleKeyAccount, throttleAccountKey(user.Email))
+	if err != nil {
+		http.Error(w, "Failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventAccountUnlocked, map[string]any{
+		"admin_id": adminID,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("User unlocked successfully"))
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/mailer"
+)
+
+// MailService composes and sends the transactional emails of the application
+type MailService struct {
+	mailer mailer.Mailer
+	appURL string
+}
+
+// NewMailService creates a new MailService that links to pages of the frontend at appURL
+func NewMailService(mailer mailer.Mailer, appURL string) *MailService {
+	return &MailService{
+		mailer: mailer,
+		appURL: strings.TrimRight(appURL, "/"),
+	}
+}
+
+// SendVerificationEmail sends the link that proves ownership of an email address
+func (s *MailService) SendVerificationEmail(to string, token string) error {
+	link := s.link("/auth/verify-email", token)
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome to Website Builder!\n\n"+
+			"Please confirm your email address by opening the link below:\n\n%s\n\n"+
+			"If you did not create an account, you can ignore this email.\n", link),
+	})
+}
+
+// SendPasswordResetEmail sends the link that lets a user choose a new password
+func (s *MailService) SendPasswordResetEmail(to string, token string) error {
+	link := s.link("/auth/reset-password", token)
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Website Builder account.\n\n"+
+			"Open the link below within 30 minutes to choose a new password:\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n", link),
+	})
+}
+
+// SendMagicLinkEmail sends a link that signs the user in without a password
+func (s *MailService) SendMagicLinkEmail(to string, token string) error {
+	link := s.link("/auth/magic-link", token)
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Open the link below within 15 minutes to sign in to Website Builder:\n\n%s\n\n"+
+			"The link works only once. If you did not ask for it, you can ignore this email.\n", link),
+	})
+}
+
+// SendAccountLockedEmail warns a user that logins to their account were blocked after repeated failures
+func (s *MailService) SendAccountLockedEmail(to string, until time.Time) error {
+	link := s.appURL + "/auth/forgot-password"
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: "Your account has been temporarily locked",
+		Body: fmt.Sprintf("We blocked sign-ins to your Website Builder account after several failed attempts.\n\n"+
+			"You can sign in again after %s.\n\n"+
+			"If this wasn't you, someone may be guessing your password. Consider choosing a new one:\n\n%s\n",
+			until.UTC().Format("2006-01-02 15:04 MST"), link),
+	})
+}
+
+// SendEmailChangeEmail sends the link that confirms a new email address for an account
+func (s *MailService) SendEmailChangeEmail(to string, token string) error {
+	link := s.link("/auth/confirm-email-change", token)
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Someone asked to use this address for their Website Builder account.\n\n"+
+			"Open the link below within 24 hours to confirm the change:\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n", link),
+	})
+}
+
+// SendEmailChangedEmail tells the previous address of an account that it has been replaced
+func (s *MailService) SendEmailChangedEmail(to string, newEmail string) error {
+	link := s.appURL + "/auth/forgot-password"
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: "Your email address has been changed",
+		Body: fmt.Sprintf("The email address of your Website Builder account was changed to %s.\n\n"+
+			"If this wasn't you, reset your password and contact support right away:\n\n%s\n", newEmail, link),
+	})
+}
+
+// SendDataExportReadyEmail tells a user that the archive of their data can be downloaded
+func (s *MailService) SendDataExportReadyEmail(to string) error {
+	link := s.appURL + "/settings/privacy"
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf("The archive of your Website Builder data is ready.\n\n"+
+			"Sign in and download it within 7 days from your privacy settings:\n\n%s\n", link),
+	})
+}
+
+// SendAccountDeletionScheduledEmail confirms that an account will be deleted and how to stop it
+func (s *MailService) SendAccountDeletionScheduledEmail(to string, at time.Time) error {
+	link := s.appURL + "/settings/privacy"
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Your Website Builder account and all of its data will be deleted on %s.\n\n"+
+			"Changed your mind? Sign in and cancel the deletion before then:\n\n%s\n",
+			at.UTC().Format("2006-01-02 15:04 MST"), link),
+	})
+}
+
+// SendWorkspaceInvitationEmail sends the link that lets someone join a workspace
+func (s *MailService) SendWorkspaceInvitationEmail(to string, workspaceName string, inviter string, token string) error {
+	link := s.link("/invitations", token)
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: fmt.Sprintf("You have been invited to %s", workspaceName),
+		Body: fmt.Sprintf("%s invited you to the workspace %q on Website Builder.\n\n"+
+			"Open the link below within 7 days to accept or decline the invitation:\n\n%s\n\n"+
+			"If you did not expect this, you can ignore this email.\n", inviter, workspaceName, link),
+	})
+}
+
+// link builds a frontend URL carrying a token as query parameter
+func (s *MailService) link(path string, token string) string {
+	return s.appURL + path + "?token=" + url.QueryEscape(token)
+}
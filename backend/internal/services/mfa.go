@@ -0,0 +1,424 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+const (
+	totpIssuer            = "Website Builder"
+	mfaChallengeTTL       = 5 * time.Minute
+	maxMFAAttempts        = 5
+	recoveryCodeCount     = 10
+	mfaMethodTOTP         = "totp"
+	mfaMethodRecoveryCode = "recovery_code"
+)
+
+// MFAChallengeResponse is returned by Login instead of a TokenResponse when a second factor is required
+type MFAChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+	ExpiresIn   int64    `json:"expires_in"`
+}
+
+// MFAVerifyRequest completes an MFA challenge with a TOTP code or a recovery code
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TOTPCodeRequest carries a TOTP code, or a recovery code in its place
+type TOTPCodeRequest struct {
+	Password     string `json:"password"` // Left out by accounts without a password
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TOTPEnrollResponse holds what an authenticator app needs to add the account
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"` // QR code payload
+}
+
+// RecoveryCodesResponse holds freshly generated recovery codes, shown to the user only once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAStatusResponse describes the second factors of the current user
+type MFAStatusResponse struct {
+	TOTPEnabled            bool `json:"totp_enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// mfaEnabled reports whether the user has a confirmed TOTP authenticator
+func (s *AuthService) mfaEnabled(userID int) (bool, error) {
+	totp, err := s.totpStore.GetTOTPByUserID(userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.ConfirmedAt != nil, nil
+}
+
+// createMFAChallenge stores a short-lived challenge that can be exchanged for tokens with a second factor
+func (s *AuthService) createMFAChallenge(user *models.User) (*MFAChallengeResponse, error) {
+	token, err := utils.GenerateTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.challengeStore.CreateChallenge(&models.MFAChallenge{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		Methods:     []string{mfaMethodTOTP, mfaMethodRecoveryCode},
+		ExpiresIn:   int64(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// checkSecondFactor validates a TOTP code, or consumes a recovery code if no TOTP code is given
+func (s *AuthService) checkSecondFactor(r *http.Request, totp *models.UserTOTP, code string, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := utils.ValidateTOTP(totp.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		// Each code is accepted only once
+		return s.totpStore.UseTOTPStep(totp.UserID, step)
+	}
+
+	if recoveryCode != "" {
+		used, err := s.recoveryCodeStore.ConsumeRecoveryCode(totp.UserID, utils.HashToken(utils.NormalizeRecoveryCode(recoveryCode)))
+		if err != nil || !used {
+			return false, err
+		}
+		s.recordAuditEvent(r, totp.UserID, models.AuditEventRecoveryCodeUsed, nil)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes and stores their hashes
+func (s *AuthService) replaceRecoveryCodes(userID int) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(utils.NormalizeRecoveryCode(code))
+	}
+
+	err = s.recoveryCodeStore.ReplaceRecoveryCodes(userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyMFA handles the second step of a login by exchanging an MFA challenge for tokens
+func (s *AuthService) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFAVerifyRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := s.challengeStore.GetChallengeByTokenHash(utils.HashToken(req.MFAToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(challenge.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	// Wrong codes share the password throttles, so fresh challenges don't buy more guesses
+	accountKey := throttleAccountKey(user.Email)
+	ip := utils.ClientIP(r)
+	throttle, wait, err := s.reserveLoginAttempt(accountKey, ip)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		tooManyLoginAttempts(w, wait)
+		return
+	}
+
+	totp, err := s.totpStore.GetTOTPByUserID(challenge.UserID)
+	if err != nil || totp.ConfirmedAt == nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	ok, err := s.checkSecondFactor(r, totp, req.Code, req.RecoveryCode)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// Too many wrong codes burn the challenge; the user has to log in again
+		attempts, err := s.challengeStore.IncrementAttempts(challenge.ID)
+		if err != nil || attempts >= maxMFAAttempts {
+			s.challengeStore.DeleteChallenge(challenge.ID)
+		}
+		s.recordFailedLogin(r, accountKey, user, "mfa", throttle)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	s.releaseLoginAttempt(accountKey, ip)
+
+	err = s.challengeStore.DeleteChallenge(challenge.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.signIn(w, r, user)
+}
+
+// MFAStatus handles reporting which second factors the current user has enabled
+func (s *AuthService) MFAStatus(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	enabled, err := s.mfaEnabled(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	remaining, err := s.recoveryCodeStore.CountRecoveryCodes(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAStatusResponse{
+		TOTPEnabled:            enabled,
+		RecoveryCodesRemaining: remaining,
+	})
+}
+
+// EnrollTOTP handles starting TOTP enrollment by generating a new secret.
+// The authenticator only protects logins after ConfirmTOTP.
+func (s *AuthService) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	enabled, err := s.mfaEnabled(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "TOTP is already enabled", http.StatusConflict)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.totpStore.SaveTOTP(&models.UserTOTP{
+		UserID: userID,
+		Secret: secret,
+	})
+	if err != nil {
+		http.Error(w, "Failed to store secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: utils.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP handles finishing TOTP enrollment with a code from the authenticator app
+func (s *AuthService) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	var req TOTPCodeRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	totp, err := s.totpStore.GetTOTPByUserID(userID)
+	if err != nil {
+		http.Error(w, "TOTP enrollment not started", http.StatusBadRequest)
+		return
+	}
+	if totp.ConfirmedAt != nil {
+		http.Error(w, "TOTP is already enabled", http.StatusConflict)
+		return
+	}
+
+	ok, err := s.checkSecondFactor(r, totp, req.Code, "")
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	err = s.totpStore.ConfirmTOTP(userID)
+	if err != nil {
+		http.Error(w, "Failed to enable TOTP", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := s.replaceRecoveryCodes(userID)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAuditEvent(r, userID, models.AuditEventMFAEnabled, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP handles turning off TOTP, which requires a current code and the password,
+// or a recent sign in for accounts without one
+func (s *AuthService) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	var req TOTPCodeRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !s.confirmIdentity(w, r, user, req.Password) {
+		return
+	}
+
+	totp, err := s.totpStore.GetTOTPByUserID(userID)
+	if err != nil || totp.ConfirmedAt == nil {
+		http.Error(w, "TOTP is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := s.checkSecondFactor(r, totp, req.Code, req.RecoveryCode)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	err = s.totpStore.DeleteTOTP(userID)
+	if err != nil {
+		http.Error(w, "Failed to disable TOTP", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.recoveryCodeStore.DeleteRecoveryCodes(userID)
+	if err != nil {
+		http.Error(w, "Failed to delete recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAuditEvent(r, userID, models.AuditEventMFADisabled, nil)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("TOTP disabled successfully"))
+}
+
+// RegenerateRecoveryCodes handles replacing all recovery codes, which requires a current TOTP code
+func (s *AuthService) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	var req TOTPCodeRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	totp, err := s.totpStore.GetTOTPByUserID(userID)
+	if err != nil || totp.ConfirmedAt == nil {
+		http.Error(w, "TOTP is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := s.checkSecondFactor(r, totp, req.Code, "")
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := s.replaceRecoveryCodes(userID)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
@@ -0,0 +1,514 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateTTL = 10 * time.Minute
+
+	// oidcStateCookie ties a flow to the browser that started it, so nobody else can finish it
+	oidcStateCookie = "wb_oidc_state"
+
+	// Usernames derived from claims are cut to what the users table holds
+	maxOIDCUsernameLength = 100
+)
+
+// OIDCProviderConfig configures an OpenID Connect provider users can sign in with
+type OIDCProviderConfig struct {
+	Name         string // Used in URLs and stored with linked identities, e.g. "google"
+	Issuer       string // Discovery is done against Issuer + "/.well-known/openid-configuration"
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // Defaults to openid, email and profile
+}
+
+// oidcProvider is a configured provider whose discovery document is loaded on first use
+type oidcProvider struct {
+	config OIDCProviderConfig
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// OIDCService handles signing in with external OpenID Connect providers and linking them to accounts
+type OIDCService struct {
+	providers     map[string]*oidcProvider
+	httpClient    *http.Client
+	stateStore    models.OIDCStateRepository
+	identityStore models.UserIdentityRepository
+	userStore     models.UserRepository
+	auth          *AuthService
+}
+
+// OIDCAuthorizeResponse carries the provider URL the browser should be sent to
+type OIDCAuthorizeResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// OIDCCallbackRequest carries the query parameters the provider redirected back with
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// OIDCProviderResponse describes a provider that can be offered on the login page
+type OIDCProviderResponse struct {
+	Name string `json:"name"`
+}
+
+// oidcClaims are the ID token claims used to find or create a user
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// NewOIDCService creates a new OIDCService with the given providers, stores and auth service
+func NewOIDCService(providers []OIDCProviderConfig, stateStore models.OIDCStateRepository, identityStore models.UserIdentityRepository, userStore models.UserRepository, auth *AuthService) *OIDCService {
+	configured := make(map[string]*oidcProvider, len(providers))
+	for _, config := range providers {
+		if len(config.Scopes) == 0 {
+			config.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+		}
+		configured[config.Name] = &oidcProvider{config: config}
+	}
+
+	return &OIDCService{
+		providers:     configured,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		stateStore:    stateStore,
+		identityStore: identityStore,
+		userStore:     userStore,
+		auth:          auth,
+	}
+}
+
+// context returns a request context the OIDC and OAuth2 clients make their calls with
+func (s *OIDCService) context(r *http.Request) context.Context {
+	return oidc.ClientContext(r.Context(), s.httpClient)
+}
+
+// provider returns the named provider, running discovery if it has not succeeded yet.
+// A provider that is down at startup therefore does not keep the others from working.
+func (s *OIDCService) provider(ctx context.Context, name string) (*oidcProvider, error) {
+	p, ok := s.providers[name]
+	if !ok {
+		return nil, errors.New("unknown provider")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth2 != nil {
+		return p, nil
+	}
+
+	discovered, err := oidc.NewProvider(ctx, p.config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     discovered.Endpoint(),
+		Scopes:       p.config.Scopes,
+	}
+	p.verifier = discovered.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+
+	return p, nil
+}
+
+// ListProviders handles listing the configured providers
+func (s *OIDCService) ListProviders(w http.ResponseWriter, r *http.Request) {
+	providers := make([]OIDCProviderResponse, 0, len(s.providers))
+	for name := range s.providers {
+		providers = append(providers, OIDCProviderResponse{Name: name})
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].Name < providers[j].Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(providers)
+}
+
+// authorize starts an authorization code flow with PKCE and answers with the provider URL.
+// A non-nil userID links the resulting identity to that user instead of signing in.
+func (s *OIDCService) authorize(w http.ResponseWriter, r *http.Request, userID *int) {
+	name := r.PathValue("provider")
+
+	p, err := s.provider(s.context(r), name)
+	if err != nil {
+		http.Error(w, "Provider not available", http.StatusNotFound)
+		return
+	}
+
+	state, err := utils.GenerateTokenID()
+	if err != nil {
+		http.Error(w, "Failed to start authorization", http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := utils.GenerateTokenID()
+	if err != nil {
+		http.Error(w, "Failed to start authorization", http.StatusInternalServerError)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	err = s.stateStore.CreateState(&models.OIDCState{
+		StateHash:    utils.HashToken(state),
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		UserID:       userID,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		http.Error(w, "Failed to start authorization", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.setCookie(w, oidcStateCookie, state, "/v1/auth", time.Now().Add(oidcStateTTL), true)
+
+	authorizationURL := p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OIDCAuthorizeResponse{AuthorizationURL: authorizationURL})
+}
+
+// Authorize handles starting a sign in with a provider
+func (s *OIDCService) Authorize(w http.ResponseWriter, r *http.Request) {
+	s.authorize(w, r, nil)
+}
+
+// LinkIdentity handles starting to link a provider account to the current user
+func (s *OIDCService) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+	s.authorize(w, r, &userID)
+}
+
+// Callback handles the authorization response of a sign in relayed by the frontend.
+// It validates the ID token and signs in through the regular login path.
+func (s *OIDCService) Callback(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.resolveCallback(w, r, nil)
+	if !ok {
+		return
+	}
+
+	// A provider login replaces the password only; a second factor is still required
+	s.auth.completeLogin(w, r, user)
+}
+
+// LinkCallback handles the authorization response of a link relayed by the frontend.
+// Only the user who started the link can finish it.
+func (s *OIDCService) LinkCallback(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+	s.resolveCallback(w, r, &userID)
+}
+
+// resolveCallback validates an authorization response and returns the user to sign in.
+// With a userID it finishes a link for that user instead, writes the response and returns false, as for errors.
+// Either way the state must have been started for the same purpose, in the same browser.
+func (s *OIDCService) resolveCallback(w http.ResponseWriter, r *http.Request, userID *int) (*models.User, bool) {
+	name := r.PathValue("provider")
+
+	var req OIDCCallbackRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if req.Code == "" || req.State == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// A code relayed from another browser must not sign it in, or finish someone else's link
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(req.State)) != 1 {
+		http.Error(w, "Invalid or expired state", http.StatusBadRequest)
+		return nil, false
+	}
+	s.auth.setCookie(w, oidcStateCookie, "", "/v1/auth", time.Time{}, true)
+
+	state, err := s.stateStore.ConsumeState(utils.HashToken(req.State), name)
+	if err != nil {
+		http.Error(w, "Invalid or expired state", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Sign in states finish sign ins, and link states links of the user who started them
+	if (state.UserID == nil) != (userID == nil) || (userID != nil && *state.UserID != *userID) {
+		http.Error(w, "Invalid or expired state", http.StatusBadRequest)
+		return nil, false
+	}
+
+	ctx := s.context(r)
+
+	p, err := s.provider(ctx, name)
+	if err != nil {
+		http.Error(w, "Provider not available", http.StatusNotFound)
+		return nil, false
+	}
+
+	token, err := p.oauth2.Exchange(ctx, req.Code, oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		http.Error(w, "Failed to exchange authorization code", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Provider did not return an ID token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	// Checks signature, issuer, audience and expiry
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var claims oidcClaims
+	err = idToken.Claims(&claims)
+	if err != nil {
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	identity, err := s.identityStore.GetIdentity(name, idToken.Subject)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if userID != nil {
+		s.finishLink(w, r, *userID, identity, name, idToken.Subject, &claims)
+		return nil, false
+	}
+
+	var user *models.User
+	if identity != nil {
+		user, err = s.userStore.GetUserByID(identity.UserID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return nil, false
+		}
+		s.identityStore.TouchIdentity(identity.ID, claims.Email)
+	} else {
+		user, ok = s.linkByEmail(w, r, name, idToken.Subject, &claims)
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return user, true
+}
+
+// finishLink links a provider account to the user who started the flow
+func (s *OIDCService) finishLink(w http.ResponseWriter, r *http.Request, userID int, identity *models.UserIdentity, provider string, subject string, claims *oidcClaims) {
+	if identity != nil {
+		if identity.UserID != userID {
+			http.Error(w, "This account is already linked to another user", http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(identity)
+		return
+	}
+
+	identity, err := s.createIdentity(r, userID, provider, subject, claims.Email)
+	if err != nil {
+		http.Error(w, "Failed to link account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(identity)
+}
+
+// linkByEmail finds or creates the user a new provider account belongs to.
+// Accounts are only matched by an email both sides have verified; otherwise
+// whoever controls the provider account could take over the local one.
+func (s *OIDCService) linkByEmail(w http.ResponseWriter, r *http.Request, provider string, subject string, claims *oidcClaims) (*models.User, bool) {
+	if claims.Email == "" || !claims.EmailVerified {
+		http.Error(w, "Provider did not return a verified email", http.StatusForbidden)
+		return nil, false
+	}
+
+	user, err := s.userStore.GetUserByEmail(claims.Email)
+	switch {
+	case err == sql.ErrNoRows:
+		user = &models.User{
+			Email:    claims.Email,
+			Username: oidcUsername(claims),
+		}
+
+		err = s.userStore.CreateUser(user)
+		if err != nil {
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			return nil, false
+		}
+
+		// The provider already verified the address
+		err = s.userStore.MarkEmailVerified(user.ID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return nil, false
+		}
+		user.EmailVerified = true
+	case err != nil:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	case !user.EmailVerified:
+		http.Error(w, "An account with this email already exists; sign in and link the provider from your account", http.StatusConflict)
+		return nil, false
+	}
+
+	_, err = s.createIdentity(r, user.ID, provider, subject, claims.Email)
+	if err != nil {
+		http.Error(w, "Failed to link account", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// createIdentity stores a new identity and records an audit event for it
+func (s *OIDCService) createIdentity(r *http.Request, userID int, provider string, subject string, email string) (*models.UserIdentity, error) {
+	identity := &models.UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  subject,
+		Email:    email,
+	}
+
+	err := s.identityStore.CreateIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auth.recordAuditEvent(r, userID, models.AuditEventIdentityLinked, map[string]any{
+		"provider":    provider,
+		"identity_id": identity.ID,
+	})
+
+	return identity, nil
+}
+
+// oidcUsername picks a username for a user created from ID token claims
+func oidcUsername(claims *oidcClaims) string {
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Name
+	}
+	if username == "" {
+		username, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	// Cut on a character boundary
+	for len(username) > maxOIDCUsernameLength {
+		_, size := utf8.DecodeLastRuneInString(username)
+		username = username[:len(username)-size]
+	}
+	return username
+}
+
+// ListIdentities handles listing the provider accounts linked to the current user
+func (s *OIDCService) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	identities, err := s.identityStore.GetIdentitiesByUserID(userID)
+	if err != nil {
+		http.Error(w, "Failed to list identities", http.StatusInternalServerError)
+		return
+	}
+
+	if identities == nil {
+		identities = []*models.UserIdentity{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identities)
+}
+
+// UnlinkIdentity handles removing a provider account from the current user.
+// The last identity of a user without a password cannot be removed.
+func (s *OIDCService) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid identity ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	identities, err := s.identityStore.GetIdentitiesByUserID(userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if user.PasswordHash == "" && len(identities) <= 1 {
+		http.Error(w, "Set a password before removing your last linked account", http.StatusConflict)
+		return
+	}
+
+	deleted, err := s.identityStore.DeleteIdentity(id, userID)
+	if err != nil {
+		http.Error(w, "Failed to unlink identity", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, userID, models.AuditEventIdentityUnlinked, map[string]any{
+		"identity_id": id,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
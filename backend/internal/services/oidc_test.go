@@ -0,0 +1,599 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+)
+
+const (
+	stubProvider = "stub"
+	stubClientID = "website-builder"
+)
+
+// stubIssuer is an OpenID Connect provider on a local test server. It serves discovery,
+// its signing keys and a token endpoint that answers codes handed out with grant.
+type stubIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]stubGrant
+}
+
+// stubGrant is an authorization code waiting to be exchanged
+type stubGrant struct {
+	challenge string // PKCE code challenge from the authorization request
+	claims    map[string]any
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &stubIssuer{t: t, key: key, grants: make(map[string]stubGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("GET /jwks", issuer.jwks)
+	mux.HandleFunc("POST /token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func (i *stubIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                i.server.URL,
+		"authorization_endpoint":                i.server.URL + "/authorize",
+		"token_endpoint":                        i.server.URL + "/token",
+		"jwks_uri":                              i.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (i *stubIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "stub",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (i *stubIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	i.mu.Lock()
+	grant, ok := i.grants[r.PostForm.Get("code")]
+	delete(i.grants, r.PostForm.Get("code"))
+	i.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "stub-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     i.sign(grant.claims),
+	})
+}
+
+// sign issues an RS256 ID token with the given claims on top of valid defaults
+func (i *stubIssuer) sign(claims map[string]any) string {
+	payload := map[string]any{
+		"iss": i.server.URL,
+		"aud": stubClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		payload[name] = value
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "stub", "typ": "JWT"})
+	body, err := json.Marshal(payload)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// grant plays the user consenting at the provider: it answers the authorization URL
+// with a code for the given claims, the nonce of the request included, and returns the state
+func (i *stubIssuer) grant(authorizationURL string, code string, claims map[string]any) string {
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	query := parsed.Query()
+
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = query.Get("nonce")
+	}
+
+	i.mu.Lock()
+	i.grants[code] = stubGrant{challenge: query.Get("code_challenge"), claims: claims}
+	i.mu.Unlock()
+
+	return query.Get("state")
+}
+
+// fakeOIDCStates keeps authorization states in memory
+type fakeOIDCStates struct {
+	states map[string]*models.OIDCState
+}
+
+func (f *fakeOIDCStates) CreateState(state *models.OIDCState) error {
+	f.states[state.StateHash] = state
+	return nil
+}
+
+func (f *fakeOIDCStates) ConsumeState(stateHash string, provider string) (*models.OIDCState, error) {
+	state, ok := f.states[stateHash]
+	if !ok || state.Provider != provider || time.Now().After(state.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	delete(f.states, stateHash)
+	return state, nil
+}
+
+// fakeIdentities keeps linked identities in memory
+type fakeIdentities struct {
+	identities []*models.UserIdentity
+}
+
+func (f *fakeIdentities) CreateIdentity(identity *models.UserIdentity) error {
+	identity.ID = len(f.identities) + 1
+	f.identities = append(f.identities, identity)
+	return nil
+}
+
+func (f *fakeIdentities) GetIdentity(provider string, subject string) (*models.UserIdentity, error) {
+	for _, identity := range f.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeIdentities) GetIdentitiesByUserID(userID int) ([]*models.UserIdentity, error) {
+	var identities []*models.UserIdentity
+	for _, identity := range f.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (f *fakeIdentities) TouchIdentity(id int, email string) error {
+	return nil
+}
+
+func (f *fakeIdentities) DeleteIdentity(id int, userID int) (bool, error) {
+	return false, nil
+}
+
+// fakeUsers keeps users in memory; it implements only what the OIDC flow uses
+type fakeUsers struct {
+	models.UserRepository
+	users []*models.User
+}
+
+func (f *fakeUsers) CreateUser(user *models.User) error {
+	if len(user.Username) > maxOIDCUsernameLength {
+		return sql.ErrConnDone
+	}
+	user.ID = len(f.users) + 1
+	f.users = append(f.users, user)
+	return nil
+}
+
+func (f *fakeUsers) GetUserByID(id int) (*models.User, error) {
+	for _, user := range f.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeUsers) GetUserByEmail(email string) (*models.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeUsers) MarkEmailVerified(id int) error {
+	user, err := f.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+	user.EmailVerified = true
+	return nil
+}
+
+// fakeAuditEvents keeps audit events in memory
+type fakeAuditEvents struct {
+	events []*models.AuditEvent
+}
+
+func (f *fakeAuditEvents) CreateAuditEvent(event *models.AuditEvent) error {
+	f.events = append(f.events, event)
+	return nil
+}
+
+func (f *fakeAuditEvents) ListAuditEvents(filter models.AuditEventFilter) ([]*models.AuditEvent, error) {
+	return f.events, nil
+}
+
+// oidcTest wires an OIDCService to a stub issuer and in-memory stores
+type oidcTest struct {
+	t          *testing.T
+	issuer     *stubIssuer
+	service    *OIDCService
+	users      *fakeUsers
+	identities *fakeIdentities
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	issuer := newStubIssuer(t)
+	users := &fakeUsers{}
+	identities := &fakeIdentities{}
+
+	service := NewOIDCService([]OIDCProviderConfig{{
+		Name:         stubProvider,
+		Issuer:       issuer.server.URL,
+		ClientID:     stubClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:5173/auth/callback/stub",
+	}}, &fakeOIDCStates{states: make(map[string]*models.OIDCState)}, identities, users, &AuthService{eventStore: &fakeAuditEvents{}})
+
+	return &oidcTest{t: t, issuer: issuer, service: service, users: users, identities: identities}
+}
+
+// authorize starts a flow, as the given user when linking, and returns the
+// authorization URL and the state cookie set for the browser
+func (o *oidcTest) authorize(principal *rbac.Principal) (string, *http.Cookie) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/auth/oidc/stub/authorize", nil)
+	r.SetPathValue("provider", stubProvider)
+
+	w := httptest.NewRecorder()
+	if principal != nil {
+		o.service.LinkIdentity(w, r.WithContext(rbac.WithPrincipal(r.Context(), principal)))
+	} else {
+		o.service.Authorize(w, r)
+	}
+	if w.Code != http.StatusOK {
+		o.t.Fatalf("authorize: %d %s", w.Code, w.Body.String())
+	}
+
+	var response OIDCAuthorizeResponse
+	json.NewDecoder(w.Body).Decode(&response)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return response.AuthorizationURL, cookie
+		}
+	}
+	o.t.Fatal("authorize set no state cookie")
+	return "", nil
+}
+
+// relay posts an authorization response from a browser holding the given cookie, as the
+// given user to the link callback or else to the sign in callback. It returns the
+// user to sign in, if any, and the recorded response.
+func (o *oidcTest) relay(principal *rbac.Principal, code string, state string, cookie *http.Cookie) (*models.User, *httptest.ResponseRecorder) {
+	body, _ := json.Marshal(OIDCCallbackRequest{Code: code, State: state})
+	r := httptest.NewRequest(http.MethodPost, "/v1/auth/oidc/stub/callback", strings.NewReader(string(body)))
+	r.SetPathValue("provider", stubProvider)
+	if cookie != nil {
+		r.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	w := httptest.NewRecorder()
+	if principal != nil {
+		o.service.LinkCallback(w, r.WithContext(rbac.WithPrincipal(r.Context(), principal)))
+		return nil, w
+	}
+	user, _ := o.service.resolveCallback(w, r, nil)
+	return user, w
+}
+
+// callback runs a whole flow for the given claims in one browser
+func (o *oidcTest) callback(principal *rbac.Principal, claims map[string]any) (*models.User, *httptest.ResponseRecorder) {
+	authorizationURL, cookie := o.authorize(principal)
+	state := o.issuer.grant(authorizationURL, "code-"+o.t.Name(), claims)
+	return o.relay(principal, "code-"+o.t.Name(), state, cookie)
+}
+
+func TestOIDCCallbackCreatesUser(t *testing.T) {
+	o := newOIDCTest(t)
+
+	user, w := o.callback(nil, map[string]any{
+		"sub":                "subject-1",
+		"email":              "ada@example.com",
+		"email_verified":     true,
+		"preferred_username": "ada",
+	})
+	if user == nil {
+		t.Fatalf("callback: %d %s", w.Code, w.Body.String())
+	}
+	if user.Email != "ada@example.com" || user.Username != "ada" || !user.EmailVerified {
+		t.Fatalf("created user = %+v", user)
+	}
+
+	identity, err := o.identities.GetIdentity(stubProvider, "subject-1")
+	if err != nil || identity.UserID != user.ID {
+		t.Fatalf("identity = %+v, %v", identity, err)
+	}
+}
+
+func TestOIDCCallbackSignsInLinkedIdentity(t *testing.T) {
+	o := newOIDCTest(t)
+	existing := &models.User{Email: "ada@example.com", EmailVerified: true}
+	o.users.CreateUser(existing)
+	o.identities.CreateIdentity(&models.UserIdentity{UserID: existing.ID, Provider: stubProvider, Subject: "subject-1"})
+
+	// The provider address changed since linking; the subject still decides
+	user, w := o.callback(nil, map[string]any{
+		"sub":            "subject-1",
+		"email":          "ada@new.example.com",
+		"email_verified": true,
+	})
+	if user != existing {
+		t.Fatalf("callback: %d %s", w.Code, w.Body.String())
+	}
+	if len(o.users.users) != 1 {
+		t.Fatal("a second user was created")
+	}
+}
+
+func TestOIDCCallbackLinksVerifiedEmail(t *testing.T) {
+	o := newOIDCTest(t)
+	existing := &models.User{Email: "ada@example.com", EmailVerified: true, PasswordHash: "hash"}
+	o.users.CreateUser(existing)
+
+	user, w := o.callback(nil, map[string]any{
+		"sub":            "subject-1",
+		"email":          "Ada@example.com",
+		"email_verified": true,
+	})
+	if user != existing {
+		t.Fatalf("callback: %d %s", w.Code, w.Body.String())
+	}
+
+	identity, err := o.identities.GetIdentity(stubProvider, "subject-1")
+	if err != nil || identity.UserID != existing.ID {
+		t.Fatalf("identity = %+v, %v", identity, err)
+	}
+}
+
+func TestOIDCCallbackLinksToCurrentUser(t *testing.T) {
+	o := newOIDCTest(t)
+	current := &models.User{Email: "ada@example.com", EmailVerified: true}
+	o.users.CreateUser(current)
+
+	// The provider address does not have to match when the user links it themselves
+	user, w := o.callback(&rbac.Principal{UserID: current.ID}, map[string]any{
+		"sub":   "subject-1",
+		"email": "ada@elsewhere.example.com",
+	})
+	if user != nil || w.Code != http.StatusCreated {
+		t.Fatalf("link: %d %s", w.Code, w.Body.String())
+	}
+
+	identity, err := o.identities.GetIdentity(stubProvider, "subject-1")
+	if err != nil || identity.UserID != current.ID {
+		t.Fatalf("identity = %+v, %v", identity, err)
+	}
+}
+
+func TestOIDCCallbackRefusesIdentityLinkedToAnotherUser(t *testing.T) {
+	o := newOIDCTest(t)
+	owner := &models.User{Email: "ada@example.com", EmailVerified: true}
+	o.users.CreateUser(owner)
+	o.identities.CreateIdentity(&models.UserIdentity{UserID: owner.ID, Provider: stubProvider, Subject: "subject-1"})
+	other := &models.User{Email: "mallory@example.com", EmailVerified: true}
+	o.users.CreateUser(other)
+
+	_, w := o.callback(&rbac.Principal{UserID: other.ID}, map[string]any{"sub": "subject-1"})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("link: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCCallbackRejectsUnverifiedEmail(t *testing.T) {
+	o := newOIDCTest(t)
+
+	user, w := o.callback(nil, map[string]any{
+		"sub":            "subject-1",
+		"email":          "ada@example.com",
+		"email_verified": false,
+	})
+	if user != nil || w.Code != http.StatusForbidden {
+		t.Fatalf("callback: %d %s", w.Code, w.Body.String())
+	}
+	if len(o.users.users) != 0 || len(o.identities.identities) != 0 {
+		t.Fatal("an unverified email created an account")
+	}
+}
+
+func TestOIDCCallbackRefusesUnverifiedLocalAccount(t *testing.T) {
+	o := newOIDCTest(t)
+	o.users.CreateUser(&models.User{Email: "ada@example.com"})
+
+	// Whoever registered the address locally never proved they own it
+	user, w := o.callback(nil, map[string]any{
+		"sub":            "subject-1",
+		"email":          "ada@example.com",
+		"email_verified": true,
+	})
+	if user != nil || w.Code != http.StatusConflict {
+		t.Fatalf("callback: %d %s", w.Code, w.Body.String())
+	}
+	if len(o.identities.identities) != 0 {
+		t.Fatal("identity was linked to an unverified account")
+	}
+}
+
+func TestOIDCCallbackRejectsInvalidIDTokens(t *testing.T) {
+	tests := map[string]map[string]any{
+		"wrong nonce":    {"nonce": "replayed"},
+		"wrong audience": {"aud": "another-client"},
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+	}
+
+	for name, claims := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := newOIDCTest(t)
+
+			claims["sub"] = "subject-1"
+			claims["email"] = "ada@example.com"
+			claims["email_verified"] = true
+
+			user, w := o.callback(nil, claims)
+			if user != nil || w.Code != http.StatusUnauthorized {
+				t.Fatalf("callback: %d %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsReusedState(t *testing.T) {
+	o := newOIDCTest(t)
+
+	claims := map[string]any{"sub": "subject-1", "email": "ada@example.com", "email_verified": true}
+	authorizationURL, cookie := o.authorize(nil)
+	state := o.issuer.grant(authorizationURL, "code-1", claims)
+	o.issuer.grant(authorizationURL, "code-2", map[string]any{"sub": "subject-1"})
+
+	for i, code := range []string{"code-1", "code-2"} {
+		user, w := o.relay(nil, code, state, cookie)
+		if i == 0 && user == nil {
+			t.Fatalf("first callback: %d %s", w.Code, w.Body.String())
+		}
+		if i == 1 && (user != nil || w.Code != http.StatusBadRequest) {
+			t.Fatalf("second callback: %d %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestOIDCCallbackRequiresStartingBrowser(t *testing.T) {
+	o := newOIDCTest(t)
+
+	// A victim's browser is made to post a code obtained by the attacker
+	authorizationURL, _ := o.authorize(nil)
+	state := o.issuer.grant(authorizationURL, "code-1", map[string]any{
+		"sub":            "attacker",
+		"email":          "mallory@example.com",
+		"email_verified": true,
+	})
+
+	user, w := o.relay(nil, "code-1", state, nil)
+	if user != nil || w.Code != http.StatusBadRequest {
+		t.Fatalf("callback: %d %s", w.Code, w.Body.String())
+	}
+
+	_, otherCookie := o.authorize(nil)
+	user, w = o.relay(nil, "code-1", state, otherCookie)
+	if user != nil || w.Code != http.StatusBadRequest {
+		t.Fatalf("callback with another flow's cookie: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOIDCLinkCallbackRejectsOtherUser(t *testing.T) {
+	o := newOIDCTest(t)
+	attacker := &models.User{Email: "mallory@example.com", EmailVerified: true}
+	o.users.CreateUser(attacker)
+	victim := &models.User{Email: "ada@example.com", EmailVerified: true}
+	o.users.CreateUser(victim)
+
+	// The attacker starts a link on their own account and gets the victim to log in at the provider
+	authorizationURL, cookie := o.authorize(&rbac.Principal{UserID: attacker.ID})
+	state := o.issuer.grant(authorizationURL, "code-1", map[string]any{"sub": "victim"})
+
+	_, w := o.relay(&rbac.Principal{UserID: victim.ID}, "code-1", state, cookie)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("link by another user: %d %s", w.Code, w.Body.String())
+	}
+	if len(o.identities.identities) != 0 {
+		t.Fatal("the victim's identity was linked to the attacker")
+	}
+}
+
+func TestOIDCCallbackRejectsLinkState(t *testing.T) {
+	o := newOIDCTest(t)
+	current := &models.User{Email: "ada@example.com", EmailVerified: true}
+	o.users.CreateUser(current)
+
+	// A link state must not sign anybody in through the public callback
+	authorizationURL, cookie := o.authorize(&rbac.Principal{UserID: current.ID})
+	state := o.issuer.grant(authorizationURL, "code-1", map[string]any{"sub": "subject-1"})
+
+	user, w := o.relay(nil, "code-1", state, cookie)
+	if user != nil || w.Code != http.StatusBadRequest {
+		t.Fatalf("callback: %d %s", w.Code, w.Body.String())
+	}
+	if len(o.identities.identities) != 0 {
+		t.Fatal("a link state linked an identity through the sign in callback")
+	}
+}
+
+func TestOIDCUsernameFitsColumn(t *testing.T) {
+	long := strings.Repeat("é", 80) // 160 bytes
+	username := oidcUsername(&oidcClaims{PreferredUsername: long})
+	if len(username) > maxOIDCUsernameLength || !utf8.ValidString(username) {
+		t.Fatalf("username has %d bytes", len(username))
+	}
+	if username != strings.Repeat("é", 50) {
+		t.Fatalf("username = %q", username)
+	}
+
+	if got := oidcUsername(&oidcClaims{Email: "ada@example.com"}); got != "ada" {
+		t.Fatalf("username from email = %q", got)
+	}
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bercivarga/website-builder/internal/blocks"
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+// PageService handles the page trees of sites.
+// Its routes sit below RequireSitePermission, which loads the site.
+type PageService struct {
+	store     *models.PageStore
+	revisions *models.PageRevisionStore
+	registry  *blocks.Registry
+}
+
+// PageNode is a page with the pages below it
+type PageNode struct {
+	*models.Page
+	Children []*PageNode `json:"children"`
+}
+
+// CreatePageRequest describes a new page; the slug is derived from the title when omitted
+type CreatePageRequest struct {
+	ParentID *int   `json:"parent_id"`
+	Title    string `json:"title"`
+	Slug     string `json:"slug"`
+	IsHome   bool   `json:"is_home"`
+}
+
+// UpdatePageRequest carries the page fields to change; omitted fields stay as they are.
+// A page stops being the home page only when another one takes its place.
+type UpdatePageRequest struct {
+	Title  *string `json:"title"`
+	Slug   *string `json:"slug"`
+	IsHome *bool   `json:"is_home"`
+}
+
+// MovePageRequest names the new parent of a page, null for the top level,
+// and its zero-based position among its new siblings
+type MovePageRequest struct {
+	ParentID *int `json:"parent_id"`
+	Position int  `json:"position"`
+}
+
+// NewPageService creates a new PageService with the given stores, validating
+// page content against the block types of registry
+func NewPageService(store *models.PageStore, revisions *models.PageRevisionStore, registry *blocks.Registry) *PageService {
+	return &PageService{
+		store:     store,
+		revisions: revisions,
+		registry:  registry,
+	}
+}
+
+// ListPages handles retrieving the page tree of a site
+func (s *PageService) ListPages(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	pages, err := s.store.GetPageTree(site.ID)
+	if err != nil {
+		http.Error(w, "Failed to list pages", http.StatusInternalServerError)
+		return
+	}
+
+	// Parents come before their children, so each child finds its parent's node
+	roots := []*PageNode{}
+	nodes := make(map[int]*PageNode, len(pages))
+	for _, page := range pages {
+		node := &PageNode{Page: page, Children: []*PageNode{}}
+		nodes[page.ID] = node
+
+		if page.ParentID == nil {
+			roots = append(roots, node)
+		} else {
+			parent := nodes[*page.ParentID]
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roots)
+}
+
+// CreatePage handles adding a page to a site, as the last child of its parent
+func (s *PageService) CreatePage(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	var req CreatePageRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	page := &models.Page{
+		SiteID:   site.ID,
+		ParentID: req.ParentID,
+		Title:    strings.TrimSpace(req.Title),
+		Slug:     req.Slug,
+		IsHome:   req.IsHome,
+	}
+	if page.Slug == "" {
+		page.Slug = utils.Slugify(page.Title)
+	}
+
+	if msg := validatePage(page); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if page.ParentID != nil {
+		_, err = s.store.GetPageByID(site.ID, *page.ParentID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Parent page not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = s.store.CreatePage(page)
+	if err != nil {
+		if err == models.ErrSlugTaken {
+			http.Error(w, "A sibling page already uses this slug", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create page", http.StatusInternalServerError)
+		return
+	}
+
+	s.writePage(w, site.ID, page.ID, http.StatusCreated)
+}
+
+// GetPage handles retrieving a page of a site
+func (s *PageService) GetPage(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	page, ok := s.pageFromPath(w, r, site.ID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// UpdatePage handles renaming a page, changing its slug or making it the home page
+func (s *PageService) UpdatePage(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	var req UpdatePageRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	page, ok := s.pageFromPath(w, r, site.ID)
+	if !ok {
+		return
+	}
+
+	if req.Title != nil {
+		page.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Slug != nil {
+		page.Slug = *req.Slug
+	}
+	if req.IsHome != nil {
+		if !*req.IsHome && page.IsHome {
+			http.Error(w, "Make another page the home page instead", http.StatusConflict)
+			return
+		}
+		page.IsHome = *req.IsHome
+	}
+
+	if msg := validatePage(page); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.UpdatePage(page)
+	if err != nil {
+		if err == models.ErrSlugTaken {
+			http.Error(w, "A sibling page already uses this slug", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to update page", http.StatusInternalServerError)
+		return
+	}
+
+	s.writePage(w, site.ID, page.ID, http.StatusOK)
+}
+
+// MovePage handles moving a page to another parent or position.
+// The pages below it move along.
+func (s *PageService) MovePage(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	var req MovePageRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Position < 0 {
+		http.Error(w, "Invalid position", http.StatusBadRequest)
+		return
+	}
+
+	page, ok := s.pageFromPath(w, r, site.ID)
+	if !ok {
+		return
+	}
+
+	if page.IsHome && req.ParentID != nil {
+		http.Error(w, "The home page must stay at the top level", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.MovePage(site.ID, page.ID, req.ParentID, req.Position)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			http.Error(w, "Page not found", http.StatusNotFound)
+		case models.ErrPageCycle:
+			http.Error(w, "A page cannot be moved below itself", http.StatusBadRequest)
+		case models.ErrSlugTaken:
+			http.Error(w, "A page with this slug already exists there", http.StatusConflict)
+		default:
+			http.Error(w, "Failed to move page", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.writePage(w, site.ID, page.ID, http.StatusOK)
+}
+
+// DeletePage handles deleting a page along with all pages below it.
+// Pages on the live site have to be unpublished first, which records the change in the publish history.
+func (s *PageService) DeletePage(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	pageID, err := strconv.Atoi(r.PathValue("pageID"))
+	if err != nil {
+		http.Error(w, "Invalid page ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.store.DeletePage(site.ID, pageID)
+	if err == models.ErrPagePublished {
+		http.Error(w, "Unpublish the page and the pages below it before deleting it", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete page", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pageFromPath loads the page of the site named by the {pageID} path value
+func (s *PageService) pageFromPath(w http.ResponseWriter, r *http.Request, siteID int) (*models.Page, bool) {
+	pageID, err := strconv.Atoi(r.PathValue("pageID"))
+	if err != nil {
+		http.Error(w, "Invalid page ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	page, err := s.store.GetPageByID(siteID, pageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Page not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return page, true
+}
+
+// writePage reloads a page after a change, so the response carries its current path and position
+func (s *PageService) writePage(w http.ResponseWriter, siteID int, pageID int, status int) {
+	page, err := s.store.GetPageByID(siteID, pageID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(page)
+}
+
+// validatePage checks the editable fields of a page and returns a message
+// describing the first problem, or an empty string
+func validatePage(page *models.Page) string {
+	if page.Title == "" || len(page.Title) > 200 {
+		return "Title must be between 1 and 200 characters"
+	}
+	if !utils.ValidSlug(page.Slug) {
+		return "Slug must be lowercase letters, digits and hyphens, at most 63 characters"
+	}
+	if page.IsHome && page.ParentID != nil {
+		return "The home page must be a top-level page"
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bercivarga/website-builder/internal/blocks"
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+)
+
+const (
+	// Page documents larger than this are refused before they are parsed
+	maxPageContentSize = 1 << 20
+
+	maxRevisionMessageLength = 500
+)
+
+// SavePageContentRequest carries a new document for a page and an optional
+// message describing the change
+type SavePageContentRequest struct {
+	Message string          `json:"message"`
+	Content json.RawMessage `json:"content"`
+}
+
+// ContentErrorResponse lists why a page document was rejected
+type ContentErrorResponse struct {
+	Errors []blocks.ValidationError `json:"errors"`
+}
+
+// ListBlockTypes handles retrieving the block types page content may use, with the props they accept
+func (s *PageService) ListBlockTypes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"version": blocks.CurrentVersion,
+		"types":   s.registry.Types(),
+	})
+}
+
+// GetPageContent handles retrieving the block document of a page
+func (s *PageService) GetPageContent(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	pageID, err := strconv.Atoi(r.PathValue("pageID"))
+	if err != nil {
+		http.Error(w, "Invalid page ID", http.StatusBadRequest)
+		return
+	}
+
+	content, err := s.store.GetPageContent(site.ID, pageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Page not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// UpdatePageContent handles saving the block document of a page as a new revision.
+// Invalid documents are rejected with the JSON pointer of every problem found.
+func (s *PageService) UpdatePageContent(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	pageID, err := strconv.Atoi(r.PathValue("pageID"))
+	if err != nil {
+		http.Error(w, "Invalid page ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SavePageContentRequest
+
+	// Parse JSON body
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPageContentSize)).Decode(&req)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Page content is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Content == nil {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	s.saveContent(w, r, site.ID, pageID, req.Content, strings.TrimSpace(req.Message), nil)
+}
+
+// saveContent validates a document, stores it as the content of a page and writes
+// the resulting revision
+func (s *PageService) saveContent(w http.ResponseWriter, r *http.Request, siteID int, pageID int, body []byte, message string, restoredFrom *int) {
+	principal := rbac.FromContext(r.Context())
+
+	if len(message) > maxRevisionMessageLength {
+		http.Error(w, "Message must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	document, errs := s.registry.Parse(body)
+	if errs != nil {
+		for i := range errs {
+			errs[i].Pointer = "/content" + errs[i].Pointer
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ContentErrorResponse{Errors: errs})
+		return
+	}
+
+	// Store the normalized document rather than the request body
+	content, err := json.Marshal(document)
+	if err != nil {
+		http.Error(w, "Failed to encode page content", http.StatusInternalServerError)
+		return
+	}
+
+	// The admin impersonating a user is the real author
+	authorID := principal.ActorID()
+	revision := &models.PageRevision{
+		AuthorID:     &authorID,
+		Message:      message,
+		Content:      content,
+		RestoredFrom: restoredFrom,
+	}
+
+	err = s.store.UpdatePageContent(siteID, pageID, revision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Page not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to save page content", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(revision)
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bercivarga/website-builder/internal/blocks"
+	"github.com/bercivarga/website-builder/internal/models"
+)
+
+const (
+	defaultRevisionPageSize = 50
+	maxRevisionPageSize     = 200
+)
+
+// PageRevisionPage is one page of the revisions of a page, newest first.
+// NextCursor is passed as cursor to fetch the next page and is null on the last one.
+type PageRevisionPage struct {
+	Revisions  []*models.PageRevision `json:"revisions"`
+	NextCursor *int                   `json:"next_cursor"`
+}
+
+// RevisionDiffResponse lists the block changes from one revision to another
+type RevisionDiffResponse struct {
+	From    int             `json:"from"`
+	To      int             `json:"to"`
+	Changes []blocks.Change `json:"changes"`
+}
+
+// RestoreRevisionRequest carries an optional message for the revision a restore creates
+type RestoreRevisionRequest struct {
+	Message string `json:"message"`
+}
+
+// ListRevisions handles listing the revisions of a page, newest first
+func (s *PageService) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	page, ok := s.pageFromPath(w, r, site.ID)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultRevisionPageSize
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxRevisionPageSize)
+	}
+	before := 0
+	if value := query.Get("cursor"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		before = n
+	}
+
+	// Fetch one extra revision to know whether there is a next page
+	revisions, err := s.revisions.GetRevisionsByPageID(page.ID, before, limit+1)
+	if err != nil {
+		http.Error(w, "Failed to list revisions", http.StatusInternalServerError)
+		return
+	}
+
+	result := PageRevisionPage{Revisions: revisions}
+	if len(revisions) > limit {
+		result.Revisions = revisions[:limit]
+		result.NextCursor = &result.Revisions[limit-1].ID
+	}
+	if result.Revisions == nil {
+		result.Revisions = []*models.PageRevision{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetRevision handles retrieving a revision of a page with its content
+func (s *PageService) GetRevision(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	page, ok := s.pageFromPath(w, r, site.ID)
+	if !ok {
+		return
+	}
+
+	revision, ok := s.revisionFromPath(w, r, page.ID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revision)
+}
+
+// DiffRevisions handles comparing two revisions of a page block by block.
+// The revisions are given as from and to query parameters.
+func (s *PageService) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	page, ok := s.pageFromPath(w, r, site.ID)
+	if !ok {
+		return
+	}
+
+	fromID, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from", http.StatusBadRequest)
+		return
+	}
+	toID, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to", http.StatusBadRequest)
+		return
+	}
+
+	var documents [2]*blocks.Document
+	for i, id := range []int{fromID, toID} {
+		revision, err := s.revisions.GetRevision(page.ID, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Revision not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		documents[i] = &blocks.Document{}
+		err = json.Unmarshal(revision.Content, documents[i])
+		if err != nil {
+			http.Error(w, "Failed to read revision", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RevisionDiffResponse{
+		From:    fromID,
+		To:      toID,
+		Changes: blocks.Diff(documents[0], documents[1]),
+	})
+}
+
+// RestoreRevision handles bringing back the content of an earlier revision.
+// History is never rewritten: the restored content is saved as a new revision.
+func (s *PageService) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	var req RestoreRevisionRequest
+
+	// Parse JSON body; it is optional
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	page, ok := s.pageFromPath(w, r, site.ID)
+	if !ok {
+		return
+	}
+
+	revision, ok := s.revisionFromPath(w, r, page.ID)
+	if !ok {
+		return
+	}
+
+	message := strings.TrimSpace(req.Message)
+	if message == "" {
+		message = "Restored revision " + strconv.Itoa(revision.ID)
+	}
+
+	// The content is checked again, in case the block types changed since it was saved
+	s.saveContent(w, r, site.ID, page.ID, revision.Content, message, &revision.ID)
+}
+
+// revisionFromPath loads the revision of the page named by the {revisionID} path value
+func (s *PageService) revisionFromPath(w http.ResponseWriter, r *http.Request, pageID int) (*models.PageRevision, bool) {
+	revisionID, err := strconv.Atoi(r.PathValue("revisionID"))
+	if err != nil {
+		http.Error(w, "Invalid revision ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	revision, err := s.revisions.GetRevision(pageID, revisionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return revision, true
+}
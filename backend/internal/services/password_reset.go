@@ -0,0 +1,138 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+const passwordResetTTL = 30 * time.Minute
+
+// PasswordResetRequest carries a reset token and the new password
+type PasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// sendPasswordResetEmail replaces any pending reset token of the user and emails a new one
+func (s *AuthService) sendPasswordResetEmail(user *models.User) error {
+	token, tokenID, expiresAt, err := s.authUtils.GenerateActionToken(user.ID, user.Email, "password_reset", passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.DeleteTokensByType(user.ID, "password_reset")
+	if err != nil {
+		return err
+	}
+
+	err = s.store.CreateToken(&models.Token{
+		UserID:    user.ID,
+		Token:     utils.HashToken(tokenID),
+		TokenType: "password_reset",
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mail.SendPasswordResetEmail(user.Email, token)
+}
+
+// ForgotPassword handles requesting a password reset email.
+// It responds the same way whether or not the address belongs to an account.
+func (s *AuthService) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userStore.GetUserByEmail(req.Email)
+	if err == nil {
+		s.sendTokenEmailInBackground(user, "password_reset", s.sendPasswordResetEmail)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the address belongs to an account, a password reset email has been sent"))
+}
+
+// ResetPassword handles setting a new password with a reset token.
+// All sessions of the user are revoked so a compromised device is signed out.
+func (s *AuthService) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Password == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Password) > utils.MaxPasswordLength {
+		http.Error(w, "Password must be at most 72 bytes", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := s.authUtils.VerifyToken(req.Token)
+	if err != nil || claims.Type != "password_reset" {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// Consuming the token makes it single-use
+	token, err := s.store.ConsumeToken(utils.HashToken(claims.TokenID), "password_reset")
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(token.UserID)
+	if err != nil || user.ID != claims.UserID || user.Email != claims.Email {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := s.hasher.Hash(req.Password)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.userStore.UpdatePasswordHash(user.ID, passwordHash)
+	if err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	// Revoke every session; their tokens are removed with them
+	err = s.sessionStore.DeleteSessionsByUserID(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	// Proving control of the mailbox lifts a lockout
+	s.throttleStore.ClearThrottle(models.ThrottleKeyAccount, throttleAccountKey(user.Email))
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventPasswordReset, nil)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Password reset successfully"))
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+)
+
+const (
+	defaultPublishPageSize = 50
+	maxPublishPageSize     = 200
+)
+
+// PublishService handles putting the drafts of pages live.
+// Saving, renaming or moving a page only changes its draft; the live site shows each
+// page as it was last published. Its routes sit below RequireSitePermission, which loads the site.
+type PublishService struct {
+	store *models.SitePublishStore
+	auth  *AuthService
+}
+
+// PublishSiteRequest names the pages whose drafts to put live, all pages of the
+// site when empty, and an optional message for the publish history
+type PublishSiteRequest struct {
+	PageIDs []int  `json:"page_ids"`
+	Message string `json:"message"`
+}
+
+// PublishMessageRequest carries an optional message for the publish history
+type PublishMessageRequest struct {
+	Message string `json:"message"`
+}
+
+// SitePublishPage is one page of the publish history of a site, newest first.
+// NextCursor is passed as cursor to fetch the next page and is null on the last one.
+type SitePublishPage struct {
+	Publishes  []*models.SitePublish `json:"publishes"`
+	NextCursor *int                  `json:"next_cursor"`
+}
+
+// NewPublishService creates a new PublishService with the given SitePublishStore and AuthService
+func NewPublishService(store *models.SitePublishStore, auth *AuthService) *PublishService {
+	return &PublishService{
+		store: store,
+		auth:  auth,
+	}
+}
+
+// PublishSite handles putting the drafts of the selected pages live in one step.
+// Either every page is published or, if one of them is missing, none is.
+func (s *PublishService) PublishSite(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+	site := siteFromContext(r.Context())
+
+	var req PublishSiteRequest
+
+	// Parse JSON body; it is optional
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	publish, ok := newPublish(w, r, req.Message)
+	if !ok {
+		return
+	}
+
+	err = s.store.PublishPages(site.ID, req.PageIDs, publish)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Page not found", http.StatusNotFound)
+			return
+		}
+		if err == models.ErrNothingToPublish {
+			http.Error(w, "Site has no pages to publish", http.StatusConflict)
+			return
+		}
+		if err == models.ErrParentNotPublished {
+			http.Error(w, "Pages can only be published below pages that are published", http.StatusConflict)
+			return
+		}
+		if err == models.ErrSlugTaken {
+			http.Error(w, "Two published pages would share a path", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to publish site", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, principal.UserID, models.AuditEventSitePublished, map[string]any{
+		"site_id":    site.ID,
+		"publish_id": publish.ID,
+		"page_ids":   req.PageIDs,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(publish)
+}
+
+// ListPublishes handles listing the publish history of a site, newest first
+func (s *PublishService) ListPublishes(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	query := r.URL.Query()
+	limit := defaultPublishPageSize
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxPublishPageSize)
+	}
+	before := 0
+	if value := query.Get("cursor"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		before = n
+	}
+
+	// Fetch one extra publish to know whether there is a next page
+	publishes, err := s.store.GetPublishesBySiteID(site.ID, before, limit+1)
+	if err != nil {
+		http.Error(w, "Failed to list publishes", http.StatusInternalServerError)
+		return
+	}
+
+	result := SitePublishPage{Publishes: publishes}
+	if len(publishes) > limit {
+		result.Publishes = publishes[:limit]
+		result.NextCursor = &result.Publishes[limit-1].ID
+	}
+	if result.Publishes == nil {
+		result.Publishes = []*models.SitePublish{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetPublish handles retrieving a publish with the pages and revisions that were live after it
+func (s *PublishService) GetPublish(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	publishID, err := strconv.Atoi(r.PathValue("publishID"))
+	if err != nil {
+		http.Error(w, "Invalid publish ID", http.StatusBadRequest)
+		return
+	}
+
+	publish, err := s.store.GetPublish(site.ID, publishID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Publish not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(publish)
+}
+
+// RollbackPublish handles returning the live site to what it showed after an earlier publish.
+// Drafts are left alone, and the rollback is added to the history as a publish of its own.
+func (s *PublishService) RollbackPublish(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+	site := siteFromContext(r.Context())
+
+	publishID, err := strconv.Atoi(r.PathValue("publishID"))
+	if err != nil {
+		http.Error(w, "Invalid publish ID", http.StatusBadRequest)
+		return
+	}
+
+	var req PublishMessageRequest
+
+	// Parse JSON body; it is optional
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	message := strings.TrimSpace(req.Message)
+	if message == "" {
+		message = "Rolled back to publish " + strconv.Itoa(publishID)
+	}
+
+	publish, ok := newPublish(w, r, message)
+	if !ok {
+		return
+	}
+
+	err = s.store.RollbackToPublish(site.ID, publishID, publish)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Publish not found", http.StatusNotFound)
+			return
+		}
+		if err == models.ErrParentNotPublished {
+			http.Error(w, "Publish has pages below pages that were not published", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to roll back site", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, principal.UserID, models.AuditEventSiteRolledBack, map[string]any{
+		"site_id":     site.ID,
+		"publish_id":  publish.ID,
+		"rollback_of": publishID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(publish)
+}
+
+// UnpublishPage handles taking a page off the live site along with the live pages below it; their drafts stay
+func (s *PublishService) UnpublishPage(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+	site := siteFromContext(r.Context())
+
+	pageID, err := strconv.Atoi(r.PathValue("pageID"))
+	if err != nil {
+		http.Error(w, "Invalid page ID", http.StatusBadRequest)
+		return
+	}
+
+	var req PublishMessageRequest
+
+	// Parse JSON body; it is optional
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	publish, ok := newPublish(w, r, req.Message)
+	if !ok {
+		return
+	}
+
+	err = s.store.UnpublishPage(site.ID, pageID, publish)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Published page not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to unpublish page", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, principal.UserID, models.AuditEventPageUnpublished, map[string]any{
+		"site_id":    site.ID,
+		"page_id":    pageID,
+		"publish_id": publish.ID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(publish)
+}
+
+// GetPublishedContent handles retrieving a page as the live site shows it: its live
+// title and path, which renaming or moving the draft doesn't change, and its revision
+func (s *PublishService) GetPublishedContent(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	pageID, err := strconv.Atoi(r.PathValue("pageID"))
+	if err != nil {
+		http.Error(w, "Invalid page ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.store.GetPublishedPage(site.ID, pageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Published page not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// newPublish checks the message of a publish and starts its history entry
+func newPublish(w http.ResponseWriter, r *http.Request, message string) (*models.SitePublish, bool) {
+	principal := rbac.FromContext(r.Context())
+
+	message = strings.TrimSpace(message)
+	if len(message) > maxRevisionMessageLength {
+		http.Error(w, "Message must be at most 500 characters", http.StatusBadRequest)
+		return nil, false
+	}
+
+	publisherID := principal.ActorID()
+	return &models.SitePublish{
+		PublisherID: &publisherID,
+		Message:     message,
+	}, true
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+)
+
+// RoleRequest carries the role to give a user
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// SetUserRole handles changing the role of a user.
+// Only roles below the caller's own can be handed out or taken away, except by owners,
+// and the last owner cannot step down.
+func (s *AuthService) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req RoleRequest
+
+	// Parse JSON body
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	role := rbac.Role(req.Role)
+	if !role.Valid() {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	current := rbac.Role(user.Role)
+	if !principal.Role.CanManage(current) || !principal.Role.CanManage(role) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if current == rbac.RoleOwner && role != rbac.RoleOwner {
+		owners, err := s.userStore.CountUsersByRole(string(rbac.RoleOwner))
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if owners <= 1 {
+			http.Error(w, "The last owner cannot be demoted", http.StatusConflict)
+			return
+		}
+	}
+
+	err = s.userStore.UpdateUserRole(user.ID, string(role))
+	if err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordAuditEvent(r, user.ID, models.AuditEventRoleChanged, map[string]any{
+		"actor_id": principal.UserID,
+		"from":     current,
+		"to":       role,
+	})
+
+	user.Role = string(role)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+)
+
+// SessionService is a struct that holds the session store
+type SessionService struct {
+	store *models.SessionStore
+	auth  *AuthService
+}
+
+// SessionResponse describes a session as shown on the account settings page
+type SessionResponse struct {
+	*models.Session
+	Current bool `json:"current"`
+}
+
+// NewSessionService creates a new SessionService with the given SessionStore and AuthService
+func NewSessionService(store *models.SessionStore, auth *AuthService) *SessionService {
+	return &SessionService{
+		store: store,
+		auth:  auth,
+	}
+}
+
+// ListSessions handles listing the active sessions of the current user
+func (s *SessionService) ListSessions(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	sessions, err := s.store.GetSessionsByUserID(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			Session: session,
+			Current: session.ID == principal.SessionID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeSession handles signing out a single session of the current user
+func (s *SessionService) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the session exists and belongs to the current user
+	session, err := s.store.GetSessionByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if session.UserID != principal.UserID {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	err = s.store.DeleteSession(session.ID, principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, principal.UserID, models.AuditEventSessionRevoked, map[string]any{
+		"session_id": session.ID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions handles signing out every session of the current user except the current one
+func (s *SessionService) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	err := s.store.DeleteOtherSessions(principal.UserID, principal.SessionID)
+	if err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, principal.UserID, models.AuditEventSessionRevoked, map[string]any{
+		"except_session_id": principal.SessionID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+// SiteService handles the sites of workspaces
+type SiteService struct {
+	store      *models.SiteStore
+	workspaces *models.WorkspaceStore
+}
+
+// CreateSiteRequest describes a new site; the slug is derived from the name when omitted
+type CreateSiteRequest struct {
+	WorkspaceID   int             `json:"workspace_id"`
+	Name          string          `json:"name"`
+	Slug          string          `json:"slug"`
+	DefaultLocale string          `json:"default_locale"`
+	Settings      json.RawMessage `json:"settings"`
+}
+
+// UpdateSiteRequest carries the site fields to change; omitted fields stay as they are
+type UpdateSiteRequest struct {
+	Name          *string         `json:"name"`
+	Slug          *string         `json:"slug"`
+	DefaultLocale *string         `json:"default_locale"`
+	Settings      json.RawMessage `json:"settings"` // Replaces the settings as a whole
+}
+
+type siteKey struct{}
+
+// NewSiteService creates a new SiteService with the given SiteStore and WorkspaceStore
+func NewSiteService(store *models.SiteStore, workspaces *models.WorkspaceStore) *SiteService {
+	return &SiteService{
+		store:      store,
+		workspaces: workspaces,
+	}
+}
+
+// RequireSitePermission returns a middleware for routes under /{siteID}.
+// It only lets members of the site's workspace whose role grants the permission
+// through and adds the site and their membership to the request context.
+// Sites outside the caller's workspaces get a 404.
+func (s *SiteService) RequireSitePermission(permission rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := rbac.FromContext(r.Context())
+
+			siteID, err := strconv.Atoi(r.PathValue("siteID"))
+			if err != nil {
+				http.Error(w, "Invalid site ID", http.StatusBadRequest)
+				return
+			}
+
+			site, err := s.store.GetSiteByID(siteID)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					http.Error(w, "Site not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+
+			member, err := s.workspaces.GetMember(site.WorkspaceID, principal.UserID)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					http.Error(w, "Site not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+
+			membership := &rbac.Membership{
+				WorkspaceID: site.WorkspaceID,
+				Role:        rbac.Role(member.Role),
+			}
+
+			if !principal.CanIn(membership, permission) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			ctx := rbac.WithMembership(r.Context(), membership)
+			ctx = context.WithValue(ctx, siteKey{}, site)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// siteFromContext returns the site loaded by RequireSitePermission
+func siteFromContext(ctx context.Context) *models.Site {
+	site, _ := ctx.Value(siteKey{}).(*models.Site)
+	return site
+}
+
+// ListSites handles listing the sites of the current user's workspaces,
+// or of a single workspace when workspace_id is given
+func (s *SiteService) ListSites(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	var sites []*models.Site
+	var err error
+	if value := r.URL.Query().Get("workspace_id"); value != "" {
+		workspaceID, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			http.Error(w, "Invalid workspace_id", http.StatusBadRequest)
+			return
+		}
+
+		_, err = s.workspaces.GetMember(workspaceID, principal.UserID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Workspace not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		sites, err = s.store.GetSitesByWorkspaceID(workspaceID)
+	} else {
+		sites, err = s.store.GetSitesByUserID(principal.UserID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to list sites", http.StatusInternalServerError)
+		return
+	}
+
+	if sites == nil {
+		sites = []*models.Site{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sites)
+}
+
+// CreateSite handles creating a site in a workspace the current user may edit sites in
+func (s *SiteService) CreateSite(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	var req CreateSiteRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.WorkspaceID == 0 || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	member, err := s.workspaces.GetMember(req.WorkspaceID, principal.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Workspace not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	membership := &rbac.Membership{WorkspaceID: req.WorkspaceID, Role: rbac.Role(member.Role)}
+	if !principal.CanIn(membership, rbac.PermissionSitesWrite) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	site := &models.Site{
+		WorkspaceID:   req.WorkspaceID,
+		OwnerID:       &principal.UserID,
+		Name:          strings.TrimSpace(req.Name),
+		Slug:          req.Slug,
+		DefaultLocale: req.DefaultLocale,
+		Settings:      req.Settings,
+	}
+	if site.Slug == "" {
+		site.Slug = utils.Slugify(site.Name)
+	}
+	if site.DefaultLocale == "" {
+		site.DefaultLocale = "en"
+	}
+
+	if msg := validateSite(site); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.CreateSite(site)
+	if err != nil {
+		if err == models.ErrSlugTaken {
+			http.Error(w, "Slug is already taken", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create site", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(site)
+}
+
+// GetSite handles retrieving a site
+func (s *SiteService) GetSite(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(site)
+}
+
+// UpdateSite handles changing the name, slug, default locale or settings of a site
+func (s *SiteService) UpdateSite(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	var req UpdateSiteRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != nil {
+		site.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Slug != nil {
+		site.Slug = *req.Slug
+	}
+	if req.DefaultLocale != nil {
+		site.DefaultLocale = *req.DefaultLocale
+	}
+	if req.Settings != nil {
+		site.Settings = req.Settings
+	}
+
+	if msg := validateSite(site); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.UpdateSite(site)
+	if err != nil {
+		if err == models.ErrSlugTaken {
+			http.Error(w, "Slug is already taken", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to update site", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(site)
+}
+
+// DeleteSite handles deleting a site along with everything on it
+func (s *SiteService) DeleteSite(w http.ResponseWriter, r *http.Request) {
+	site := siteFromContext(r.Context())
+
+	deleted, err := s.store.DeleteSite(site.ID)
+	if err != nil {
+		http.Error(w, "Failed to delete site", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateSite checks the editable fields of a site and returns a message
+// describing the first problem, or an empty string
+func validateSite(site *models.Site) string {
+	if site.Name == "" || len(site.Name) > 100 {
+		return "Name must be between 1 and 100 characters"
+	}
+	if !utils.ValidSlug(site.Slug) {
+		return "Slug must be lowercase letters, digits and hyphens, at most 63 characters"
+	}
+	if !localePattern.MatchString(site.DefaultLocale) || len(site.DefaultLocale) > 35 {
+		return "Invalid default locale"
+	}
+	if site.Settings != nil {
+		var settings map[string]any
+		if json.Unmarshal(site.Settings, &settings) != nil || settings == nil {
+			return "Settings must be a JSON object"
+		}
+	}
+	return ""
+}
@@ -2,11 +2,15 @@ package services
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
 )
 
 // UserService is a struct that holds the user store
@@ -21,53 +25,146 @@ func NewUserService(store *models.UserStore) *UserService {
 	}
 }
 
-// CreateUser handles the user registration process
-func (s *UserService) CreateUser(w http.ResponseWriter, r *http.Request) {
-	username := r.FormValue("username")
-	email := r.FormValue("email")
-	password := r.FormValue("password")
-	if username == "" || email == "" || password == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
+// MeResponse describes the current user as shown on the account settings page
+type MeResponse struct {
+	Username      string `json:"username"`
+	DisplayName   string `json:"display_name"`
+	AvatarURL     string `json:"avatar_url"`
+	Locale        string `json:"locale"`
+	Timezone      string `json:"timezone"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Role          string `json:"role"`
+	Impersonated  bool   `json:"impersonated"`
+	Impersonator  *int   `json:"impersonator_id,omitempty"` // Admin acting as the user
+
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+// UpdateProfileRequest carries the profile fields to change; omitted fields stay as they are
+type UpdateProfileRequest struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Locale      *string `json:"locale"`
+	Timezone    *string `json:"timezone"`
+}
+
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// GetMe handles the retrieval of the current user
+func (s *UserService) GetMe(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	user, err := s.store.GetUserByID(principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
-	user := &models.User{
-		Username: username,
-		Email:    email,
+	writeMe(w, user, principal)
+}
+
+// UpdateMe handles the current user editing their profile
+func (s *UserService) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	var req UpdateProfileRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	err := s.store.CreateUser(user)
+	user, err := s.store.GetUserByID(principal.UserID)
 	if err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("User created successfully"))
-}
 
-// GetMe handles the retrieval of the current user
-func (s *UserService) GetMe(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("GetMe called")
-	userID := r.Context().Value("userID").(int)
-	fmt.Println("User ID from context:", userID)
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if username == "" || len(username) > 100 {
+			http.Error(w, "Username must be between 1 and 100 characters", http.StatusBadRequest)
+			return
+		}
+		user.Username = username
+	}
+
+	if req.DisplayName != nil {
+		displayName := strings.TrimSpace(*req.DisplayName)
+		if len(displayName) > 100 {
+			http.Error(w, "Display name must be at most 100 characters", http.StatusBadRequest)
+			return
+		}
+		user.DisplayName = displayName
+	}
 
-	user, err := s.store.GetUserByID(userID)
+	if req.AvatarURL != nil {
+		avatarURL := strings.TrimSpace(*req.AvatarURL)
+		if avatarURL != "" && !validAvatarURL(avatarURL) {
+			http.Error(w, "Avatar must be an http or https URL", http.StatusBadRequest)
+			return
+		}
+		user.AvatarURL = avatarURL
+	}
+
+	if req.Locale != nil {
+		if !localePattern.MatchString(*req.Locale) || len(*req.Locale) > 35 {
+			http.Error(w, "Invalid locale", http.StatusBadRequest)
+			return
+		}
+		user.Locale = *req.Locale
+	}
+
+	if req.Timezone != nil {
+		// LoadLocation accepts "" and "Local", which mean the server's zone
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+			http.Error(w, "Invalid timezone", http.StatusBadRequest)
+			return
+		}
+		user.Timezone = *req.Timezone
+	}
+
+	err = s.store.UpdateProfile(user)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
 		return
 	}
 
-	// Set Content-Type and encode JSON in one step
+	writeMe(w, user, principal)
+}
+
+// writeMe writes the current user as JSON
+func writeMe(w http.ResponseWriter, user *models.User, principal *rbac.Principal) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-	}{
-		Username: user.Username,
-		Email:    user.Email,
+	json.NewEncoder(w).Encode(MeResponse{
+		Username:      user.Username,
+		DisplayName:   user.DisplayName,
+		AvatarURL:     user.AvatarURL,
+		Locale:        user.Locale,
+		Timezone:      user.Timezone,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+		Impersonated:  principal.IsImpersonated(),
+		Impersonator:  impersonatorID(principal),
+
+		DeletionScheduledAt: user.DeletionScheduledAt,
 	})
 }
 
+// validAvatarURL reports whether an avatar URL is an absolute http or https URL
+func validAvatarURL(raw string) bool {
+	if len(raw) > 2048 {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // GetUser handles the retrieval of a user by ID
 func (s *UserService) GetUser(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
@@ -88,8 +185,16 @@ func (s *UserService) GetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
 	// write user data as JSON
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(user)
 }
+
+// impersonatorID returns the ID of the admin impersonating the principal, or nil
+func impersonatorID(principal *rbac.Principal) *int {
+	if !principal.IsImpersonated() {
+		return nil
+	}
+	return &principal.ImpersonatorID
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+const emailVerificationTTL = 48 * time.Hour
+
+// TokenRequest carries a single-use token sent to the user by email
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailRequest carries the email address an action is requested for
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// sendVerificationEmail replaces any pending verification token of the user and emails a new one
+func (s *AuthService) sendVerificationEmail(user *models.User) error {
+	token, tokenID, expiresAt, err := s.authUtils.GenerateActionToken(user.ID, user.Email, "email_verification", emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.DeleteTokensByType(user.ID, "email_verification")
+	if err != nil {
+		return err
+	}
+
+	err = s.store.CreateToken(&models.Token{
+		UserID:    user.ID,
+		Token:     utils.HashToken(tokenID),
+		TokenType: "email_verification",
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mail.SendVerificationEmail(user.Email, token)
+}
+
+// VerifyEmail handles confirming an email address with a verification token
+func (s *AuthService) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := s.authUtils.VerifyToken(req.Token)
+	if err != nil || claims.Type != "email_verification" {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// Consuming the token makes it single-use
+	token, err := s.store.ConsumeToken(utils.HashToken(claims.TokenID), "email_verification")
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(token.UserID)
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// The link only proves ownership of the address it was sent to
+	if user.ID != claims.UserID || user.Email != claims.Email {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	err = s.userStore.MarkEmailVerified(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Email verified successfully"))
+}
+
+// ResendVerificationEmail handles sending a new verification email.
+// It responds the same way whether or not the address belongs to an account.
+func (s *AuthService) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userStore.GetUserByEmail(req.Email)
+	if err == nil && !user.EmailVerified {
+		s.sendTokenEmailInBackground(user, "email_verification", s.sendVerificationEmail)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the address belongs to an unverified account, a verification email has been sent"))
+}
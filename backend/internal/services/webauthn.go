@@ -0,0 +1,410 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const webAuthnCeremonyTTL = 5 * time.Minute
+
+// WebAuthnService handles passkey and security key registration and login
+type WebAuthnService struct {
+	webAuthn        *webauthn.WebAuthn
+	credentialStore models.WebAuthnCredentialRepository
+	ceremonyStore   models.WebAuthnCeremonyRepository
+	userStore       models.UserRepository
+	auth            *AuthService
+}
+
+// WebAuthnBeginResponse carries the options for navigator.credentials.create() or .get()
+type WebAuthnBeginResponse struct {
+	CeremonyToken string `json:"ceremony_token"`
+	Options       any    `json:"options"`
+}
+
+// WebAuthnFinishRequest carries the browser's answer to a ceremony started with a begin endpoint
+type WebAuthnFinishRequest struct {
+	CeremonyToken string          `json:"ceremony_token"`
+	Name          string          `json:"name"` // Label of a newly registered credential
+	Credential    json.RawMessage `json:"credential"`
+}
+
+// NewWebAuthnService creates a new WebAuthnService with the given relying party, stores and auth service
+func NewWebAuthnService(webAuthn *webauthn.WebAuthn, credentialStore models.WebAuthnCredentialRepository, ceremonyStore models.WebAuthnCeremonyRepository, userStore models.UserRepository, auth *AuthService) *WebAuthnService {
+	return &WebAuthnService{
+		webAuthn:        webAuthn,
+		credentialStore: credentialStore,
+		ceremonyStore:   ceremonyStore,
+		userStore:       userStore,
+		auth:            auth,
+	}
+}
+
+// webAuthnUser adapts a user and its stored credentials to webauthn.User
+type webAuthnUser struct {
+	user        *models.User
+	credentials []*models.WebAuthnCredential
+}
+
+// webAuthnUserHandle derives the opaque WebAuthn user handle from a user ID
+func webAuthnUserHandle(userID int) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// userIDFromHandle recovers the user ID from a WebAuthn user handle
+func userIDFromHandle(handle []byte) (int, error) {
+	if len(handle) != 8 {
+		return 0, errors.New("invalid user handle")
+	}
+	return int(binary.BigEndian.Uint64(handle)), nil
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return webAuthnUserHandle(u.user.ID)
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	if u.user.Username != "" {
+		return u.user.Username
+	}
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+		for i, t := range c.Transports {
+			transports[i] = protocol.AuthenticatorTransport(t)
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+// storedCredential returns the stored credential with the given credential ID
+func (u *webAuthnUser) storedCredential(credentialID []byte) *models.WebAuthnCredential {
+	for _, c := range u.credentials {
+		if bytes.Equal(c.CredentialID, credentialID) {
+			return c
+		}
+	}
+	return nil
+}
+
+// loadUser loads a user together with its registered credentials
+func (s *WebAuthnService) loadUser(userID int) (*webAuthnUser, error) {
+	user, err := s.userStore.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := s.credentialStore.GetCredentialsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+// beginCeremony stores the session data of a ceremony and answers with its options
+func (s *WebAuthnService) beginCeremony(w http.ResponseWriter, ceremonyType string, userID *int, session *webauthn.SessionData, options any) {
+	token, err := utils.GenerateTokenID()
+	if err != nil {
+		http.Error(w, "Failed to start ceremony", http.StatusInternalServerError)
+		return
+	}
+
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		http.Error(w, "Failed to start ceremony", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.ceremonyStore.CreateCeremony(&models.WebAuthnCeremony{
+		TokenHash:    utils.HashToken(token),
+		CeremonyType: ceremonyType,
+		UserID:       userID,
+		SessionData:  sessionData,
+		ExpiresAt:    time.Now().Add(webAuthnCeremonyTTL),
+	})
+	if err != nil {
+		http.Error(w, "Failed to start ceremony", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebAuthnBeginResponse{
+		CeremonyToken: token,
+		Options:       options,
+	})
+}
+
+// finishCeremony consumes the ceremony a finish request refers to and decodes its session data
+func (s *WebAuthnService) finishCeremony(req *WebAuthnFinishRequest, ceremonyType string) (*models.WebAuthnCeremony, *webauthn.SessionData, error) {
+	ceremony, err := s.ceremonyStore.ConsumeCeremony(utils.HashToken(req.CeremonyToken), ceremonyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var session webauthn.SessionData
+	err = json.Unmarshal(ceremony.SessionData, &session)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ceremony, &session, nil
+}
+
+// BeginRegistration handles starting the registration of a new credential for the current user
+func (s *WebAuthnService) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	user, err := s.loadUser(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Don't register the same authenticator twice
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	// Logins are always discoverable, so the authenticator has to keep the credential.
+	// It must also verify the user, since a passkey login skips the second factor.
+	options, session, err := s.webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{UserVerification: protocol.VerificationRequired}),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		http.Error(w, "Failed to start registration", http.StatusInternalServerError)
+		return
+	}
+
+	s.beginCeremony(w, "registration", &userID, session, options)
+}
+
+// FinishRegistration handles verifying and storing a newly created credential
+func (s *WebAuthnService) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	var req WebAuthnFinishRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ceremony, session, err := s.finishCeremony(&req, "registration")
+	if err != nil || ceremony.UserID == nil || *ceremony.UserID != userID {
+		http.Error(w, "Invalid or expired ceremony", http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		http.Error(w, "Invalid credential", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.loadUser(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	credential, err := s.webAuthn.CreateCredential(user, *session, parsed)
+	if err != nil {
+		http.Error(w, "Credential verification failed", http.StatusBadRequest)
+		return
+	}
+
+	stored := newStoredCredential(userID, req.Name, credential)
+	err = s.credentialStore.CreateCredential(stored)
+	if err != nil {
+		http.Error(w, "Failed to store credential", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, userID, models.AuditEventWebAuthnRegistered, map[string]any{
+		"credential_id": stored.ID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(stored)
+}
+
+// newStoredCredential converts a verified credential into the form it is stored in
+func newStoredCredential(userID int, name string, credential *webauthn.Credential) *models.WebAuthnCredential {
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+
+	return &models.WebAuthnCredential{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+}
+
+// ListCredentials handles listing the credentials of the current user
+func (s *WebAuthnService) ListCredentials(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	credentials, err := s.credentialStore.GetCredentialsByUserID(userID)
+	if err != nil {
+		http.Error(w, "Failed to list credentials", http.StatusInternalServerError)
+		return
+	}
+
+	if credentials == nil {
+		credentials = []*models.WebAuthnCredential{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+// DeleteCredential handles removing a credential of the current user
+func (s *WebAuthnService) DeleteCredential(w http.ResponseWriter, r *http.Request) {
+	userID := rbac.FromContext(r.Context()).UserID
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid credential ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.credentialStore.DeleteCredential(id, userID)
+	if err != nil {
+		http.Error(w, "Failed to delete credential", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Credential not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BeginLogin handles starting a passkey login.
+// Logins are always discoverable: the options name no account and list no credentials,
+// so the response is the same for every caller and never reveals whether an account exists.
+// The authenticator must verify the user with a PIN or biometric, which makes the passkey two factors.
+func (s *WebAuthnService) BeginLogin(w http.ResponseWriter, r *http.Request) {
+	options, session, err := s.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	s.beginCeremony(w, "login", nil, session, options)
+}
+
+// FinishLogin handles verifying a passkey assertion and signing in through the regular login path
+func (s *WebAuthnService) FinishLogin(w http.ResponseWriter, r *http.Request) {
+	var req WebAuthnFinishRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	_, session, err := s.finishCeremony(&req, "login")
+	if err != nil {
+		http.Error(w, "Invalid or expired ceremony", http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		http.Error(w, "Invalid credential", http.StatusBadRequest)
+		return
+	}
+
+	var user *webAuthnUser
+	credential, err := s.webAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := userIDFromHandle(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		user, err = s.loadUser(userID)
+		return user, err
+	}, *session, parsed)
+	if err != nil || user == nil {
+		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+		return
+	}
+
+	stored := user.storedCredential(credential.ID)
+	if stored == nil || !credential.Flags.UserVerified {
+		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+		return
+	}
+
+	// A counter going backwards means the private key may have been copied
+	if credential.Authenticator.CloneWarning {
+		s.auth.recordAuditEvent(r, user.user.ID, models.AuditEventWebAuthnCloneWarning, map[string]any{
+			"credential_id": stored.ID,
+		})
+		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+		return
+	}
+
+	err = s.credentialStore.UpdateCredentialUsage(stored.ID, credential.Authenticator.SignCount, credential.Flags.BackupState)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// A verified passkey is something the user has and knows or is, so no MFA challenge follows
+	s.auth.signIn(w, r, user.user)
+}
@@ -0,0 +1,553 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:5173"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// softwareAuthenticator is a passkey authenticator in memory. It keeps one
+// discoverable P-256 credential and answers ceremonies like a browser would.
+type softwareAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	verifiesUser bool // Whether it asks for a PIN or biometric, as a plain security key doesn't
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+
+	return &softwareAuthenticator{t: t, key: key, credentialID: credentialID, verifiesUser: true}
+}
+
+// flags returns the authenticator data flags of a ceremony
+func (a *softwareAuthenticator) flags(extra byte) byte {
+	flags := flagUserPresent | extra
+	if a.verifiesUser {
+		flags |= flagUserVerified
+	}
+	return flags
+}
+
+// authenticatorData builds the authenticator data for the test relying party
+func (a *softwareAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// clientData builds the client data JSON the browser would send
+func (a *softwareAuthenticator) clientData(ceremonyType string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create() with a "none" attestation
+func (a *softwareAuthenticator) create(options *ceremonyOptions) []byte {
+	a.userHandle = options.PublicKey.User.ID
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // Zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	authData := a.authenticatorData(a.flags(flagAttestedData), attested)
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.encode(map[string]any{
+		"clientDataJSON":    a.clientData("webauthn.create", options.PublicKey.Challenge),
+		"attestationObject": attestation,
+	})
+}
+
+// get answers navigator.credentials.get() by signing the challenge
+func (a *softwareAuthenticator) get(options *ceremonyOptions) []byte {
+	a.signCount++
+
+	clientData := a.clientData("webauthn.get", options.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	authData := a.authenticatorData(a.flags(0), nil)
+
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.encode(map[string]any{
+		"clientDataJSON":    clientData,
+		"authenticatorData": authData,
+		"signature":         signature,
+		"userHandle":        a.userHandle,
+	})
+}
+
+// encode wraps an authenticator response in a PublicKeyCredential as JSON
+func (a *softwareAuthenticator) encode(response map[string]any) []byte {
+	encoded := make(map[string]string, len(response))
+	for name, value := range response {
+		encoded[name] = base64.RawURLEncoding.EncodeToString(value.([]byte))
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(a.credentialID)
+	body, err := json.Marshal(map[string]any{
+		"id":       id,
+		"rawId":    id,
+		"type":     "public-key",
+		"response": encoded,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return body
+}
+
+// ceremonyOptions is what the browser reads from the options of a begin response
+type ceremonyOptions struct {
+	PublicKey struct {
+		Challenge protocol.URLEncodedBase64 `json:"challenge"`
+		User      struct {
+			ID protocol.URLEncodedBase64 `json:"id"`
+		} `json:"user"`
+		AuthenticatorSelection struct {
+			ResidentKey      string `json:"residentKey"`
+			UserVerification string `json:"userVerification"`
+		} `json:"authenticatorSelection"`
+		AllowCredentials []json.RawMessage `json:"allowCredentials"`
+		UserVerification string            `json:"userVerification"`
+	} `json:"publicKey"`
+}
+
+// fakeWebAuthnCredentials keeps credentials in memory
+type fakeWebAuthnCredentials struct {
+	credentials []*models.WebAuthnCredential
+}
+
+func (f *fakeWebAuthnCredentials) CreateCredential(credential *models.WebAuthnCredential) error {
+	credential.ID = len(f.credentials) + 1
+	f.credentials = append(f.credentials, credential)
+	return nil
+}
+
+func (f *fakeWebAuthnCredentials) GetCredentialsByUserID(userID int) ([]*models.WebAuthnCredential, error) {
+	var credentials []*models.WebAuthnCredential
+	for _, credential := range f.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	return credentials, nil
+}
+
+func (f *fakeWebAuthnCredentials) UpdateCredentialUsage(id int, signCount uint32, backupState bool) error {
+	for _, credential := range f.credentials {
+		if credential.ID == id {
+			credential.SignCount = signCount
+			credential.BackupState = backupState
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (f *fakeWebAuthnCredentials) DeleteCredential(id int, userID int) (bool, error) {
+	return false, nil
+}
+
+// fakeWebAuthnCeremonies keeps ceremonies in memory
+type fakeWebAuthnCeremonies struct {
+	ceremonies map[string]*models.WebAuthnCeremony
+}
+
+func (f *fakeWebAuthnCeremonies) CreateCeremony(ceremony *models.WebAuthnCeremony) error {
+	f.ceremonies[ceremony.TokenHash] = ceremony
+	return nil
+}
+
+func (f *fakeWebAuthnCeremonies) ConsumeCeremony(tokenHash string, ceremonyType string) (*models.WebAuthnCeremony, error) {
+	ceremony, ok := f.ceremonies[tokenHash]
+	if !ok || ceremony.CeremonyType != ceremonyType || time.Now().After(ceremony.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	delete(f.ceremonies, tokenHash)
+	return ceremony, nil
+}
+
+// fakeSessions keeps sessions in memory; it implements only what signing in uses
+type fakeSessions struct {
+	models.SessionRepository
+	sessions []*models.Session
+}
+
+func (f *fakeSessions) CreateSession(session *models.Session) error {
+	session.ID = len(f.sessions) + 1
+	f.sessions = append(f.sessions, session)
+	return nil
+}
+
+// fakeTokens keeps issued tokens in memory; it implements only what signing in uses
+type fakeTokens struct {
+	models.TokenRepository
+	tokens []*models.Token
+}
+
+func (f *fakeTokens) CreateToken(token *models.Token) error {
+	token.ID = len(f.tokens) + 1
+	f.tokens = append(f.tokens, token)
+	return nil
+}
+
+// fakeThrottles remembers which throttles were cleared; it implements only what signing in uses
+type fakeThrottles struct {
+	models.LoginThrottleRepository
+	cleared []string
+}
+
+func (f *fakeThrottles) ClearThrottle(keyType string, key string) error {
+	f.cleared = append(f.cleared, keyType+":"+key)
+	return nil
+}
+
+// webAuthnTest wires a WebAuthnService and the AuthService it signs in through to in-memory stores
+type webAuthnTest struct {
+	t           *testing.T
+	service     *WebAuthnService
+	users       *fakeUsers
+	credentials *fakeWebAuthnCredentials
+	sessions    *fakeSessions
+	throttles   *fakeThrottles
+	events      *fakeAuditEvents
+}
+
+func newWebAuthnTest(t *testing.T) *webAuthnTest {
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: "Website Builder",
+		RPOrigins:     []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := utils.GenerateSigningKey(utils.AlgorithmEdDSA, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := utils.NewKeySet()
+	keys.Replace([]*utils.SigningKey{key})
+
+	w := &webAuthnTest{
+		t:           t,
+		users:       &fakeUsers{},
+		credentials: &fakeWebAuthnCredentials{},
+		sessions:    &fakeSessions{},
+		throttles:   &fakeThrottles{},
+		events:      &fakeAuditEvents{},
+	}
+
+	auth := &AuthService{
+		store:         &fakeTokens{},
+		sessionStore:  w.sessions,
+		authUtils:     utils.NewAuthUtils(utils.AuthConfig{Keys: keys, TokenExpiration: time.Hour, RefreshExpiration: time.Hour}),
+		eventStore:    w.events,
+		throttleStore: w.throttles,
+	}
+	ceremonies := &fakeWebAuthnCeremonies{ceremonies: make(map[string]*models.WebAuthnCeremony)}
+	w.service = NewWebAuthnService(webAuthn, w.credentials, ceremonies, w.users, auth)
+
+	return w
+}
+
+// call runs a handler with a JSON body, as the given user if any
+func (w *webAuthnTest) call(handler http.HandlerFunc, principal *rbac.Principal, body any) *httptest.ResponseRecorder {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		w.t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+	if principal != nil {
+		r = r.WithContext(rbac.WithPrincipal(r.Context(), principal))
+	}
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, r)
+	return recorder
+}
+
+// begin starts a ceremony and returns its token and options
+func (w *webAuthnTest) begin(handler http.HandlerFunc, principal *rbac.Principal) (string, *ceremonyOptions) {
+	recorder := w.call(handler, principal, nil)
+	if recorder.Code != http.StatusOK {
+		w.t.Fatalf("begin: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		CeremonyToken string          `json:"ceremony_token"`
+		Options       ceremonyOptions `json:"options"`
+	}
+	err := json.NewDecoder(recorder.Body).Decode(&response)
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	return response.CeremonyToken, &response.Options
+}
+
+// register registers the authenticator's credential for the user through the handlers
+func (w *webAuthnTest) register(authenticator *softwareAuthenticator, user *models.User) *httptest.ResponseRecorder {
+	principal := &rbac.Principal{UserID: user.ID}
+	token, options := w.begin(w.service.BeginRegistration, principal)
+
+	return w.call(w.service.FinishRegistration, principal, WebAuthnFinishRequest{
+		CeremonyToken: token,
+		Name:          "Laptop",
+		Credential:    authenticator.create(options),
+	})
+}
+
+// login runs a passkey login with the authenticator through the handlers
+func (w *webAuthnTest) login(authenticator *softwareAuthenticator) *httptest.ResponseRecorder {
+	token, options := w.begin(w.service.BeginLogin, nil)
+
+	return w.call(w.service.FinishLogin, nil, WebAuthnFinishRequest{
+		CeremonyToken: token,
+		Credential:    authenticator.get(options),
+	})
+}
+
+// newUser adds a user to the in-memory store
+func (w *webAuthnTest) newUser(email string) *models.User {
+	user := &models.User{Email: email, Username: "ada", EmailVerified: true}
+	w.users.CreateUser(user)
+	return user
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	w := newWebAuthnTest(t)
+	user := w.newUser("ada@example.com")
+	authenticator := newSoftwareAuthenticator(t)
+
+	recorder := w.register(authenticator, user)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	stored := w.credentials.credentials[0]
+	if stored.UserID != user.ID || !bytes.Equal(stored.CredentialID, authenticator.credentialID) {
+		t.Fatalf("stored credential = %+v", stored)
+	}
+	if !bytes.Equal(authenticator.userHandle, webAuthnUserHandle(user.ID)) {
+		t.Fatalf("user handle = %x", authenticator.userHandle)
+	}
+
+	recorder = w.login(authenticator)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response TokenResponse
+	json.NewDecoder(recorder.Body).Decode(&response)
+	if response.AccessToken == "" || response.RefreshToken == "" {
+		t.Fatalf("login response = %+v", response)
+	}
+	if len(w.sessions.sessions) != 1 || w.sessions.sessions[0].UserID != user.ID {
+		t.Fatalf("sessions = %+v", w.sessions.sessions)
+	}
+	if stored.SignCount != 1 {
+		t.Fatalf("sign count = %d", stored.SignCount)
+	}
+	// Signing in goes through the regular path, which forgets failed logins
+	if len(w.throttles.cleared) != 1 {
+		t.Fatalf("cleared throttles = %v", w.throttles.cleared)
+	}
+}
+
+func TestWebAuthnCeremoniesRequireUserVerification(t *testing.T) {
+	w := newWebAuthnTest(t)
+	user := w.newUser("ada@example.com")
+
+	_, registration := w.begin(w.service.BeginRegistration, &rbac.Principal{UserID: user.ID})
+	selection := registration.PublicKey.AuthenticatorSelection
+	if selection.UserVerification != "required" || selection.ResidentKey != "required" {
+		t.Fatalf("registration selection = %+v", selection)
+	}
+
+	_, login := w.begin(w.service.BeginLogin, nil)
+	if login.PublicKey.UserVerification != "required" {
+		t.Fatalf("login user verification = %q", login.PublicKey.UserVerification)
+	}
+}
+
+func TestWebAuthnLoginOptionsRevealNoAccount(t *testing.T) {
+	w := newWebAuthnTest(t)
+	w.register(newSoftwareAuthenticator(t), w.newUser("ada@example.com"))
+
+	_, options := w.begin(w.service.BeginLogin, nil)
+	if len(options.PublicKey.AllowCredentials) != 0 || len(options.PublicKey.User.ID) != 0 {
+		t.Fatalf("discoverable login names an account: %+v", options.PublicKey)
+	}
+}
+
+func TestWebAuthnLoginRejectsUnverifiedUser(t *testing.T) {
+	w := newWebAuthnTest(t)
+	authenticator := newSoftwareAuthenticator(t)
+	w.register(authenticator, w.newUser("ada@example.com"))
+
+	// Someone holding the key without knowing its PIN only has one factor
+	authenticator.verifiesUser = false
+
+	recorder := w.login(authenticator)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("login: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if len(w.sessions.sessions) != 0 {
+		t.Fatal("a session was created")
+	}
+}
+
+func TestWebAuthnRegistrationRejectsUnverifiedUser(t *testing.T) {
+	w := newWebAuthnTest(t)
+	authenticator := newSoftwareAuthenticator(t)
+	authenticator.verifiesUser = false
+
+	recorder := w.register(authenticator, w.newUser("ada@example.com"))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("register: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if len(w.credentials.credentials) != 0 {
+		t.Fatal("a credential was stored")
+	}
+}
+
+func TestWebAuthnLoginDetectsClonedAuthenticator(t *testing.T) {
+	w := newWebAuthnTest(t)
+	authenticator := newSoftwareAuthenticator(t)
+	w.register(authenticator, w.newUser("ada@example.com"))
+
+	// The stored counter is ahead of the authenticator, as if a copy of the key had been used
+	w.credentials.credentials[0].SignCount = 10
+
+	recorder := w.login(authenticator)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("login: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	var warned bool
+	for _, event := range w.events.events {
+		warned = warned || event.EventType == models.AuditEventWebAuthnCloneWarning
+	}
+	if !warned {
+		t.Fatal("expected a clone warning in the audit log")
+	}
+}
+
+func TestWebAuthnLoginRejectsUnknownUser(t *testing.T) {
+	w := newWebAuthnTest(t)
+	authenticator := newSoftwareAuthenticator(t)
+	w.register(authenticator, w.newUser("ada@example.com"))
+
+	// The account was deleted after the passkey was created
+	w.users.users = nil
+
+	recorder := w.login(authenticator)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("login: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestWebAuthnLoginCeremonyIsSingleUse(t *testing.T) {
+	w := newWebAuthnTest(t)
+	authenticator := newSoftwareAuthenticator(t)
+	w.register(authenticator, w.newUser("ada@example.com"))
+
+	token, options := w.begin(w.service.BeginLogin, nil)
+	for i, want := range []int{http.StatusOK, http.StatusBadRequest} {
+		recorder := w.call(w.service.FinishLogin, nil, WebAuthnFinishRequest{
+			CeremonyToken: token,
+			Credential:    authenticator.get(options),
+		})
+		if recorder.Code != want {
+			t.Fatalf("login %d: %d %s", i+1, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestUserIDFromHandle(t *testing.T) {
+	id, err := userIDFromHandle(webAuthnUserHandle(1234))
+	if err != nil || id != 1234 {
+		t.Fatalf("userIDFromHandle = %d, %v", id, err)
+	}
+
+	if _, err := userIDFromHandle([]byte("short")); err == nil {
+		t.Fatal("expected an error for a malformed handle")
+	}
+}
@@ -0,0 +1,582 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bercivarga/website-builder/internal/models"
+	"github.com/bercivarga/website-builder/internal/rbac"
+	"github.com/bercivarga/website-builder/internal/utils"
+)
+
+const workspaceInvitationTTL = 7 * 24 * time.Hour
+
+// WorkspaceService handles workspaces, their members and invitations
+type WorkspaceService struct {
+	store           *models.WorkspaceStore
+	invitationStore *models.WorkspaceInvitationStore
+	userStore       *models.UserStore
+	mail            *MailService
+	auth            *AuthService
+}
+
+// WorkspaceRequest carries the editable fields of a workspace
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+// MemberRoleRequest carries the new role of a workspace member
+type MemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// InvitationRequest describes who to invite to a workspace and with which role
+type InvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// TransferRequest names the member who becomes the new owner of a workspace
+type TransferRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// NewWorkspaceService creates a new WorkspaceService with the given stores, mail service and AuthService
+func NewWorkspaceService(store *models.WorkspaceStore, invitationStore *models.WorkspaceInvitationStore, userStore *models.UserStore, mail *MailService, auth *AuthService) *WorkspaceService {
+	return &WorkspaceService{
+		store:           store,
+		invitationStore: invitationStore,
+		userStore:       userStore,
+		mail:            mail,
+		auth:            auth,
+	}
+}
+
+// RequireWorkspacePermission returns a middleware for routes under /{workspaceID}.
+// It only lets members whose workspace role grants the permission through and
+// adds their membership to the request context. Non-members get a 404 so
+// workspace IDs cannot be probed.
+func (s *WorkspaceService) RequireWorkspacePermission(permission rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := rbac.FromContext(r.Context())
+
+			workspaceID, err := strconv.Atoi(r.PathValue("workspaceID"))
+			if err != nil {
+				http.Error(w, "Invalid workspace ID", http.StatusBadRequest)
+				return
+			}
+
+			member, err := s.store.GetMember(workspaceID, principal.UserID)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					http.Error(w, "Workspace not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+
+			membership := &rbac.Membership{
+				WorkspaceID: workspaceID,
+				Role:        rbac.Role(member.Role),
+			}
+
+			if !principal.CanIn(membership, permission) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(rbac.WithMembership(r.Context(), membership)))
+		})
+	}
+}
+
+// ListWorkspaces handles listing the workspaces of the current user
+func (s *WorkspaceService) ListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	workspaces, err := s.store.GetWorkspacesByUserID(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list workspaces", http.StatusInternalServerError)
+		return
+	}
+
+	if workspaces == nil {
+		workspaces = []*models.Workspace{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspaces)
+}
+
+// CreateWorkspace handles creating a workspace owned by the current user
+func (s *WorkspaceService) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	var req WorkspaceRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	workspace := &models.Workspace{Name: strings.TrimSpace(req.Name)}
+
+	err = s.store.CreateWorkspace(workspace, principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to create workspace", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workspace)
+}
+
+// GetWorkspace handles retrieving a workspace
+func (s *WorkspaceService) GetWorkspace(w http.ResponseWriter, r *http.Request) {
+	membership := rbac.MembershipFromContext(r.Context())
+
+	workspace, err := s.store.GetWorkspaceByID(membership.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+	workspace.Role = string(membership.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
+
+// UpdateWorkspace handles renaming a workspace
+func (s *WorkspaceService) UpdateWorkspace(w http.ResponseWriter, r *http.Request) {
+	membership := rbac.MembershipFromContext(r.Context())
+
+	var req WorkspaceRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	workspace, err := s.store.GetWorkspaceByID(membership.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+
+	workspace.Name = strings.TrimSpace(req.Name)
+	err = s.store.UpdateWorkspace(workspace)
+	if err != nil {
+		http.Error(w, "Failed to update workspace", http.StatusInternalServerError)
+		return
+	}
+	workspace.Role = string(membership.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
+
+// DeleteWorkspace handles deleting a workspace
+func (s *WorkspaceService) DeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	membership := rbac.MembershipFromContext(r.Context())
+
+	err := s.store.DeleteWorkspace(membership.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to delete workspace", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListMembers handles listing the members of a workspace
+func (s *WorkspaceService) ListMembers(w http.ResponseWriter, r *http.Request) {
+	membership := rbac.MembershipFromContext(r.Context())
+
+	members, err := s.store.GetMembers(membership.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to list members", http.StatusInternalServerError)
+		return
+	}
+
+	if members == nil {
+		members = []*models.WorkspaceMember{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// memberFromPath loads the member named by the {userID} path value
+func (s *WorkspaceService) memberFromPath(w http.ResponseWriter, r *http.Request, workspaceID int) (*models.WorkspaceMember, bool) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	member, err := s.store.GetMember(workspaceID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return member, true
+}
+
+// UpdateMember handles changing the role of a workspace member.
+// Roles below the caller's own can be handed out; ownership moves only by transfer.
+func (s *WorkspaceService) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	membership := rbac.MembershipFromContext(r.Context())
+
+	var req MemberRoleRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	role := rbac.Role(req.Role)
+	if !role.Valid() || role == rbac.RoleOwner {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	member, ok := s.memberFromPath(w, r, membership.WorkspaceID)
+	if !ok {
+		return
+	}
+
+	current := rbac.Role(member.Role)
+	if current == rbac.RoleOwner || !membership.Role.CanManage(current) || !membership.Role.CanManage(role) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	err = s.store.UpdateMemberRole(membership.WorkspaceID, member.UserID, string(role))
+	if err != nil {
+		http.Error(w, "Failed to update member", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, member.UserID, models.AuditEventRoleChanged, map[string]any{
+		"old_role": member.Role,
+		"new_role": string(role),
+	})
+	member.Role = string(role)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+// RemoveMember handles removing a member from a workspace.
+// Members may always leave on their own, except the owner, who has to transfer ownership first.
+func (s *WorkspaceService) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+	membership := rbac.MembershipFromContext(r.Context())
+
+	member, ok := s.memberFromPath(w, r, membership.WorkspaceID)
+	if !ok {
+		return
+	}
+
+	role := rbac.Role(member.Role)
+	if role == rbac.RoleOwner {
+		http.Error(w, "Transfer ownership before leaving the workspace", http.StatusConflict)
+		return
+	}
+
+	leaving := member.UserID == principal.UserID
+	if !leaving && (!principal.CanIn(membership, rbac.PermissionMembersManage) || !membership.Role.CanManage(role)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	err := s.store.RemoveMember(membership.WorkspaceID, member.UserID)
+	if err != nil {
+		http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, member.UserID, models.AuditEventMemberRemoved, map[string]any{
+		"role": member.Role,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TransferOwnership handles the owner handing a workspace to another member.
+// The previous owner stays on as an admin.
+func (s *WorkspaceService) TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+	membership := rbac.MembershipFromContext(r.Context())
+
+	var req TransferRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == principal.UserID {
+		http.Error(w, "You already own this workspace", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.TransferOwnership(membership.WorkspaceID, principal.UserID, req.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+		return
+	}
+
+	s.auth.recordAuditEvent(r, req.UserID, models.AuditEventOwnershipTransferred, map[string]any{
+		"previous_owner_id": principal.UserID,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Ownership transferred successfully"))
+}
+
+// ListInvitations handles listing the pending invitations of a workspace
+func (s *WorkspaceService) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	membership := rbac.MembershipFromContext(r.Context())
+
+	invitations, err := s.invitationStore.GetInvitationsByWorkspaceID(membership.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to list invitations", http.StatusInternalServerError)
+		return
+	}
+
+	if invitations == nil {
+		invitations = []*models.WorkspaceInvitation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// CreateInvitation handles inviting an email address to a workspace.
+// Inviting the same address again replaces the pending invitation.
+func (s *WorkspaceService) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+	membership := rbac.MembershipFromContext(r.Context())
+
+	var req InvitationRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email == "" || !strings.Contains(email, "@") {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+
+	role := rbac.Role(req.Role)
+	if !role.Valid() || role == rbac.RoleOwner {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	if !membership.Role.CanManage(role) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if invitee, err := s.userStore.GetUserByEmail(email); err == nil {
+		if _, err := s.store.GetMember(membership.WorkspaceID, invitee.ID); err == nil {
+			http.Error(w, "User is already a member", http.StatusConflict)
+			return
+		}
+	}
+
+	workspace, err := s.store.GetWorkspaceByID(membership.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+
+	token, err := utils.GenerateTokenID()
+	if err != nil {
+		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+		return
+	}
+
+	invitation := &models.WorkspaceInvitation{
+		WorkspaceID: membership.WorkspaceID,
+		Email:       email,
+		Role:        string(role),
+		TokenHash:   utils.HashToken(token),
+		InvitedBy:   &principal.UserID,
+		ExpiresAt:   time.Now().Add(workspaceInvitationTTL),
+	}
+
+	err = s.invitationStore.CreateInvitation(invitation)
+	if err != nil {
+		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.mail.SendWorkspaceInvitationEmail(email, workspace.Name, principal.Email, token)
+	if err != nil {
+		http.Error(w, "Failed to send invitation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// RevokeInvitation handles withdrawing a pending invitation
+func (s *WorkspaceService) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	membership := rbac.MembershipFromContext(r.Context())
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.invitationStore.DeleteInvitation(id, membership.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Failed to revoke invitation", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvitation handles the current user joining a workspace with an invitation token.
+// The invitation only works for the account with the invited email address.
+func (s *WorkspaceService) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	principal := rbac.FromContext(r.Context())
+
+	var req TokenRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokenHash := utils.HashToken(req.Token)
+
+	invitation, err := s.invitationStore.GetInvitationByTokenHash(tokenHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invalid or expired invitation", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if !strings.EqualFold(invitation.Email, principal.Email) {
+		http.Error(w, "This invitation was sent to a different email address", http.StatusForbidden)
+		return
+	}
+
+	// Consuming the invitation makes it single-use
+	invitation, err = s.invitationStore.ConsumeInvitation(tokenHash)
+	if err != nil {
+		http.Error(w, "Invalid or expired invitation", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.AddMember(invitation.WorkspaceID, principal.UserID, invitation.Role)
+	if err != nil {
+		http.Error(w, "Failed to join workspace", http.StatusInternalServerError)
+		return
+	}
+
+	r = r.WithContext(rbac.WithMembership(r.Context(), &rbac.Membership{
+		WorkspaceID: invitation.WorkspaceID,
+		Role:        rbac.Role(invitation.Role),
+	}))
+	s.auth.recordAuditEvent(r, principal.UserID, models.AuditEventMemberJoined, map[string]any{
+		"role":          invitation.Role,
+		"invitation_id": invitation.ID,
+	})
+
+	workspace, err := s.store.GetWorkspaceByID(invitation.WorkspaceID)
+	if err != nil {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+
+	member, err := s.store.GetMember(invitation.WorkspaceID, principal.UserID)
+	if err == nil {
+		workspace.Role = member.Role
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workspace)
+}
+
+// DeclineInvitation handles turning down an invitation.
+// Holding the token is enough, so people without an account can decline too.
+func (s *WorkspaceService) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+
+	// Parse JSON body
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	_, err = s.invitationStore.ConsumeInvitation(utils.HashToken(req.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invalid or expired invitation", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Invitation declined"))
+}
@@ -2,8 +2,11 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -11,20 +14,28 @@ import (
 
 // AuthConfig holds the configuration for JWT authentication
 type AuthConfig struct {
-	SecretKey         string
+	Keys              *KeySet
 	TokenExpiration   time.Duration
 	RefreshExpiration time.Duration
 }
 
 // Claims represents the JWT token claims
 type Claims struct {
-	UserID  int    `json:"user_id"`
-	Email   string `json:"email"`
-	TokenID string `json:"token_id"` // Unique identifier for the token
-	Type    string `json:"type"`     // "access" or "refresh"
+	UserID    int    `json:"user_id"`
+	SessionID int    `json:"session_id"`
+	Email     string `json:"email"`
+	TokenID   string `json:"token_id"` // Unique identifier for the token
+	Type      string `json:"type"`     // "access", "refresh" or a single-use action such as "email_verification"
+	Actor     *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Actor identifies who really acts with an impersonation token, after the act claim of RFC 8693
+type Actor struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+}
+
 // AuthUtils provides JWT authentication utilities
 type AuthUtils struct {
 	config AuthConfig
@@ -55,7 +66,7 @@ func GenerateTokenID() (string, error) {
 }
 
 // GenerateAccessToken creates a new JWT access token
-func (au *AuthUtils) GenerateAccessToken(userID int, email string) (string, string, time.Time, error) {
+func (au *AuthUtils) GenerateAccessToken(userID int, email string, sessionID int) (string, string, time.Time, error) {
 	tokenID, err := GenerateTokenID()
 	if err != nil {
 		return "", "", time.Time{}, err
@@ -64,10 +75,11 @@ func (au *AuthUtils) GenerateAccessToken(userID int, email string) (string, stri
 	expiresAt := time.Now().Add(au.config.TokenExpiration)
 
 	claims := &Claims{
-		UserID:  userID,
-		Email:   email,
-		TokenID: tokenID,
-		Type:    "access",
+		UserID:    userID,
+		SessionID: sessionID,
+		Email:     email,
+		TokenID:   tokenID,
+		Type:      "access",
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -75,8 +87,7 @@ func (au *AuthUtils) GenerateAccessToken(userID int, email string) (string, stri
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(au.config.SecretKey))
+	signedToken, err := au.sign(claims)
 	if err != nil {
 		return "", "", time.Time{}, err
 	}
@@ -85,7 +96,7 @@ func (au *AuthUtils) GenerateAccessToken(userID int, email string) (string, stri
 }
 
 // GenerateRefreshToken creates a new JWT refresh token
-func (au *AuthUtils) GenerateRefreshToken(userID int, email string) (string, string, time.Time, error) {
+func (au *AuthUtils) GenerateRefreshToken(userID int, email string, sessionID int) (string, string, time.Time, error) {
 	tokenID, err := GenerateTokenID()
 	if err != nil {
 		return "", "", time.Time{}, err
@@ -93,11 +104,74 @@ func (au *AuthUtils) GenerateRefreshToken(userID int, email string) (string, str
 
 	expiresAt := time.Now().Add(au.config.RefreshExpiration)
 
+	claims := &Claims{
+		UserID:    userID,
+		SessionID: sessionID,
+		Email:     email,
+		TokenID:   tokenID,
+		Type:      "refresh",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	signedToken, err := au.sign(claims)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	return signedToken, tokenID, expiresAt, nil
+}
+
+// GenerateImpersonationToken creates a short-lived access token for userID that the actor uses.
+// It belongs to the actor's session, so it ends at the latest with that session.
+func (au *AuthUtils) GenerateImpersonationToken(userID int, email string, sessionID int, actor Actor, ttl time.Duration) (string, string, time.Time, error) {
+	tokenID, err := GenerateTokenID()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+
+	claims := &Claims{
+		UserID:    userID,
+		SessionID: sessionID,
+		Email:     email,
+		TokenID:   tokenID,
+		Type:      "impersonation",
+		Actor:     &actor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	signedToken, err := au.sign(claims)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	return signedToken, tokenID, expiresAt, nil
+}
+
+// GenerateActionToken creates a short-lived JWT for a single-use action such as verifying an email.
+// Only a hash of the returned token ID should be stored, see HashToken.
+func (au *AuthUtils) GenerateActionToken(userID int, email string, tokenType string, ttl time.Duration) (string, string, time.Time, error) {
+	tokenID, err := GenerateTokenID()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+
 	claims := &Claims{
 		UserID:  userID,
 		Email:   email,
 		TokenID: tokenID,
-		Type:    "refresh",
+		Type:    tokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -105,8 +179,7 @@ func (au *AuthUtils) GenerateRefreshToken(userID int, email string) (string, str
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(au.config.SecretKey))
+	signedToken, err := au.sign(claims)
 	if err != nil {
 		return "", "", time.Time{}, err
 	}
@@ -114,16 +187,46 @@ func (au *AuthUtils) GenerateRefreshToken(userID int, email string) (string, str
 	return signedToken, tokenID, expiresAt, nil
 }
 
+// HashToken returns the hex encoded SHA-256 hash of a token, for storing single-use tokens at rest
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sign signs the claims with the current signing key and sets its kid in the header
+func (au *AuthUtils) sign(claims jwt.Claims) (string, error) {
+	key, err := au.config.Keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	method := jwt.GetSigningMethod(key.Algorithm)
+	if method == nil {
+		return "", errors.New("unsupported signing method")
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
+}
+
 // VerifyToken validates a JWT token and returns the claims
 func (au *AuthUtils) VerifyToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		key, err := au.config.Keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		// The key decides the algorithm, never the token header
+		if token.Method.Alg() != key.Algorithm {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(au.config.SecretKey), nil
-	})
+		return key.Private.Public(), nil
+	}, jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}))
 
 	if err != nil {
 		return nil, err
@@ -155,22 +258,19 @@ func ExtractTokenFromHeader(header string) (string, error) {
 	return header[len(bearerPrefix):], nil
 }
 
-// TODO: Implement a proper password hashing and comparison mechanism
+// APITokenPrefix marks API tokens so they can be told apart from JWTs and found by secret scanners
+const APITokenPrefix = "wbp_"
 
-// HashPassword creates a password hash (you should use this for storing passwords)
-func HashPassword(password string) (string, error) {
-	// Use a proper password hashing library like bcrypt
-	return password, nil // Replace with actual hashing implementation
+// GenerateAPIToken creates a new random API token secret
+func GenerateAPIToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return APITokenPrefix + base64.RawURLEncoding.EncodeToString(bytes), nil
 }
 
-// TODO: Implement a proper password comparison mechanism
-
-// ComparePasswords compares a password with its hash
-func ComparePasswords(password, hash string) error {
-	// Use a proper password hashing library like bcrypt
-	// This is just a placeholder
-	if password == hash {
-		return nil
-	}
-	return errors.New("password mismatch")
+// IsAPIToken reports whether a bearer token is an API token rather than a JWT
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
 }
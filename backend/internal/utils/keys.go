@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Supported JWT signing algorithms
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+// ErrNoSigningKey is returned when no key is active for signing
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// SigningKey is a private key used to sign JWTs, identified by its kid
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	Private     crypto.Signer
+	ActivatesAt time.Time
+}
+
+// KeySet holds the keys tokens are signed with and verified against.
+// It is safe for concurrent use and can be swapped out while serving requests.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys map[string]*SigningKey
+}
+
+// NewKeySet creates an empty KeySet
+func NewKeySet() *KeySet {
+	return &KeySet{keys: map[string]*SigningKey{}}
+}
+
+// Replace atomically swaps the keys in the set
+func (ks *KeySet) Replace(keys []*SigningKey) {
+	next := make(map[string]*SigningKey, len(keys))
+	for _, key := range keys {
+		next[key.ID] = key
+	}
+
+	ks.mu.Lock()
+	ks.keys = next
+	ks.mu.Unlock()
+}
+
+// SigningKey returns the most recently activated key.
+// Keys that are published but not active yet are only used for verification.
+func (ks *KeySet) SigningKey() (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := time.Now()
+	var current *SigningKey
+	for _, key := range ks.keys {
+		if key.ActivatesAt.After(now) {
+			continue
+		}
+		if current == nil || key.ActivatesAt.After(current.ActivatesAt) {
+			current = key
+		}
+	}
+
+	if current == nil {
+		return nil, ErrNoSigningKey
+	}
+	return current, nil
+}
+
+// VerificationKey returns the key with the given kid
+func (ks *KeySet) VerificationKey(kid string) (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// JWK is the public part of a signing key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, ordered by activation time
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	keys := make([]*SigningKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	ks.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ActivatesAt.Before(keys[j].ActivatesAt)
+	})
+
+	set := JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwk := JWK{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Algorithm,
+		}
+
+		switch pub := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// GenerateSigningKey creates a new random key for the given algorithm
+func GenerateSigningKey(algorithm string, activatesAt time.Time) (*SigningKey, error) {
+	var private crypto.Signer
+
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	case AlgorithmEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+
+	kid, err := GenerateTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:          kid,
+		Algorithm:   algorithm,
+		Private:     private,
+		ActivatesAt: activatesAt,
+	}, nil
+}
+
+// EncodePrivateKey encodes a private key as a PKCS #8 PEM block
+func EncodePrivateKey(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParsePrivateKey decodes a PKCS #8 PEM encoded private key
+func ParsePrivateKey(encoded string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+	return signer, nil
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIPKey is the context key of the client address resolved by TrustedProxies
+type clientIPKey struct{}
+
+// TrustedProxies are the networks of the reverse proxies in front of the server.
+// Only they are believed when they name the client in X-Forwarded-For.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", entry)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// trusts reports whether the address belongs to a trusted proxy
+func (p TrustedProxies) trusts(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP resolves the address of the client that made the request. Starting with the
+// peer that connected, it follows X-Forwarded-For from the right for as long as the
+// address at hand is a trusted proxy; entries further left could be made up by the client.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	ip := remoteIP(r)
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0 && p.trusts(ip); i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop.String()
+	}
+
+	return ip
+}
+
+// WithClientIP returns a copy of the context carrying the resolved client address
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the IP address of the client that made the request: the one resolved
+// for it by TrustedProxies if there is one, otherwise the peer that connected
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// remoteIP returns the IP address of the peer that connected
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
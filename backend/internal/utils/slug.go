@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MaxSlugLength is the longest slug accepted, the length limit of a DNS label
+const MaxSlugLength = 63
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ValidSlug reports whether s is a lowercase, hyphen-separated URL segment
+func ValidSlug(s string) bool {
+	return len(s) <= MaxSlugLength && slugPattern.MatchString(s)
+}
+
+// Slugify turns a name such as "My Portfolio!" into a slug such as "my-portfolio".
+// Runs of anything but ASCII letters and digits become a single hyphen.
+// It returns an empty string if nothing usable is left.
+func Slugify(name string) string {
+	var b strings.Builder
+	pendingHyphen := false
+
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if pendingHyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingHyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		pendingHyphen = true
+	}
+
+	slug := b.String()
+	if len(slug) > MaxSlugLength {
+		slug = strings.TrimRight(slug[:MaxSlugLength], "-")
+	}
+	return slug
+}
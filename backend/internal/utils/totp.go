@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters as understood by common authenticator apps (RFC 6238 defaults)
+const (
+	totpDigits     = 6
+	totpPeriod     = 30
+	totpSecretSize = 20
+	totpSkewSteps  = 1
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret creates a new random base32 encoded TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// TOTPURI builds the otpauth:// URI authenticator apps import, usually rendered as a QR code
+func TOTPURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TOTPCode computes the code of a secret for the time step containing t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	return totpCodeAt(secret, t.Unix()/totpPeriod)
+}
+
+// ValidateTOTP checks a code against the secret, allowing one step of clock drift.
+// It returns the matched time step so callers can reject codes that were already used.
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := t.Unix() / totpPeriod
+	for step := current - totpSkewSteps; step <= current+totpSkewSteps; step++ {
+		expected, err := totpCodeAt(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func totpCodeAt(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// GenerateRecoveryCodes creates n random one-time recovery codes formatted as xxxxx-xxxxx
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(base32NoPadding.EncodeToString(raw))[:10]
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode strips separators and case so codes can be typed loosely
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+-- Tokens issued before sessions existed cannot be tied to one, so revoke them
+DELETE FROM token;
+
+ALTER TABLE token ADD COLUMN session_id INT REFERENCES sessions(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_token_token ON token(token);
+CREATE INDEX IF NOT EXISTS idx_token_session_id ON token(session_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_token_session_id;
+DROP INDEX IF EXISTS idx_token_token;
+ALTER TABLE token DROP COLUMN IF EXISTS session_id;
+DROP TABLE IF EXISTS sessions;
+-- +goose StatementEnd
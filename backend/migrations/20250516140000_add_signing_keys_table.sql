@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(10) NOT NULL CHECK (algorithm IN ('RS256', 'EdDSA')),
+    private_key TEXT NOT NULL,
+    activates_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tokens signed with the old shared secret can no longer be verified
+DELETE FROM token;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS signing_keys;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE token DROP CONSTRAINT IF EXISTS token_token_type_check;
+ALTER TABLE token ADD CONSTRAINT token_token_type_check
+    CHECK (token_type IN ('access', 'refresh', 'email_verification'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM token WHERE token_type NOT IN ('access', 'refresh');
+ALTER TABLE token DROP CONSTRAINT IF EXISTS token_token_type_check;
+ALTER TABLE token ADD CONSTRAINT token_token_type_check
+    CHECK (token_type IN ('access', 'refresh'));
+
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified;
+-- +goose StatementEnd
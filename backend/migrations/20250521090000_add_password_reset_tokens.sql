@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE token DROP CONSTRAINT IF EXISTS token_token_type_check;
+ALTER TABLE token ADD CONSTRAINT token_token_type_check
+    CHECK (token_type IN ('access', 'refresh', 'email_verification', 'password_reset'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM token WHERE token_type = 'password_reset';
+ALTER TABLE token DROP CONSTRAINT IF EXISTS token_token_type_check;
+ALTER TABLE token ADD CONSTRAINT token_token_type_check
+    CHECK (token_type IN ('access', 'refresh', 'email_verification'));
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key_type VARCHAR(10) NOT NULL CHECK (key_type IN ('account', 'ip')),
+    key VARCHAR(255) NOT NULL,
+    failed_count INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (key_type, key)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
+DROP TABLE IF EXISTS login_throttles;
+-- +goose StatementEnd
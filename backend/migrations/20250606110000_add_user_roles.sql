@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'editor'
+    CHECK (role IN ('owner', 'admin', 'editor', 'viewer'));
+
+UPDATE users SET role = 'admin' WHERE is_admin;
+
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users SET is_admin = TRUE WHERE role IN ('owner', 'admin');
+
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+-- +goose StatementEnd
@@ -0,0 +1,67 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Audit events replace security events. There are no foreign keys on purpose:
+-- the log has to outlive deleted users and workspaces unchanged.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INT,
+    user_id INT,
+    workspace_id INT,
+    event_type VARCHAR(50) NOT NULL,
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO audit_events (actor_id, user_id, event_type, ip_address, user_agent, metadata, created_at)
+SELECT user_id, user_id, event_type, ip_address, user_agent, metadata, COALESCE(created_at, CURRENT_TIMESTAMP)
+FROM security_events
+ORDER BY id;
+
+DROP TABLE IF EXISTS security_events;
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events(user_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_workspace_id ON audit_events(workspace_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_event_type ON audit_events(event_type, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+
+-- The log is append-only
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS security_events (
+    id SERIAL PRIMARY KEY,
+    user_id INT,
+    event_type VARCHAR(50) NOT NULL,
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_security_events_user_id ON security_events(user_id);
+
+INSERT INTO security_events (user_id, event_type, ip_address, user_agent, metadata, created_at)
+SELECT user_id, event_type, ip_address, user_agent, metadata, created_at
+FROM audit_events
+WHERE user_id IS NULL OR user_id IN (SELECT id FROM users)
+ORDER BY id;
+
+DROP TABLE IF EXISTS audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN display_name VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '',
+    ADD COLUMN locale VARCHAR(35) NOT NULL DEFAULT 'en',
+    ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+ALTER TABLE token DROP CONSTRAINT IF EXISTS token_token_type_check;
+ALTER TABLE token ADD CONSTRAINT token_token_type_check
+    CHECK (token_type IN ('access', 'refresh', 'email_verification', 'password_reset', 'magic_link', 'impersonation', 'email_change'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM token WHERE token_type = 'email_change';
+ALTER TABLE token DROP CONSTRAINT IF EXISTS token_token_type_check;
+ALTER TABLE token ADD CONSTRAINT token_token_type_check
+    CHECK (token_type IN ('access', 'refresh', 'email_verification', 'password_reset', 'magic_link', 'impersonation'));
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS locale,
+    DROP COLUMN IF EXISTS avatar_url,
+    DROP COLUMN IF EXISTS display_name;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users(deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS data_exports (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'ready', 'failed')),
+    archive BYTEA,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports(user_id);
+CREATE INDEX IF NOT EXISTS idx_data_exports_status ON data_exports(status) WHERE status IN ('pending', 'processing');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS data_exports;
+DROP INDEX IF EXISTS idx_users_deletion_scheduled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
+-- +goose StatementEnd
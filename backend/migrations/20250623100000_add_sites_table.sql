@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS sites (
+    id SERIAL PRIMARY KEY,
+    workspace_id INT NOT NULL,
+    owner_id INT,
+    name VARCHAR(100) NOT NULL,
+    slug VARCHAR(63) UNIQUE NOT NULL,
+    default_locale VARCHAR(35) NOT NULL DEFAULT 'en',
+    settings JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sites_workspace_id ON sites(workspace_id);
+CREATE INDEX IF NOT EXISTS idx_sites_owner_id ON sites(owner_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS sites;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS pages (
+    id SERIAL PRIMARY KEY,
+    site_id INT NOT NULL,
+    parent_id INT,
+    title VARCHAR(200) NOT NULL,
+    slug VARCHAR(63) NOT NULL,
+    sort_order INT NOT NULL DEFAULT 0,
+    is_home BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (site_id, id),
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+    -- Keeps parents on the same site; top-level pages have no parent
+    FOREIGN KEY (site_id, parent_id) REFERENCES pages(site_id, id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_pages_parent_id ON pages(parent_id);
+
+-- Slugs are unique among siblings, so every page has a distinct path
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pages_sibling_slug ON pages(site_id, COALESCE(parent_id, 0), slug);
+
+-- At most one home page per site
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pages_home ON pages(site_id) WHERE is_home;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS pages;
+-- +goose StatementEnd
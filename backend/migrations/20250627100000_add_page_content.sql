@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Block document of the page, see internal/blocks
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS content JSONB NOT NULL DEFAULT '{"version": 1, "blocks": []}';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE pages DROP COLUMN IF EXISTS content;
+-- +goose StatementEnd
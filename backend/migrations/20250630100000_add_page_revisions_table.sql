@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS page_revisions (
+    id SERIAL PRIMARY KEY,
+    page_id INT NOT NULL,
+    author_id INT,
+    message VARCHAR(500) NOT NULL DEFAULT '',
+    content JSONB NOT NULL,
+    restored_from INT, -- The revision this one brought back, if any
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (page_id) REFERENCES pages(id) ON DELETE CASCADE,
+    FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_page_revisions_page_id ON page_revisions(page_id, id);
+
+-- Revisions never change. Only the author may be cleared when their account is deleted,
+-- and revisions go away with their page.
+CREATE OR REPLACE FUNCTION page_revisions_immutable() RETURNS trigger AS $$
+BEGIN
+    IF ROW(NEW.id, NEW.page_id, NEW.message, NEW.content, NEW.restored_from, NEW.created_at)
+        IS DISTINCT FROM ROW(OLD.id, OLD.page_id, OLD.message, OLD.content, OLD.restored_from, OLD.created_at)
+        OR (NEW.author_id IS NOT NULL AND NEW.author_id IS DISTINCT FROM OLD.author_id) THEN
+        RAISE EXCEPTION 'page revisions are immutable';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER page_revisions_no_update BEFORE UPDATE ON page_revisions
+    FOR EACH ROW EXECUTE FUNCTION page_revisions_immutable();
+
+-- Start the history of existing pages with their current content
+INSERT INTO page_revisions (page_id, message, content, created_at)
+SELECT id, 'Content before revision history', content, COALESCE(updated_at, CURRENT_TIMESTAMP)
+FROM pages
+ORDER BY id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS page_revisions;
+DROP FUNCTION IF EXISTS page_revisions_immutable();
+-- +goose StatementEnd
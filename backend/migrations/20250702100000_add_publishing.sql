@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The draft of a page is its content; the live version is one of its revisions
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS published_revision_id INT REFERENCES page_revisions(id);
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS published_at TIMESTAMP WITH TIME ZONE;
+
+-- Every change to what a site shows, with the full set of live pages afterwards
+CREATE TABLE IF NOT EXISTS site_publishes (
+    id SERIAL PRIMARY KEY,
+    site_id INT NOT NULL,
+    publisher_id INT,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('publish', 'rollback', 'unpublish')),
+    message VARCHAR(500) NOT NULL DEFAULT '',
+    rollback_of INT, -- The publish a rollback returned to
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+    FOREIGN KEY (publisher_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_site_publishes_site_id ON site_publishes(site_id, id);
+
+CREATE TABLE IF NOT EXISTS site_publish_pages (
+    publish_id INT NOT NULL,
+    page_id INT NOT NULL,
+    revision_id INT NOT NULL,
+    PRIMARY KEY (publish_id, page_id),
+    FOREIGN KEY (publish_id) REFERENCES site_publishes(id) ON DELETE CASCADE,
+    FOREIGN KEY (page_id) REFERENCES pages(id) ON DELETE CASCADE,
+    FOREIGN KEY (revision_id) REFERENCES page_revisions(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS site_publish_pages;
+DROP TABLE IF EXISTS site_publishes;
+ALTER TABLE pages DROP COLUMN IF EXISTS published_at;
+ALTER TABLE pages DROP COLUMN IF EXISTS published_revision_id;
+-- +goose StatementEnd
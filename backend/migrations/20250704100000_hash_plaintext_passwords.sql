@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+-- Accounts from before passwords were hashed still hold them in plaintext.
+-- Hash them with bcrypt, which logins verify and upgrade to the configured hasher.
+UPDATE users SET password_hash = crypt(password_hash, gen_salt('bf', 10))
+WHERE password_hash <> ''
+    AND password_hash NOT LIKE '$argon2id$%'
+    AND password_hash !~ '^\$2[aby]\$[0-9]{2}\$';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Hashed passwords cannot be turned back into plaintext
+SELECT 1;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Each publish keeps where its pages sat and what they were called, so renaming or
+-- moving a draft leaves the live site alone until the next publish
+ALTER TABLE site_publish_pages ADD COLUMN IF NOT EXISTS parent_id INT;
+ALTER TABLE site_publish_pages ADD COLUMN IF NOT EXISTS title VARCHAR(200) NOT NULL DEFAULT '';
+ALTER TABLE site_publish_pages ADD COLUMN IF NOT EXISTS slug VARCHAR(63) NOT NULL DEFAULT '';
+ALTER TABLE site_publish_pages ADD COLUMN IF NOT EXISTS sort_order INT NOT NULL DEFAULT 0;
+ALTER TABLE site_publish_pages ADD COLUMN IF NOT EXISTS is_home BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Earlier publishes only knew the revisions; the pages as they are now are the best guess
+UPDATE site_publish_pages pp
+SET parent_id = p.parent_id, title = p.title, slug = p.slug, sort_order = p.sort_order, is_home = p.is_home
+FROM pages p
+WHERE p.id = pp.page_id;
+
+CREATE INDEX IF NOT EXISTS idx_site_publish_pages_page_id ON site_publish_pages(page_id);
+
+-- Like the drafts, live pages have distinct paths and at most one home page
+CREATE UNIQUE INDEX IF NOT EXISTS idx_site_publish_pages_sibling_slug ON site_publish_pages(publish_id, COALESCE(parent_id, 0), slug);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_site_publish_pages_home ON site_publish_pages(publish_id) WHERE is_home;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_site_publish_pages_home;
+DROP INDEX IF EXISTS idx_site_publish_pages_sibling_slug;
+DROP INDEX IF EXISTS idx_site_publish_pages_page_id;
+ALTER TABLE site_publish_pages DROP COLUMN IF EXISTS is_home;
+ALTER TABLE site_publish_pages DROP COLUMN IF EXISTS sort_order;
+ALTER TABLE site_publish_pages DROP COLUMN IF EXISTS slug;
+ALTER TABLE site_publish_pages DROP COLUMN IF EXISTS title;
+ALTER TABLE site_publish_pages DROP COLUMN IF EXISTS parent_id;
+-- +goose StatementEnd
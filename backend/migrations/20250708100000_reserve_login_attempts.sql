@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Attempts are counted as failed before they are checked; this is the failure
+-- time to go back to when one of them turns out to succeed
+ALTER TABLE login_throttles ADD COLUMN IF NOT EXISTS previous_failed_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE login_throttles DROP COLUMN IF EXISTS previous_failed_at;
+-- +goose StatementEnd
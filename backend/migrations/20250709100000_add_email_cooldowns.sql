@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Emails sent on unauthenticated requests are throttled per user and kind of email
+ALTER TABLE login_throttles DROP CONSTRAINT IF EXISTS login_throttles_key_type_check;
+ALTER TABLE login_throttles ADD CONSTRAINT login_throttles_key_type_check CHECK (key_type IN ('account', 'ip', 'email'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM login_throttles WHERE key_type = 'email';
+ALTER TABLE login_throttles DROP CONSTRAINT IF EXISTS login_throttles_key_type_check;
+ALTER TABLE login_throttles ADD CONSTRAINT login_throttles_key_type_check CHECK (key_type IN ('account', 'ip'));
+-- +goose StatementEnd